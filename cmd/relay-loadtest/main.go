@@ -0,0 +1,207 @@
+// Command relay-loadtest exercises relay pairing with thousands of pending
+// sessions. It runs a relay in-process, parks hosts waiting for joiners,
+// hangs some of them up to check they are dropped promptly, then pairs the
+// rest and reports pairing latency.
+//
+// Each session holds two sockets on each side of the loopback, so raise the
+// open file limit first, e.g. "ulimit -n 65536".
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/client/transport"
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/server/auth"
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/server/relay"
+)
+
+type tokenValidator struct {
+	signer *auth.Signer
+}
+
+func (v *tokenValidator) Validate(token string) (*relay.TokenInfo, error) {
+	claims, err := v.signer.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	return &relay.TokenInfo{SessionID: claims.SessionID, Role: claims.Role, JoinerID: claims.JoinerID}, nil
+}
+
+func main() {
+	sessions := flag.Int("sessions", 2000, "Number of pending sessions")
+	hangup := flag.Float64("hangup", 0.5, "Fraction of waiting hosts that hang up before a joiner arrives")
+	parallel := flag.Int("parallel", 100, "Concurrent connection attempts")
+	flag.Parse()
+
+	log.SetOutput(io.Discard) // the relay logs every connection
+
+	signer := auth.NewSigner("loadtest")
+	r := relay.NewRelay(&tokenValidator{signer: signer}, time.Hour, time.Hour)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.HandleConnection(conn)
+		}
+	}()
+	addr := ln.Addr().String()
+
+	baseGoroutines := runtime.NumGoroutine()
+	fmt.Printf("Relay on %s, %d sessions\n\n", addr, *sessions)
+
+	// 1. Park every host
+	hosts := make([]*transport.RelayClient, *sessions)
+	start := time.Now()
+	err = forEach(*sessions, *parallel, func(i int) error {
+		token, _ := signer.CreateRelayToken(sessionID(i), "", "host", auth.Limits{}, time.Hour)
+		c := transport.NewRelayClient(addr, false)
+		if err := c.Connect(sessionID(i), token, "host"); err != nil {
+			return err
+		}
+		hosts[i] = c
+		return nil
+	})
+	if err != nil {
+		fatalf("Host connect failed: %v (raise the open file limit?)", err)
+	}
+	waitFor(func() bool { return len(r.Pending()) == *sessions }, 10*time.Second)
+	fmt.Printf("1. Parked %d hosts in %s\n", len(r.Pending()), time.Since(start).Round(time.Millisecond))
+	printResources(baseGoroutines)
+
+	// 2. Hang up some of them; the relay should notice without a joiner
+	dropped := int(float64(*sessions) * *hangup)
+	for i := 0; i < dropped; i++ {
+		hosts[i].Close()
+	}
+	start = time.Now()
+	want := *sessions - dropped
+	if !waitFor(func() bool { return len(r.Pending()) == want }, 10*time.Second) {
+		fatalf("Relay still holds %d pending hosts, want %d", len(r.Pending()), want)
+	}
+	fmt.Printf("2. Dropped %d hung-up hosts in %s\n", dropped, time.Since(start).Round(time.Millisecond))
+
+	// 3. Pair the rest and time how long each joiner takes to reach its host
+	latencies := make([]time.Duration, want)
+	start = time.Now()
+	err = forEach(want, *parallel, func(k int) error {
+		i := dropped + k
+		token, _ := signer.CreateJoinerRelayToken(sessionID(i), "", 1, auth.Limits{}, time.Hour)
+		c := transport.NewRelayClient(addr, false)
+		begin := time.Now()
+		if err := c.Connect(sessionID(i), token, "joiner"); err != nil {
+			return err
+		}
+		defer c.Close()
+
+		if err := protocol.WriteFrame(c.GetConn(), &protocol.Frame{Type: protocol.FrameData, Payload: []byte{1}}); err != nil {
+			return err
+		}
+		host := hosts[i].GetConn()
+		host.SetReadDeadline(time.Now().Add(10 * time.Second))
+		for {
+			f, err := protocol.ReadFrame(host)
+			if err != nil {
+				return fmt.Errorf("session %d: %w", i, err)
+			}
+			if f.Type == protocol.FrameData {
+				break
+			}
+		}
+		latencies[k] = time.Since(begin)
+		return nil
+	})
+	if err != nil {
+		fatalf("Pairing failed: %v", err)
+	}
+	fmt.Printf("3. Paired %d sessions in %s\n", want, time.Since(start).Round(time.Millisecond))
+
+	sort.Slice(latencies, func(a, b int) bool { return latencies[a] < latencies[b] })
+	if want > 0 {
+		fmt.Printf("   Join latency p50 %s | p99 %s | max %s\n",
+			latencies[want/2].Round(time.Microsecond),
+			latencies[want*99/100].Round(time.Microsecond),
+			latencies[want-1].Round(time.Microsecond))
+	}
+
+	for i := dropped; i < *sessions; i++ {
+		hosts[i].Close()
+	}
+	if !waitFor(func() bool { return len(r.Pending()) == 0 && r.ActiveSessions() == 0 }, 10*time.Second) {
+		fatalf("Relay did not release all sessions")
+	}
+	fmt.Println("4. All sessions released")
+	printResources(baseGoroutines)
+}
+
+func sessionID(i int) string {
+	return fmt.Sprintf("load-%06d", i)
+}
+
+// forEach runs fn for 0..n-1 with at most parallel calls at once
+func forEach(n, parallel int, fn func(i int) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, parallel)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			if err := fn(i); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// waitFor polls cond until it holds or timeout passes
+func waitFor(cond func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+func printResources(baseGoroutines int) {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	fmt.Printf("   Goroutines: %d | Heap: %.1f MB\n",
+		runtime.NumGoroutine()-baseGoroutines, float64(m.HeapAlloc)/(1<<20))
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
@@ -15,14 +18,22 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
+	"unicode"
 
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/capture"
 	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/client/bridge"
 	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/client/config"
 	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/client/transport"
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/joincode"
 	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/p2p"
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
 	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/server/auth"
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/server/cluster"
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/server/events"
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/server/proxyproto"
 	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/server/ratelimit"
 	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/server/relay"
 	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/server/session"
@@ -224,10 +235,18 @@ func main() {
 		runHost(os.Args[2:])
 	case "join":
 		runJoin(os.Args[2:])
+	case "spectate":
+		runSpectate(os.Args[2:])
 	case "status":
 		runStatus(os.Args[2:])
+	case "session":
+		runSession(os.Args[2:])
+	case "lobbies":
+		runLobbies(os.Args[2:])
 	case "diagnose":
 		runDiagnose(os.Args[2:])
+	case "admin":
+		runAdmin(os.Args[2:])
 	case "version":
 		fmt.Printf("sfo-helper version %s\n", version)
 	case "help", "-h", "--help":
@@ -266,12 +285,13 @@ func runInteractive() {
 ║  2. JOIN (P2P)     WebRTC/ICE - CGNAT-proof   ║
 ║  3. HOST (Relay)   Classic relay mode         ║
 ║  4. JOIN (Relay)   Classic relay mode         ║
-║  5. PLAY OFFLINE   Launch game only           ║
-║  6. Advanced       Manual options             ║
-║  7. Exit                                      ║
+║  5. LOBBIES        Browse public games        ║
+║  6. PLAY OFFLINE   Launch game only           ║
+║  7. Advanced       Manual options             ║
+║  8. Exit                                      ║
 ╚═══════════════════════════════════════════════╝
 `)
-		fmt.Print("Enter choice (1-7): ")
+		fmt.Print("Enter choice (1-8): ")
 
 		input, _ := reader.ReadString('\n')
 		input = strings.TrimSpace(input)
@@ -286,10 +306,12 @@ func runInteractive() {
 		case "4":
 			runLauncherJoin(reader, gameDir)
 		case "5":
-			runLauncherOffline(reader, gameDir)
+			runLobbyBrowser(reader)
 		case "6":
-			runAdvancedMenu(reader)
+			runLauncherOffline(reader, gameDir)
 		case "7":
+			runAdvancedMenu(reader)
+		case "8":
 			fmt.Println("Goodbye!")
 			return
 		default:
@@ -370,8 +392,9 @@ func runLauncherHost(reader *bufio.Reader, gameDir string) {
 	secret := fmt.Sprintf("auto-%d", time.Now().UnixNano())
 	signer := auth.NewSigner(secret)
 	limiter := ratelimit.NewMultiLimiter()
-	go runSignalingServer(ctx, 1628, store, signer, limiter, 15*time.Minute)
-	go runRelayServer(ctx, 1627, signer, 4*time.Hour)
+	rl := newRelay(signer, "", 0, 10*time.Minute, 30*time.Second)
+	go runSignalingServer(ctx, 1628, nil, nil, store, joincode.Default(), cluster.NewRegistry(), signer, limiter, auth.Limits{}, 15*time.Minute, rl)
+	go runRelayServer(ctx, 1627, nil, nil, rl)
 	time.Sleep(500 * time.Millisecond)
 
 	fmt.Println("Server started!")
@@ -383,6 +406,7 @@ func runLauncherHost(reader *bufio.Reader, gameDir string) {
 	sess, err := signaling.CreateSession()
 	if err != nil {
 		fmt.Printf("ERROR: Failed to create session: %v\n", err)
+		printErrorAdvice(err)
 		fmt.Println("\nPress Enter to return to menu...")
 		reader.ReadString('\n')
 		return
@@ -402,8 +426,11 @@ func runLauncherHost(reader *bufio.Reader, gameDir string) {
 	// Connect to relay as host
 	fmt.Println("Connecting to relay...")
 	relayClient := transport.NewRelayClient("localhost:1627", false)
+	relayClient.SetMultiplexed(true)
+	watchReconnects(relayClient)
 	if err := relayClient.Connect(sess.SessionID, sess.RelayToken, "host"); err != nil {
 		fmt.Printf("ERROR: Failed to connect to relay: %v\n", err)
+		printErrorAdvice(err)
 		fmt.Println("\nPress Enter to return to menu...")
 		reader.ReadString('\n')
 		return
@@ -454,15 +481,22 @@ func runLauncherHost(reader *bufio.Reader, gameDir string) {
 	}
 
 	// Connect bridge IMMEDIATELY - this is critical!
-	// Bridge must be connected before joiner arrives so relay forwarding works
+	// Bridge must be connected before joiners arrive so relay forwarding works
 	fmt.Println("[Connecting] Bridge to game and relay...")
 	activeBridge := bridge.NewBridge(gameAddr)
-	if err := activeBridge.ConnectRelay(relayClient.GetConn()); err != nil {
+	activeBridge.SetStateChangeCallback(func(state bridge.State) {
+		if state == bridge.StateConnected {
+			fmt.Printf("[Joined] Your friend connected at %s!\n", time.Now().Format("15:04:05"))
+		}
+	})
+	activeBridge.SetRelayVersion(relayClient.Version())
+	if err := activeBridge.ConnectRelayMux(relayClient.GetConn()); err != nil {
 		fmt.Printf("ERROR: Bridge connection failed: %v\n", err)
 		fmt.Println("\nPress Enter to return to menu...")
 		reader.ReadString('\n')
 		return
 	}
+	connectUDPChannel(activeBridge, relayClient, gameUDPAddr(gameAddr), sess.SessionID, sess.RelayToken, "host")
 
 	fmt.Println()
 	fmt.Println("╔═══════════════════════════════════════════════╗")
@@ -520,7 +554,7 @@ func runLauncherJoin(reader *bufio.Reader, gameDir string) {
 	}
 
 	fmt.Println("Your friend should have given you:")
-	fmt.Println("  1. A JOIN CODE (like SFO-X7K2)")
+	fmt.Println("  1. A JOIN CODE (like SFO-5EP-TBB)")
 	fmt.Println("  2. Their SERVER IP (like 192.168.1.5)")
 	fmt.Println()
 
@@ -555,7 +589,9 @@ func runLauncherJoin(reader *bufio.Reader, gameDir string) {
 	sess, err := signaling.JoinSession(code)
 	if err != nil {
 		fmt.Printf("ERROR: Failed to join session: %v\n", err)
-		fmt.Println("Check the code and IP address.")
+		if !printErrorAdvice(err) {
+			fmt.Println("Check the code and IP address.")
+		}
 		fmt.Println("\nPress Enter to return to menu...")
 		reader.ReadString('\n')
 		return
@@ -566,8 +602,10 @@ func runLauncherJoin(reader *bufio.Reader, gameDir string) {
 	// Connect to relay
 	fmt.Println("Connecting to relay...")
 	relayClient := transport.NewRelayClient(relayAddr, false)
+	watchReconnects(relayClient)
 	if err := relayClient.Connect(sess.SessionID, sess.RelayToken, "joiner"); err != nil {
 		fmt.Printf("ERROR: Failed to connect to relay: %v\n", err)
+		printErrorAdvice(err)
 		fmt.Println("\nPress Enter to return to menu...")
 		reader.ReadString('\n')
 		return
@@ -617,12 +655,14 @@ func runLauncherJoin(reader *bufio.Reader, gameDir string) {
 	// Connect bridge IMMEDIATELY
 	fmt.Println("[Connecting] Bridge to game and relay...")
 	activeBridge := bridge.NewBridge(gameAddr)
+	activeBridge.SetRelayVersion(relayClient.Version())
 	if err := activeBridge.ConnectRelay(relayClient.GetConn()); err != nil {
 		fmt.Printf("ERROR: Bridge connection failed: %v\n", err)
 		fmt.Println("\nPress Enter to return to menu...")
 		reader.ReadString('\n')
 		return
 	}
+	connectUDPChannel(activeBridge, relayClient, gameUDPAddr(gameAddr), sess.SessionID, sess.RelayToken, "joiner")
 
 	fmt.Println()
 	fmt.Println("╔═══════════════════════════════════════════════╗")
@@ -861,7 +901,7 @@ func runP2PJoin(reader *bufio.Reader, gameDir string) {
 	}
 
 	fmt.Println("Your friend should have given you:")
-	fmt.Println("  1. A JOIN CODE (like SFO-X7K2)")
+	fmt.Println("  1. A JOIN CODE (like SFO-5EP-TBB)")
 	fmt.Println("  2. Their SERVER IP (like 192.168.1.5)")
 	fmt.Println()
 
@@ -1104,8 +1144,9 @@ func runOneClickHost(reader *bufio.Reader) {
 			secret := fmt.Sprintf("auto-%d", time.Now().UnixNano())
 			signer := auth.NewSigner(secret)
 			limiter := ratelimit.NewMultiLimiter()
-			go runSignalingServer(ctx, 1628, store, signer, limiter, 15*time.Minute)
-			go runRelayServer(ctx, 1627, signer, 4*time.Hour)
+			rl := newRelay(signer, "", 0, 10*time.Minute, 30*time.Second)
+			go runSignalingServer(ctx, 1628, nil, nil, store, joincode.Default(), cluster.NewRegistry(), signer, limiter, auth.Limits{}, 15*time.Minute, rl)
+			go runRelayServer(ctx, 1627, nil, nil, rl)
 		}()
 		time.Sleep(500 * time.Millisecond) // Give server time to start
 		fmt.Println("Server started!")
@@ -1246,6 +1287,65 @@ func runQuickPlay(reader *bufio.Reader) {
 	reader.ReadString('\n')
 }
 
+// runLobbyBrowser lists the public games on a server and joins the one picked
+func runLobbyBrowser(reader *bufio.Reader) {
+	fmt.Println("\n═══ PUBLIC LOBBIES ═══")
+	fmt.Print("Server address [localhost]: ")
+	server, _ := reader.ReadString('\n')
+	server = strings.TrimSpace(server)
+	if server == "" {
+		server = "localhost"
+	}
+
+	signalURL := fmt.Sprintf("http://%s:1628", server)
+	relayAddr := fmt.Sprintf("%s:1627", server)
+	signaling := transport.NewSignalingClient(signalURL)
+
+	for {
+		fmt.Println()
+		lobbies, err := signaling.ListLobbies(transport.LobbyFilter{})
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			fmt.Println("\nPress Enter to return to menu...")
+			reader.ReadString('\n')
+			return
+		}
+		printLobbies(lobbies)
+
+		fmt.Print("\nNumber to join, R to refresh, Enter to go back: ")
+		input, _ := reader.ReadString('\n')
+		input = strings.TrimSpace(input)
+		if input == "" {
+			return
+		}
+		if strings.EqualFold(input, "r") {
+			continue
+		}
+		var n int
+		if _, err := fmt.Sscanf(input, "%d", &n); err != nil || n < 1 || n > len(lobbies) {
+			fmt.Println("Invalid choice.")
+			continue
+		}
+
+		fmt.Print("Game target address [127.0.0.1:1626]: ")
+		target, _ := reader.ReadString('\n')
+		target = strings.TrimSpace(target)
+		if target == "" {
+			target = "127.0.0.1:1626"
+		}
+
+		fmt.Printf("\n>>> JOINING %s - Connecting to host...\n", lobbyName(lobbies[n-1]))
+		runJoin([]string{"--code", lobbies[n-1].Code, "--signal", signalURL, "--relay", relayAddr, "--target", target, "--skip-wait"})
+
+		fmt.Println("\n══════════════════════════════════════════")
+		fmt.Println("Session ended.")
+		fmt.Println("══════════════════════════════════════════")
+		fmt.Println("\nPress Enter to return to menu...")
+		reader.ReadString('\n')
+		return
+	}
+}
+
 func runInteractiveHost(reader *bufio.Reader) {
 	fmt.Println("\n═══ HOST A GAME ═══")
 	fmt.Println("IMPORTANT: Start Street Fighter Online FIRST before continuing!")
@@ -1442,8 +1542,12 @@ Commands:
   server    Run signaling + relay servers (for hosting infrastructure)
   host      Create a session and wait for a joiner (player)
   join      Join an existing session with a code (player)
-  status    Show current connection status
+  spectate  Watch a session live with its code (if the host allows it)
+  status    Show the status of your hosted session
+  session   Cancel or extend your hosted session, kick joiners or get a new code
+  lobbies   List the public games you can join
   diagnose  Run connectivity diagnostics
+  admin     Inspect, terminate or drain relay sessions (server operators)
   version   Show version information
   help      Show this help message
 
@@ -1457,6 +1561,28 @@ Examples:
   # Join a game (player 2)
   sfo-helper join --code ABCD-EFGH-IJKL --signal http://myserver:1628 --relay myserver:1627
 
+  # Host a game that others may watch, and watch it
+  sfo-helper host --spectators --signal http://myserver:1628 --relay myserver:1627
+  sfo-helper spectate --code ABCD-EFGH-IJKL --signal http://myserver:1628 --relay myserver:1627
+
+  # Host a public game, then find it in the lobby browser
+  sfo-helper host --public --nickname Ryu --region eu --signal http://myserver:1628 --relay myserver:1627
+  sfo-helper lobbies --region eu --signal http://myserver:1628
+
+  # Kick the joiners of a session you host and give it a new code
+  sfo-helper session kick --session <session-id> --token <host-token> --signal http://myserver:1628
+  sfo-helper session new-code --session <session-id> --token <host-token> --signal http://myserver:1628
+
+  # Let others watch a session you host after it has started
+  sfo-helper session allow-spectators --session <session-id> --token <host-token> --signal http://myserver:1628
+
+  # List and terminate relay sessions
+  sfo-helper admin sessions list --admin http://127.0.0.1:1629 --token mytoken
+  sfo-helper admin sessions kill <session-id> --admin http://127.0.0.1:1629 --token mytoken
+
+  # Stop taking new games and shut down once current matches end
+  sfo-helper admin drain --admin http://127.0.0.1:1629 --token mytoken
+
 Use "sfo-helper <command> --help" for more information about a command.
 `)
 }
@@ -1525,14 +1651,40 @@ func runServer(args []string) {
 
 	signalingPort := fs.Int("signaling-port", 1628, "Signaling server port")
 	relayPort := fs.Int("relay-port", 1627, "Relay server port")
+	relayWSPort := fs.Int("relay-ws-port", 0, "Port for the relay's WebSocket endpoint, wss:// with --relay-tls (0 disables)")
 	secret := fs.String("secret", "changeme-in-production", "Shared secret for token signing")
 	sessionTTL := fs.Int("session-ttl", 15, "Session TTL in minutes")
-	maxSessionHours := fs.Int("max-session", 4, "Max session duration in hours")
+	codeFormat := fs.String("code-format", joincode.FormatBase32, "Join code format: base32 or words")
+	codeLength := fs.Int("code-length", 0, "Random characters, or words, in a join code (0 = 5 characters or 3 words)")
+	codeCheck := fs.Bool("code-check-digit", true, "Add a check character to base32 join codes so typos are caught")
+	sessionStore := fs.String("session-store", "", "File to keep sessions in so join codes survive a restart (empty keeps them in memory)")
+	maxSessionHours := fs.Int("max-session", 0, "Max session duration in hours; clients are warned 5 minutes before (0 = no limit)")
+	idleTimeout := fs.Int("idle-timeout", 10, "Minutes a session may relay no game traffic before it is closed (0 disables)")
+	resumeGrace := fs.Int("resume-grace", 30, "Seconds to hold a session open for a disconnected client to resume (0 disables)")
+	drainTimeout := fs.Int("drain-timeout", 600, "Seconds to wait for matches to finish after SIGTERM or an admin drain")
+	maxPlayers := fs.Int("max-players", session.DefaultMaxJoiners+1, "Max players per session, host included")
+	sessionRate := fs.Int("session-rate", 0, "Per-session bandwidth in KB/s for each direction (0 = unlimited)")
+	sessionQuota := fs.Int("session-quota", 0, "Per-session traffic quota in MB (0 = unlimited)")
+	maxSessions := fs.Int("max-sessions", 0, "Max sessions relaying traffic at once (0 = unlimited)")
+	maxPending := fs.Int("max-pending", 0, "Max relay clients waiting for their peer (0 = unlimited)")
+	maxConnsPerIP := fs.Int("max-conns-per-ip", 0, "Max simultaneous relay connections from one IP (0 = unlimited)")
+	relayTLS := fs.Bool("relay-tls", false, "Serve the relay over TLS")
+	relayCert := fs.String("relay-cert", "", "TLS certificate file for the relay (default: auto-generated self-signed)")
+	relayKey := fs.String("relay-key", "", "TLS key file for the relay")
+	adminAddr := fs.String("admin-addr", "", "Address for the relay admin API, e.g. 127.0.0.1:1629 (empty disables)")
+	adminToken := fs.String("admin-token", os.Getenv("SFO_ADMIN_TOKEN"), "Bearer token required by the admin API")
+	captureDir := fs.String("capture-dir", "captures", "Directory for session captures started through the admin API")
+	proxyFrom := fs.String("proxy-protocol-from", "", "Comma-separated CIDRs of load balancers allowed to send PROXY protocol headers (empty disables)")
+	trustedProxies := fs.String("trusted-proxies", "", "Comma-separated CIDRs of HTTP proxies whose X-Forwarded-For headers signaling trusts (empty trusts none)")
+	nodeID := fs.String("node-id", "", "ID of this relay in a relay cluster (empty runs a standalone relay)")
+	region := fs.String("region", "", "Region of this relay node, used to place sessions near players")
+	publicAddr := fs.String("public-addr", "", "Relay address handed to clients for this node: host:port or a ws:// or wss:// URL")
+	joinCluster := fs.String("join-cluster", "", "Signaling URL of the cluster this relay node reports to (runs the relay only)")
 
 	fs.Parse(args)
 
 	// Check if ports are already in use
-	sigInUse := checkPortInUse(*signalingPort)
+	sigInUse := *joinCluster == "" && checkPortInUse(*signalingPort)
 	relayInUse := checkPortInUse(*relayPort)
 
 	if sigInUse || relayInUse {
@@ -1558,41 +1710,248 @@ func runServer(args []string) {
 		log.Println("WARNING: Using default secret. Use --secret in production!")
 	}
 
+	if *adminAddr != "" && *adminToken == "" {
+		log.Fatalf("--admin-addr requires --admin-token")
+	}
+
+	if *nodeID != "" && *publicAddr == "" {
+		log.Fatalf("--node-id requires --public-addr")
+	}
+	if *joinCluster != "" && *nodeID == "" {
+		log.Fatalf("--join-cluster requires --node-id")
+	}
+
+	codes, err := joincode.New(joincode.Config{
+		Format:     *codeFormat,
+		Length:     *codeLength,
+		CheckDigit: *codeCheck,
+		Prefix:     "SFO",
+	})
+	if err != nil {
+		log.Fatalf("Join codes: %v", err)
+	}
+
+	proxyTrusted, err := proxyproto.ParseCIDRs(*proxyFrom)
+	if err != nil {
+		log.Fatalf("Invalid --proxy-protocol-from: %v", err)
+	}
+	if len(proxyTrusted) > 0 {
+		log.Printf("Accepting PROXY protocol headers from %s", *proxyFrom)
+	}
+	forwardTrusted, err := proxyproto.ParseCIDRs(*trustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid --trusted-proxies: %v", err)
+	}
+
+	var relayTLSConfig *tls.Config
+	var relayFingerprint string
+	if *relayTLS || *relayCert != "" {
+		cert, err := loadRelayCertificate(*relayCert, *relayKey)
+		if err != nil {
+			log.Fatalf("Relay TLS: %v", err)
+		}
+		relayTLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS13,
+		}
+		relayFingerprint = relay.Fingerprint(cert)
+	}
+
 	fmt.Printf(banner, version)
 	fmt.Println("Mode: SERVER")
-	fmt.Printf("Signaling port: %d\n", *signalingPort)
+	if *joinCluster == "" {
+		fmt.Printf("Signaling port: %d\n", *signalingPort)
+	}
 	fmt.Printf("Relay port: %d\n", *relayPort)
+	if *nodeID != "" {
+		fmt.Printf("Relay node: %s, clients connect to %s\n", *nodeID, *publicAddr)
+		if *region != "" {
+			fmt.Printf("Region: %s\n", *region)
+		}
+	}
+	if *joinCluster != "" {
+		fmt.Printf("Cluster signaling: %s\n", *joinCluster)
+	}
+	if *relayWSPort > 0 {
+		scheme := "ws"
+		if relayTLSConfig != nil {
+			scheme = "wss"
+		}
+		fmt.Printf("Relay WebSocket: %s://<server>:%d%s\n", scheme, *relayWSPort, relay.WebSocketPath)
+	}
+	if relayTLSConfig != nil {
+		fmt.Println("Relay TLS: enabled")
+		fmt.Printf("Relay certificate SHA-256: %s\n", relayFingerprint)
+		fmt.Println("Clients connect with:")
+		fmt.Printf("  --relay-tls --relay-pin %s\n", relayFingerprint)
+	}
+	if *maxSessionHours > 0 {
+		fmt.Printf("Max session: %d hours\n", *maxSessionHours)
+	}
+	if *idleTimeout > 0 {
+		fmt.Printf("Idle timeout: %d minutes\n", *idleTimeout)
+	}
+	if *sessionRate > 0 {
+		fmt.Printf("Session bandwidth: %d KB/s per direction\n", *sessionRate)
+	}
+	if *sessionQuota > 0 {
+		fmt.Printf("Session quota: %d MB\n", *sessionQuota)
+	}
+	if *maxSessions > 0 {
+		fmt.Printf("Max relayed sessions: %d\n", *maxSessions)
+	}
+	if *maxPending > 0 {
+		fmt.Printf("Max waiting clients: %d\n", *maxPending)
+	}
+	if *maxConnsPerIP > 0 {
+		fmt.Printf("Max connections per IP: %d\n", *maxConnsPerIP)
+	}
+	if *adminAddr != "" {
+		fmt.Printf("Admin API: %s\n", *adminAddr)
+	}
+	if *sessionStore != "" && *joinCluster == "" {
+		fmt.Printf("Session store: %s\n", *sessionStore)
+	}
 	fmt.Println()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Create shared components
+	store, err := openSessionStore(*sessionStore, time.Duration(*sessionTTL)*time.Minute, *maxPlayers-1, codes)
+	if err != nil {
+		log.Fatalf("Session store: %v", err)
+	}
+	defer store.Close()
+	signer := auth.NewSigner(*secret)
+	limiter := ratelimit.NewMultiLimiter()
+	limits := auth.Limits{
+		RateLimit: int64(*sessionRate) * 1024,
+		ByteQuota: int64(*sessionQuota) * 1024 * 1024,
+	}
+
+	r := newRelay(signer, *nodeID, time.Duration(*maxSessionHours)*time.Hour, time.Duration(*idleTimeout)*time.Minute, time.Duration(*resumeGrace)*time.Second)
+	r.SetAdmissionLimits(relay.AdmissionLimits{
+		MaxSessions:   *maxSessions,
+		MaxPending:    *maxPending,
+		MaxConnsPerIP: *maxConnsPerIP,
+	})
+	nodes := cluster.NewRegistry()
+	nodeStatus := func() cluster.Node {
+		return cluster.Node{
+			ID:          *nodeID,
+			Region:      *region,
+			Addr:        *publicAddr,
+			TLS:         relayTLSConfig != nil && !transport.IsWebSocketAddr(*publicAddr),
+			Fingerprint: relayFingerprint,
+			Sessions:    r.ActiveSessions() + len(r.Pending()),
+			Draining:    r.Draining(),
+		}
+	}
+
+	// Draining lets matches in progress finish before the servers stop
+	drainCh := make(chan struct{})
+	var drainOnce sync.Once
+	drain := func() { drainOnce.Do(func() { close(drainCh) }) }
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-drainCh:
+		}
+
+		timeout := time.Duration(*drainTimeout) * time.Second
+		r.Drain(timeout)
+		waitCtx, waitCancel := context.WithTimeout(ctx, timeout)
+		defer waitCancel()
+		if err := r.WaitIdle(waitCtx); err != nil {
+			log.Printf("Drain deadline reached, closing %d remaining sessions", r.ActiveSessions())
+		} else {
+			log.Printf("All sessions finished")
+		}
+		r.CloseAll("relay shutting down")
+		cancel()
+	}()
+
+	// SIGTERM drains; SIGINT, or a second SIGTERM, stops immediately
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		<-sigCh
-		fmt.Println("\nShutting down servers...")
-		cancel()
+		for sig := range sigCh {
+			if sig == syscall.SIGTERM && !r.Draining() {
+				fmt.Printf("\nDraining servers (up to %ds)...\n", *drainTimeout)
+				drain()
+				continue
+			}
+			fmt.Println("\nShutting down servers...")
+			cancel()
+			return
+		}
 	}()
 
-	// Create shared components
-	store := session.NewStore(time.Duration(*sessionTTL) * time.Minute)
-	signer := auth.NewSigner(*secret)
-	limiter := ratelimit.NewMultiLimiter()
+	if *joinCluster != "" {
+		// A cluster node leaves signaling to the server it reports to
+		nodeToken := func() (string, error) { return signer.CreateNodeToken(*nodeID, time.Minute) }
+		go cluster.RunHeartbeat(ctx, *joinCluster, nodeToken, nodeStatus, func(revoked []cluster.Revocation) {
+			for _, rev := range revoked {
+				if rev.JoinerID == 0 {
+					r.RevokeSession(rev.SessionID, rev.Until)
+					r.CloseSession(rev.SessionID, "cancelled by the host")
+					continue
+				}
+				r.RevokeJoiner(rev.SessionID, rev.JoinerID, rev.Until)
+				r.KickJoiner(rev.SessionID, rev.JoinerID, "kicked by the host")
+			}
+		})
+	} else {
+		go runSignalingServer(ctx, *signalingPort, proxyTrusted, forwardTrusted, store, codes, nodes, signer, limiter, limits, time.Duration(*sessionTTL)*time.Minute, r)
+	}
 
-	// Start signaling server
-	go runSignalingServer(ctx, *signalingPort, store, signer, limiter, time.Duration(*sessionTTL)*time.Minute)
+	if *nodeID != "" && *joinCluster == "" {
+		// The local relay is a node of the cluster this signaling server runs
+		go func() {
+			ticker := time.NewTicker(cluster.HeartbeatInterval)
+			defer ticker.Stop()
+			for {
+				nodes.Heartbeat(nodeStatus())
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
 
 	// Start relay server
-	go runRelayServer(ctx, *relayPort, signer, time.Duration(*maxSessionHours)*time.Hour)
+	go runRelayServer(ctx, *relayPort, proxyTrusted, relayTLSConfig, r)
+
+	if *relayWSPort > 0 {
+		go runRelayWebSocketServer(ctx, *relayWSPort, proxyTrusted, relayTLSConfig, r)
+	}
+
+	if *adminAddr != "" {
+		go runAdminServer(ctx, *adminAddr, *adminToken, *captureDir, r, drain)
+	}
 
 	<-ctx.Done()
 	fmt.Println("Servers stopped.")
 }
 
-func runSignalingServer(ctx context.Context, port int, store *session.Store, signer *auth.Signer, limiter *ratelimit.MultiLimiter, tokenTTL time.Duration) {
+func runSignalingServer(ctx context.Context, port int, proxyTrusted, forwardTrusted []*net.IPNet, store session.Backend, codes *joincode.Generator, nodes *cluster.Registry, signer *auth.Signer, limiter *ratelimit.MultiLimiter, limits auth.Limits, tokenTTL time.Duration, rl *relay.Relay) {
 	mux := http.NewServeMux()
 
+	// Session events come from signaling and from this server's relay;
+	// relay nodes elsewhere in a cluster don't report theirs
+	hub := events.NewHub()
+	rl.SetObserver(relayEvents{hub: hub})
+
+	// Rate limits key on the player's address, which only a trusted proxy
+	// may pass on in a header
+	clientIP := func(r *http.Request) string {
+		return proxyproto.ClientIP(r, forwardTrusted)
+	}
+
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
@@ -1600,156 +1959,735 @@ func runSignalingServer(ctx context.Context, port int, store *session.Store, sig
 
 	mux.HandleFunc("/session/create", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, protocol.CodeBadRequest, "Method not allowed")
+			return
+		}
+
+		if rl.Draining() {
+			writeError(w, http.StatusServiceUnavailable, protocol.CodeShuttingDown, "Server is restarting")
 			return
 		}
 
-		ip := getClientIP(r)
+		ip := clientIP(r)
 		if !limiter.AllowCreate(ip) {
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			writeError(w, http.StatusTooManyRequests, protocol.CodeRateLimited, "Rate limit exceeded")
+			return
+		}
+
+		var req struct {
+			Region          string        `json:"region"`
+			AllowSpectators bool          `json:"allowSpectators"`
+			Public          bool          `json:"public"`
+			Lobby           session.Lobby `json:"lobby"` // shown in the lobby browser if public
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, protocol.CodeBadRequest, "Invalid request")
 			return
 		}
 
+		// With a relay cluster, signaling picks the node; otherwise clients bring their own relay
+		var node cluster.Node
+		if !nodes.Empty() {
+			var err error
+			node, err = nodes.Pick(req.Region)
+			if err != nil {
+				writeError(w, http.StatusServiceUnavailable, protocol.CodeNoRelay, "No relay available, please try again later")
+				return
+			}
+		}
+
 		sess, err := store.Create()
 		if err != nil {
-			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, protocol.CodeInternal, "Failed to create session")
 			return
 		}
 
 		// Mark host as connected
 		store.SetHostConnected(sess.ID, true)
+		store.SetRelayNode(sess.ID, node.ID)
+		store.SetAllowSpectators(sess.ID, req.AllowSpectators)
+		if req.Public {
+			lobby := cleanLobby(req.Lobby)
+			if lobby.Region == "" {
+				lobby.Region = cleanLobbyField(req.Region, maxLobbyField)
+			}
+			store.SetLobby(sess.ID, &lobby)
+		}
+
+		relayToken, err := signer.CreateRelayToken(sess.ID, node.ID, "host", limits, tokenTTL)
+		if err != nil {
+			log.Printf("Failed to sign relay token for session %s: %v", sess.ID[:8], err)
+			store.Delete(sess.ID)
+			writeError(w, http.StatusInternalServerError, protocol.CodeInternal, "Failed to create session")
+			return
+		}
 
-		relayToken, _ := signer.CreateRelayToken(sess.ID, "host", tokenTTL)
+		resp := map[string]interface{}{
+			"sessionId":       sess.ID,
+			"code":            sess.Code,
+			"hostToken":       sess.HostToken,
+			"relayToken":      relayToken,
+			"expiresAt":       sess.ExpiresAt.Unix(),
+			"allowSpectators": req.AllowSpectators,
+			"public":          req.Public,
+		}
+		addRelayNode(resp, node)
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"sessionId":  sess.ID,
-			"code":       sess.Code,
-			"hostToken":  sess.HostToken,
-			"relayToken": relayToken,
-			"expiresAt":  sess.ExpiresAt.Unix(),
-		})
-		log.Printf("Created session %s with code %s", sess.ID[:8], sess.Code)
+		json.NewEncoder(w).Encode(resp)
+		if node.ID != "" {
+			log.Printf("Created session %s with code %s on relay node %s", sess.ID[:8], sess.Code, node.ID)
+		} else {
+			log.Printf("Created session %s with code %s", sess.ID[:8], sess.Code)
+		}
 	})
 
 	mux.HandleFunc("/session/join", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, protocol.CodeBadRequest, "Method not allowed")
 			return
 		}
 
-		ip := getClientIP(r)
+		ip := clientIP(r)
 		if !limiter.AllowJoin(ip) {
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			writeError(w, http.StatusTooManyRequests, protocol.CodeRateLimited, "Rate limit exceeded")
 			return
 		}
 
 		var req struct {
-			Code string `json:"code"`
+			Code   string `json:"code"`
+			Region string `json:"region"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, protocol.CodeBadRequest, "Invalid request")
 			return
 		}
 
-		code := strings.ToUpper(strings.TrimSpace(req.Code))
-		// Normalize code: accept "SFO-XXXX", "SFOXXXX", or just "XXXX"
-		code = strings.ReplaceAll(code, "-", "")
-		if strings.HasPrefix(code, "SFO") {
-			code = code[3:] // Remove SFO prefix
-		}
-		if len(code) == 4 {
-			code = fmt.Sprintf("SFO-%s", code)
+		code, err := codes.Normalize(req.Code)
+		if err != nil {
+			writeError(w, http.StatusNotFound, protocol.CodeInvalidCode, invalidCodeMessage(err))
+			return
 		}
 
-		sess, err := store.Join(code)
+		sess, joiner, err := store.Join(code)
+		if errors.Is(err, session.ErrSessionFull) {
+			writeError(w, http.StatusConflict, protocol.CodeSessionFull, "Session is full")
+			return
+		}
 		if err != nil {
-			http.Error(w, "Invalid or expired code", http.StatusNotFound)
+			writeError(w, http.StatusNotFound, protocol.CodeInvalidCode, "Invalid or expired code")
 			return
 		}
 
 		// Mark joiner as connected so host knows to connect bridge
 		store.SetJoinConnected(sess.ID, true)
+		hub.Publish(protocol.SessionEvent{Type: protocol.EventJoinerClaimed, SessionID: sess.ID, JoinerID: joiner.ID})
+
+		var home, node cluster.Node
+		if sess.RelayNode != "" {
+			// The host's relay is used even if it stopped heartbeating a moment ago
+			var ok bool
+			home, ok = nodes.Get(sess.RelayNode)
+			if !ok {
+				writeError(w, http.StatusServiceUnavailable, protocol.CodeRelayUnavailable, "Session's relay is unavailable")
+				return
+			}
+			node = home
+			// A joiner far from the host's relay uses a node in its own region, linked to the host's
+			if req.Region != "" && req.Region != home.Region {
+				if near, err := nodes.PickInRegion(req.Region); err == nil {
+					node = near
+				}
+			}
+		}
 
-		relayToken, _ := signer.CreateRelayToken(sess.ID, "joiner", tokenTTL)
+		var relayToken string
+		if node.ID != home.ID {
+			relayToken, err = signer.CreateLinkedJoinerRelayToken(sess.ID, node.ID, &auth.HomeNode{
+				ID:   home.ID,
+				Addr: home.Addr,
+				TLS:  home.TLS,
+				Pin:  home.Fingerprint,
+			}, joiner.ID, limits, tokenTTL)
+		} else {
+			relayToken, err = signer.CreateJoinerRelayToken(sess.ID, node.ID, joiner.ID, limits, tokenTTL)
+		}
+		if err != nil {
+			log.Printf("Failed to sign relay token for joiner %d of session %s: %v", joiner.ID, sess.ID[:8], err)
+			writeError(w, http.StatusInternalServerError, protocol.CodeInternal, "Failed to join session")
+			return
+		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
+		resp := map[string]interface{}{
 			"sessionId":     sess.ID,
-			"joinToken":     sess.JoinToken,
+			"joinerId":      joiner.ID,
+			"joinToken":     joiner.Token,
 			"relayToken":    relayToken,
 			"hostConnected": sess.HostConnected,
-		})
-		log.Printf("Joiner connected to session %s", sess.ID[:8])
+		}
+		addRelayNode(resp, node)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		if node.ID != home.ID {
+			log.Printf("Joiner %d connected to session %s on relay node %s, linked to %s", joiner.ID, sess.ID[:8], node.ID, home.ID)
+			return
+		}
+		log.Printf("Joiner %d connected to session %s", joiner.ID, sess.ID[:8])
 	})
 
-	mux.HandleFunc("/session/", func(w http.ResponseWriter, r *http.Request) {
-		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/session/"), "/")
-		if len(parts) < 2 || parts[1] != "status" {
-			http.Error(w, "Not found", http.StatusNotFound)
+	mux.HandleFunc("/session/spectate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, protocol.CodeBadRequest, "Method not allowed")
 			return
 		}
 
-		sess, ok := store.GetByID(parts[0])
-		if !ok {
-			http.Error(w, "Session not found", http.StatusNotFound)
+		ip := clientIP(r)
+		if !limiter.AllowJoin(ip) {
+			writeError(w, http.StatusTooManyRequests, protocol.CodeRateLimited, "Rate limit exceeded")
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"sessionId":     sess.ID,
-			"hostConnected": sess.HostConnected,
-			"joinConnected": sess.JoinConnected,
-			"expiresAt":     sess.ExpiresAt.Unix(),
-		})
-	})
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, protocol.CodeBadRequest, "Invalid request")
+			return
+		}
 
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		code, err := codes.Normalize(req.Code)
+		if err != nil {
+			writeError(w, http.StatusNotFound, protocol.CodeInvalidCode, invalidCodeMessage(err))
 			return
 		}
-		mux.ServeHTTP(w, r)
-	})
 
-	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: handler,
-	}
+		sess, spectator, err := store.Spectate(code)
+		if errors.Is(err, session.ErrSpectatorsDenied) {
+			writeError(w, http.StatusForbidden, protocol.CodeSpectatorsDenied, "The host does not allow spectators")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusNotFound, protocol.CodeInvalidCode, "Invalid or expired code")
+			return
+		}
 
-	go func() {
-		<-ctx.Done()
-		server.Shutdown(context.Background())
-	}()
+		// Spectators watch on the host's relay node, never a linked one
+		var node cluster.Node
+		if sess.RelayNode != "" {
+			var ok bool
+			node, ok = nodes.Get(sess.RelayNode)
+			if !ok {
+				writeError(w, http.StatusServiceUnavailable, protocol.CodeRelayUnavailable, "Session's relay is unavailable")
+				return
+			}
+		}
 
-	log.Printf("Signaling server listening on :%d", port)
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
-		log.Printf("Signaling server error: %v", err)
-	}
-}
+		relayToken, err := signer.CreateRelayToken(sess.ID, node.ID, "spectator", limits, tokenTTL)
+		if err != nil {
+			log.Printf("Failed to sign relay token for a spectator of session %s: %v", sess.ID[:8], err)
+			writeError(w, http.StatusInternalServerError, protocol.CodeInternal, "Failed to spectate session")
+			return
+		}
 
-func runRelayServer(ctx context.Context, port int, signer *auth.Signer, maxDuration time.Duration) {
-	validator := &tokenValidator{signer: signer}
-	r := relay.NewRelay(validator, 24*time.Hour, maxDuration) // Long timeout - wait for joiner indefinitely
+		resp := map[string]interface{}{
+			"sessionId":     sess.ID,
+			"spectatorId":   spectator.ID,
+			"relayToken":    relayToken,
+			"hostConnected": sess.HostConnected,
+		}
+		addRelayNode(resp, node)
 
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		log.Fatalf("Failed to start relay listener: %v", err)
-	}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		log.Printf("Spectator %d watching session %s", spectator.ID, sess.ID[:8])
+	})
 
-	go func() {
-		<-ctx.Done()
-		listener.Close()
-	}()
+	mux.HandleFunc(cluster.HeartbeatPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, protocol.CodeBadRequest, "Method not allowed")
+			return
+		}
 
-	log.Printf("Relay server listening on :%d", port)
+		var node cluster.Node
+		if err := json.NewDecoder(r.Body).Decode(&node); err != nil || node.ID == "" || node.Addr == "" {
+			writeError(w, http.StatusBadRequest, protocol.CodeBadRequest, "Invalid request")
+			return
+		}
 
-	for {
-		conn, err := listener.Accept()
+		// Nodes prove they share the cluster secret with a token for their own ID
+		claims, err := signer.Verify(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if err != nil || claims.Role != auth.NodeRole || claims.Node != node.ID {
+			writeError(w, http.StatusUnauthorized, protocol.CodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		if _, known := nodes.Get(node.ID); !known {
+			log.Printf("Relay node %s joined (%s, region %q)", node.ID, node.Addr, node.Region)
+		}
+		nodes.Heartbeat(node)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cluster.HeartbeatResponse{Revoked: nodes.Revoked()})
+	})
+
+	mux.HandleFunc("/lobbies", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, protocol.CodeBadRequest, "Method not allowed")
+			return
+		}
+
+		if !limiter.AllowList(clientIP(r)) {
+			writeError(w, http.StatusTooManyRequests, protocol.CodeRateLimited, "Rate limit exceeded")
+			return
+		}
+
+		q := r.URL.Query()
+		region, gameVersion := q.Get("region"), q.Get("version")
+		search := strings.ToLower(q.Get("q"))
+		includeFull := q.Get("full") == "1" || q.Get("full") == "true"
+
+		lobbies := make([]map[string]interface{}, 0)
+		for _, sess := range store.Lobbies() {
+			l := sess.Lobby
+			full := len(sess.Joiners) >= sess.MaxJoiners
+			switch {
+			case !sess.HostConnected, full && !includeFull:
+				continue
+			case region != "" && !strings.EqualFold(l.Region, region):
+				continue
+			case gameVersion != "" && !strings.EqualFold(l.GameVersion, gameVersion):
+				continue
+			case search != "" && !strings.Contains(strings.ToLower(l.Nickname+"\n"+l.Skill), search):
+				continue
+			}
+
+			lobbies = append(lobbies, map[string]interface{}{
+				"code":            sess.Code,
+				"nickname":        l.Nickname,
+				"region":          l.Region,
+				"skill":           l.Skill,
+				"gameVersion":     l.GameVersion,
+				"players":         len(sess.Joiners) + 1,
+				"maxPlayers":      sess.MaxJoiners + 1,
+				"allowSpectators": sess.AllowSpectators,
+				"createdAt":       sess.CreatedAt.Unix(),
+			})
+			if len(lobbies) == maxLobbies {
+				break
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"lobbies": lobbies})
+	})
+
+	// Managing a session is up to its host, who authenticates with the host
+	// token /session/create returned
+	mux.HandleFunc("/session/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/session/"), "/")
+		id, action := parts[0], ""
+		if len(parts) > 1 {
+			action = strings.Join(parts[1:], "/")
+		}
+
+		route := r.Method + " " + action
+		switch route {
+		case "GET status", "GET events", "DELETE ", "POST extend", "POST kick", "POST code", "POST spectators":
+		default:
+			writeError(w, http.StatusNotFound, protocol.CodeNotFound, "Not found")
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || !store.ValidateToken(id, token, "host") {
+			writeError(w, http.StatusUnauthorized, protocol.CodeUnauthorized, "Host token required")
+			return
+		}
+
+		switch route {
+		case "GET events":
+			serveSessionEvents(ctx, w, r, store, hub, rl, id)
+			return
+
+		case "DELETE ":
+			store.Delete(id)
+			hub.Publish(protocol.SessionEvent{Type: protocol.EventEnded, SessionID: id, Reason: "cancelled by the host"})
+			// The session may be on another node, and its tokens outlive it
+			until := time.Now().Add(tokenTTL)
+			rl.RevokeSession(id, until)
+			nodes.Revoke(cluster.Revocation{SessionID: id, Until: until})
+			rl.CloseSession(id, "cancelled by the host")
+			log.Printf("Session %s cancelled by its host", id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+
+		case "POST extend":
+			expires, err := store.Extend(id)
+			if err != nil {
+				writeError(w, http.StatusNotFound, protocol.CodeSessionNotFound, "Session not found")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"expiresAt": expires.Unix()})
+			return
+
+		case "POST kick":
+			var req struct {
+				JoinerID uint32 `json:"joinerId"` // zero kicks every joiner
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+				writeError(w, http.StatusBadRequest, protocol.CodeBadRequest, "Invalid request")
+				return
+			}
+			kicked, err := store.Kick(id, req.JoinerID)
+			if errors.Is(err, session.ErrJoinerNotFound) {
+				writeError(w, http.StatusNotFound, protocol.CodeJoinerNotFound, "No such joiner")
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusNotFound, protocol.CodeSessionNotFound, "Session not found")
+				return
+			}
+			// Their relay tokens stay valid until they expire, so every relay
+			// refuses them until then. Other nodes hear of it with their next
+			// heartbeat.
+			until := time.Now().Add(tokenTTL)
+			for _, jid := range kicked {
+				rl.RevokeJoiner(id, jid, until)
+				nodes.Revoke(cluster.Revocation{SessionID: id, JoinerID: jid, Until: until})
+			}
+			rl.KickJoiner(id, req.JoinerID, "kicked by the host")
+			if kicked == nil {
+				kicked = []uint32{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"kicked": kicked})
+			return
+
+		case "POST code":
+			code, err := store.RegenerateCode(id)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, protocol.CodeInternal, "Failed to generate a new code")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"code": code})
+			return
+
+		case "POST spectators":
+			var req struct {
+				Allow *bool `json:"allow"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Allow == nil {
+				writeError(w, http.StatusBadRequest, protocol.CodeBadRequest, "Invalid request")
+				return
+			}
+			if err := store.SetAllowSpectators(id, *req.Allow); err != nil {
+				writeError(w, http.StatusNotFound, protocol.CodeSessionNotFound, "Session not found")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"allowSpectators": *req.Allow})
+			return
+		}
+
+		sess, ok := store.GetByID(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, protocol.CodeSessionNotFound, "Session not found")
+			return
+		}
+
+		joiners := make([]map[string]interface{}, 0, len(sess.Joiners))
+		for _, j := range sess.Joiners {
+			joiners = append(joiners, map[string]interface{}{
+				"id":       j.ID,
+				"joinedAt": j.JoinedAt.Unix(),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sessionId":       sess.ID,
+			"code":            sess.Code,
+			"hostConnected":   sess.HostConnected,
+			"joinConnected":   sess.JoinConnected,
+			"joiners":         joiners,
+			"maxJoiners":      sess.MaxJoiners,
+			"allowSpectators": sess.AllowSpectators,
+			"spectators":      len(sess.Spectators),
+			"relayNode":       sess.RelayNode,
+			"createdAt":       sess.CreatedAt.Unix(),
+			"expiresAt":       sess.ExpiresAt.Unix(),
+		})
+	})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: handler,
+	}
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Printf("Signaling server error: %v", err)
+		return
+	}
+	if len(proxyTrusted) > 0 {
+		listener = proxyproto.NewListener(listener, proxyTrusted)
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	log.Printf("Signaling server listening on :%d", port)
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		log.Printf("Signaling server error: %v", err)
+	}
+}
+
+// Lobby browser limits
+const (
+	maxLobbies    = 100 // lobbies one listing returns
+	maxLobbyField = 32  // characters of each field a host sets
+	maxLobbySkill = 64  // characters of the host's skill note
+)
+
+// cleanLobby trims what a host sent for the lobby browser to printable text
+// of a bounded length
+func cleanLobby(l session.Lobby) session.Lobby {
+	return session.Lobby{
+		Nickname:    cleanLobbyField(l.Nickname, maxLobbyField),
+		Region:      cleanLobbyField(l.Region, maxLobbyField),
+		Skill:       cleanLobbyField(l.Skill, maxLobbySkill),
+		GameVersion: cleanLobbyField(l.GameVersion, maxLobbyField),
+	}
+}
+
+// cleanLobbyField drops unprintable characters from s and cuts it to max
+// characters
+func cleanLobbyField(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+	if r := []rune(s); len(r) > max {
+		s = strings.TrimSpace(string(r[:max]))
+	}
+	return s
+}
+
+// Session event stream timing
+const (
+	eventKeepalive = 15 * time.Second // comment sent to keep idle streams open through proxies
+	expiringNotice = time.Minute      // how long before a session expires its host is warned
+)
+
+// serveSessionEvents streams a session's events to its host as server-sent
+// events until the session ends, the host hangs up or the server stops.
+// The store drops expired sessions without a word, so their expiry is
+// watched here.
+func serveSessionEvents(ctx context.Context, w http.ResponseWriter, r *http.Request, store session.Backend, hub *events.Hub, rl *relay.Relay, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, protocol.CodeInternal, "Streaming unsupported")
+		return
+	}
+	if _, ok := store.GetByID(id); !ok {
+		writeError(w, http.StatusNotFound, protocol.CodeSessionNotFound, "Session not found")
+		return
+	}
+
+	ch, unsubscribe := hub.Subscribe(id)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	expiry := time.NewTimer(0)
+	defer expiry.Stop()
+	var warned int64 // expiry the host was last warned about
+
+	for {
+		var e protocol.SessionEvent
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			continue
+		case ev, ok := <-ch:
+			if !ok {
+				// Fell behind; the client reconnects and checks the status
+				return
+			}
+			e = ev
+		case <-expiry.C:
+			sess, ok := store.GetByID(id)
+			if !ok && rl.HasSession(id) {
+				// Only the join code is gone; the stream ends with the
+				// relay's own ended event
+				e = protocol.SessionEvent{Type: protocol.EventExpired, SessionID: id, Time: time.Now().Unix()}
+				break
+			}
+			if !ok {
+				e = protocol.SessionEvent{Type: protocol.EventEnded, SessionID: id, Reason: "expired", Time: time.Now().Unix()}
+				break
+			}
+			// The host may have extended the session since the timer was set
+			left := time.Until(sess.ExpiresAt)
+			if left > expiringNotice {
+				expiry.Reset(left - expiringNotice)
+				continue
+			}
+			expiry.Reset(left + time.Second)
+			if warned == sess.ExpiresAt.Unix() {
+				continue
+			}
+			warned = sess.ExpiresAt.Unix()
+			e = protocol.SessionEvent{Type: protocol.EventExpiring, SessionID: id, ExpiresAt: warned, Time: time.Now().Unix()}
+		}
+
+		data, _ := json.Marshal(e)
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+			return
+		}
+		flusher.Flush()
+		if e.Type == protocol.EventEnded {
+			return
+		}
+	}
+}
+
+// relayEvents passes the relay's session events on to signaling's event
+// streams
+type relayEvents struct {
+	hub *events.Hub
+}
+
+func (e relayEvents) ClientConnected(sessionID, role, channel string, joinerID uint32) {
+	t := protocol.EventHostRelayConnected
+	if role == "joiner" {
+		t = protocol.EventJoinerRelayConnected
+	}
+	e.hub.Publish(protocol.SessionEvent{Type: t, SessionID: sessionID, JoinerID: joinerID, Channel: channel})
+}
+
+func (e relayEvents) Paired(sessionID, channel string, joinerID uint32) {
+	e.hub.Publish(protocol.SessionEvent{Type: protocol.EventPaired, SessionID: sessionID, JoinerID: joinerID, Channel: channel})
+}
+
+func (e relayEvents) Expiring(sessionID string, remaining time.Duration) {
+	e.hub.Publish(protocol.SessionEvent{
+		Type:      protocol.EventExpiring,
+		SessionID: sessionID,
+		Reason:    "relay time limit",
+		ExpiresAt: time.Now().Add(remaining).Unix(),
+	})
+}
+
+func (e relayEvents) Ended(sessionID string) {
+	e.hub.Publish(protocol.SessionEvent{Type: protocol.EventEnded, SessionID: sessionID, Reason: "everyone left the relay"})
+}
+
+// invalidCodeMessage explains why a join code a player typed was rejected
+func invalidCodeMessage(err error) string {
+	if errors.Is(err, joincode.ErrCheckDigit) {
+		return "Code has a typo, check it and try again"
+	}
+	return "Invalid or expired code"
+}
+
+// addRelayNode adds the assigned relay node to a create, join or spectate
+// response
+func addRelayNode(resp map[string]interface{}, node cluster.Node) {
+	if node.ID == "" {
+		return
+	}
+	resp["relayNode"] = node.ID
+	resp["relayAddr"] = node.Addr
+	resp["relayTLS"] = node.TLS
+	if node.Fingerprint != "" {
+		resp["relayPin"] = node.Fingerprint
+	}
+}
+
+// openSessionStore opens the session log at path, or an in-memory store if
+// path is empty, making join codes with codes
+func openSessionStore(path string, ttl time.Duration, maxJoiners int, codes *joincode.Generator) (session.Backend, error) {
+	if path == "" {
+		store := session.NewStore(ttl)
+		store.SetMaxJoiners(maxJoiners)
+		store.SetCodeGenerator(codes)
+		return store, nil
+	}
+	store, err := session.OpenFileStore(path, ttl)
+	if err != nil {
+		return nil, err
+	}
+	store.SetMaxJoiners(maxJoiners)
+	store.SetCodeGenerator(codes)
+	return store, nil
+}
+
+func newRelay(signer *auth.Signer, nodeID string, maxDuration, idleTimeout, resumeGrace time.Duration) *relay.Relay {
+	validator := &tokenValidator{signer: signer, nodeID: nodeID}
+	r := relay.NewRelay(validator, 24*time.Hour, maxDuration) // Long timeout - wait for joiner indefinitely; hosts that hang up are dropped at once
+	r.SetIdleTimeout(idleTimeout)
+	r.SetResumeGrace(resumeGrace)
+	if nodeID != "" {
+		r.SetUpstreamDialer(upstreamDialer{})
+	}
+	return r
+}
+
+func runRelayServer(ctx context.Context, port int, proxyTrusted []*net.IPNet, tlsConfig *tls.Config, r *relay.Relay) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		log.Fatalf("Failed to start relay listener: %v", err)
+	}
+	if len(proxyTrusted) > 0 {
+		// The load balancer's header comes before any TLS handshake
+		listener = proxyproto.NewListener(listener, proxyTrusted)
+	}
+	if tlsConfig != nil {
+		// The handshake runs on the first read, under HandleConnection's auth deadline
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	log.Printf("Relay server listening on :%d", port)
+
+	for {
+		conn, err := listener.Accept()
 		if err != nil {
 			select {
 			case <-ctx.Done():
@@ -1761,43 +2699,482 @@ func runRelayServer(ctx context.Context, port int, signer *auth.Signer, maxDurat
 		}
 		go r.HandleConnection(conn)
 	}
-}
+}
+
+// runRelayWebSocketServer serves the relay over WebSocket, for players whose
+// networks only allow HTTP(S)
+func runRelayWebSocketServer(ctx context.Context, port int, proxyTrusted []*net.IPNet, tlsConfig *tls.Config, r *relay.Relay) {
+	mux := http.NewServeMux()
+	mux.Handle(relay.WebSocketPath, r.WebSocketHandler())
+
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Printf("Relay WebSocket server error: %v", err)
+		return
+	}
+	if len(proxyTrusted) > 0 {
+		listener = proxyproto.NewListener(listener, proxyTrusted)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Relay WebSocket server listening on :%d", port)
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		log.Printf("Relay WebSocket server error: %v", err)
+	}
+}
+
+// runAdminServer serves the relay admin API. Every request must carry the
+// admin token as a bearer token. Session captures are written to captureDir.
+func runAdminServer(ctx context.Context, addr, token, captureDir string, rl *relay.Relay, drain func()) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/admin/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, protocol.CodeBadRequest, "Method not allowed")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"pending":  rl.Pending(),
+			"sessions": rl.Sessions(),
+		})
+	})
+
+	mux.HandleFunc("/admin/sessions/", func(w http.ResponseWriter, r *http.Request) {
+		sessionID := strings.TrimPrefix(r.URL.Path, "/admin/sessions/")
+		sessionID, capturing := strings.CutSuffix(sessionID, "/capture")
+		if sessionID == "" || strings.ContainsAny(sessionID, `/\.`) {
+			writeError(w, http.StatusNotFound, protocol.CodeNotFound, "Not found")
+			return
+		}
+
+		if capturing {
+			handleAdminCapture(w, r, rl, captureDir, sessionID)
+			return
+		}
+
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, protocol.CodeBadRequest, "Method not allowed")
+			return
+		}
+
+		if !rl.KillSession(sessionID) {
+			writeError(w, http.StatusNotFound, protocol.CodeSessionNotFound, "Session not found")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"killed": sessionID})
+		log.Printf("Admin killed session %s from %s", sessionID, adminClientIP(r))
+	})
+
+	mux.HandleFunc("/admin/drain", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, protocol.CodeBadRequest, "Method not allowed")
+			return
+		}
+
+		log.Printf("Admin requested drain from %s", adminClientIP(r))
+		drain()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"draining":       true,
+			"activeSessions": rl.ActiveSessions(),
+		})
+	})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, protocol.CodeUnauthorized, "Unauthorized")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	log.Printf("Admin API listening on %s", addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Printf("Admin API error: %v", err)
+	}
+}
+
+// loadRelayCertificate loads the relay's TLS certificate, or the persistent
+// self-signed one when no files are given
+func loadRelayCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return tls.Certificate{}, fmt.Errorf("--relay-cert and --relay-key must be given together")
+		}
+		return relay.LoadCertificate(certFile, keyFile)
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	dir = filepath.Join(dir, "sfo-helper")
+	certFile = filepath.Join(dir, "relay-cert.pem")
+	keyFile = filepath.Join(dir, "relay-key.pem")
+
+	cert, err := relay.LoadOrCreateSelfSigned(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	log.Printf("Using self-signed relay certificate %s", certFile)
+	return cert, nil
+}
+
+type tokenValidator struct {
+	signer *auth.Signer
+	nodeID string // set on a cluster node, which only accepts its own tokens
+}
+
+func (v *tokenValidator) Validate(token string) (*relay.TokenInfo, error) {
+	claims, err := v.signer.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Role == auth.NodeRole {
+		return nil, fmt.Errorf("node token used for a session")
+	}
+	if v.nodeID != "" && claims.Node != v.nodeID {
+		return nil, fmt.Errorf("token issued for relay node %q, this is %q", claims.Node, v.nodeID)
+	}
+	if claims.ID == "" {
+		return nil, fmt.Errorf("token has no ID")
+	}
+	info := &relay.TokenInfo{
+		ID:        claims.ID,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		SessionID: claims.SessionID,
+		Role:      claims.Role,
+		JoinerID:  claims.JoinerID,
+		RateLimit: claims.RateLimit,
+		ByteQuota: claims.ByteQuota,
+		Via:       claims.Via,
+	}
+	if home := claims.Home; home != nil && home.ID != v.nodeID {
+		// The host is on another node; this one links the joiner through
+		linkToken, err := v.signer.CreateLinkToken(claims, v.nodeID, time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		info.Upstream = &relay.Upstream{
+			Node:  home.ID,
+			Addr:  home.Addr,
+			TLS:   home.TLS,
+			Pin:   home.Pin,
+			Token: linkToken,
+		}
+	}
+	return info, nil
+}
+
+// upstreamDialer links joiners to the relay node serving their host, as a
+// relay client of that node
+type upstreamDialer struct{}
+
+func (upstreamDialer) DialUpstream(up *relay.Upstream, sessionID, channel string) (net.Conn, error) {
+	client := transport.NewRelayClient(up.Addr, up.TLS)
+	if err := client.SetPinnedFingerprint(up.Pin); err != nil {
+		return nil, err
+	}
+	if err := client.ConnectChannel(sessionID, up.Token, "joiner", channel); err != nil {
+		return nil, err
+	}
+	if client.Version() < 2 {
+		client.Close()
+		return nil, fmt.Errorf("relay node %s is too old for relay links", up.Node)
+	}
+	return client.GetConn(), nil
+}
+
+// adminClientIP returns the address an admin request came from. The admin
+// API is reached directly, so forwarding headers are never trusted.
+func adminClientIP(r *http.Request) string {
+	return proxyproto.ClientIP(r, nil)
+}
+
+// writeError answers an HTTP request with a JSON error body carrying a
+// machine-readable code
+func writeError(w http.ResponseWriter, status int, code protocol.ErrorCode, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(protocol.ErrorBody{Error: msg, Code: code})
+}
+
+// ==================== CLIENT COMMANDS ====================
+
+func runHost(args []string) {
+	fs := flag.NewFlagSet("host", flag.ExitOnError)
+	cfg := config.DefaultConfig()
+
+	target := fs.String("target", "", "Game target address (host:port)")
+	fs.StringVar(&cfg.SignalingURL, "signal", cfg.SignalingURL, "Signaling server URL")
+	fs.StringVar(&cfg.RelayAddr, "relay", cfg.RelayAddr, "Relay server address (host:port, or a ws:// or wss:// URL)")
+	fs.BoolVar(&cfg.RelayTLS, "relay-tls", cfg.RelayTLS, "Connect to the relay over TLS")
+	fs.StringVar(&cfg.RelayPin, "relay-pin", cfg.RelayPin, "SHA-256 fingerprint of the relay's TLS certificate to trust")
+	fs.IntVar(&cfg.TargetUDPPort, "udp-port", cfg.TargetUDPPort, "Game UDP port (0 disables UDP forwarding)")
+	fs.BoolVar(&cfg.Debug, "debug", cfg.Debug, "Enable debug logging")
+	skipWait := fs.Bool("skip-wait", false, "Skip waiting for game")
+	region := fs.String("region", "", "Preferred relay region when the server runs a relay cluster")
+	capturePath := fs.String("capture", "", "Record game traffic to this pcapng file for debugging")
+	spectators := fs.Bool("spectators", false, "Let others watch the match with the join code")
+	public := fs.Bool("public", false, "List the session in the public lobby browser so anyone can join")
+	nickname := fs.String("nickname", "", "Your name in the lobby browser (with --public)")
+	skill := fs.String("skill", "", "Short skill note shown in the lobby browser, e.g. \"casual\" (with --public)")
+	gameVersion := fs.String("game-version", "", "Game version shown in the lobby browser (with --public)")
+
+	fs.Parse(args)
+	cfg.LoadFromEnv()
+
+	if *target != "" {
+		parts := strings.Split(*target, ":")
+		if len(parts) == 2 {
+			cfg.TargetHost = parts[0]
+			fmt.Sscanf(parts[1], "%d", &cfg.TargetPort)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	fmt.Printf(banner, version)
+	fmt.Println("Mode: HOST")
+	fmt.Printf("Target: %s\n", cfg.TargetAddr())
+	fmt.Printf("Signaling: %s\n", cfg.SignalingURL)
+	fmt.Printf("Relay: %s\n", cfg.RelayAddr)
+	if *spectators {
+		fmt.Println("Spectators: allowed")
+	}
+	if *public {
+		fmt.Println("Lobby: public")
+	}
+	fmt.Println()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		fmt.Println("\nShutting down...")
+		cancel()
+	}()
+
+	br := bridge.NewBridge(cfg.TargetAddr())
+	if *capturePath != "" {
+		defer startCapture(br, *capturePath)()
+	}
+	joined := make(chan struct{}, 1)
+	br.SetStateChangeCallback(func(state bridge.State) {
+		fmt.Printf("[%s] State: %s\n", time.Now().Format("15:04:05"), state)
+		if state == bridge.StateConnected {
+			select {
+			case joined <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	if !*skipWait {
+		fmt.Println("Waiting for game on", cfg.TargetAddr(), "...")
+		if err := br.WaitForGame(5 * time.Minute); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Println("Game detected!")
+	}
+
+	fmt.Println("Creating session...")
+	signaling := transport.NewSignalingClient(cfg.SignalingURL)
+	signaling.SetRegion(*region)
+	signaling.SetAllowSpectators(*spectators)
+	if *public {
+		signaling.SetLobby(&transport.Lobby{
+			Nickname:    *nickname,
+			Region:      *region,
+			Skill:       *skill,
+			GameVersion: *gameVersion,
+		})
+	}
+	sess, err := signaling.CreateSession()
+	if err != nil {
+		fmt.Printf("\nERROR: Failed to create session: %v\n", err)
+		printErrorAdvice(err)
+		os.Exit(1)
+	}
+	useAssignedRelay(cfg, sess.RelayAssignment)
+
+	localIP := getLocalIP()
+
+	fmt.Println()
+	fmt.Println("╔═══════════════════════════════════════════════╗")
+	fmt.Println("║         SHARE THIS WITH YOUR FRIEND:          ║")
+	fmt.Println("╠═══════════════════════════════════════════════╣")
+	fmt.Printf("║  JOIN CODE: %-33s ║\n", sess.Code)
+	fmt.Printf("║  SERVER IP: %-33s ║\n", localIP)
+	fmt.Println("╚═══════════════════════════════════════════════╝")
+	fmt.Println()
+	fmt.Println("Your friend needs BOTH the code AND the IP!")
+	if sess.AllowSpectators {
+		fmt.Println("Others can watch with: sfo-helper spectate --code", sess.Code)
+	}
+	if sess.Public {
+		fmt.Println("Listed in the public lobby browser (sfo-helper lobbies)")
+	}
+	fmt.Println("Manage this session with (keep the token private):")
+	fmt.Printf("  sfo-helper session <cancel|extend|kick|new-code|allow-spectators|deny-spectators> --session %s --token %s --signal %s\n", sess.SessionID, sess.HostToken, cfg.SignalingURL)
+	fmt.Println("Waiting for joiner...")
+
+	if events, err := signaling.Subscribe(ctx); err != nil {
+		fmt.Printf("(Live session updates unavailable: %v)\n", err)
+	} else {
+		go showSessionEvents(events, sess.SessionID, sess.HostToken, cfg.SignalingURL)
+	}
+
+	fmt.Println("Connecting to relay server...")
+	relayClient := newRelayClient(cfg)
+	relayClient.SetMultiplexed(true)
+	watchReconnects(relayClient)
+	if err := relayClient.Connect(sess.SessionID, sess.RelayToken, "host"); err != nil {
+		fmt.Printf("\nERROR: Failed to connect to relay: %v\n", err)
+		if !printErrorAdvice(err) {
+			fmt.Println("Make sure the server is running and the address is correct.")
+		}
+		return
+	}
+	defer relayClient.Close()
+
+	fmt.Println("Connected to relay! Waiting for your friend to join...")
+	// The relay holds the host for as long as it stays; the code is what runs out
+	fmt.Printf("(The code works until %s unless you extend the session)\n", time.Unix(sess.ExpiresAt, 0).Format("15:04"))
+	fmt.Println()
+
+	br.SetRelayVersion(relayClient.Version())
+	if err := br.ConnectRelayMux(relayClient.GetConn()); err != nil {
+		fmt.Printf("\nERROR: Failed to connect to game: %v\n", err)
+		fmt.Println("Make sure Street Fighter Online is running!")
+		return
+	}
+
+	connectUDPChannel(br, relayClient, cfg.TargetUDPAddr(), sess.SessionID, sess.RelayToken, "host")
+
+	doneCh := make(chan struct{})
+	go func() {
+		br.Wait()
+		close(doneCh)
+	}()
+
+	// The game is only dialed once a joiner arrives
+	select {
+	case <-joined:
+	case <-ctx.Done():
+		fmt.Println("\nYou stopped the session.")
+		br.Close()
+		return
+	case <-doneCh:
+		fmt.Println("\nConnection ended.")
+		return
+	}
 
-type tokenValidator struct {
-	signer *auth.Signer
-}
+	fmt.Println()
+	fmt.Println("╔═══════════════════════════════════════════════╗")
+	fmt.Println("║    YOUR FRIEND JOINED! CONNECTED (RELAYED)    ║")
+	fmt.Println("║    You can now play! Keep this window open.   ║")
+	fmt.Println("╚═══════════════════════════════════════════════╝")
+	fmt.Println()
 
-func (v *tokenValidator) Validate(token string) (sessionID, role string, err error) {
-	claims, err := v.signer.Verify(token)
-	if err != nil {
-		return "", "", err
+	go statsLoop(ctx, br)
+
+	select {
+	case <-ctx.Done():
+		fmt.Println("\nYou stopped the session.")
+		br.Close()
+	case <-doneCh:
+		fmt.Println("\nConnection ended.")
 	}
-	return claims.SessionID, claims.Role, nil
 }
 
-func getClientIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
-	}
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+// showSessionEvents prints what signaling reports about the hosted session
+// as it happens. Only the TCP channel's relay events are shown; the UDP
+// channel follows it.
+func showSessionEvents(events <-chan protocol.SessionEvent, sessionID, hostToken, signalURL string) {
+	for e := range events {
+		if e.Channel == relay.ChannelUDP {
+			continue
+		}
+		stamp := time.Unix(e.Time, 0).Format("15:04:05")
+		switch e.Type {
+		case protocol.EventJoinerClaimed:
+			fmt.Printf("[%s] Player #%d entered the code, connecting...\n", stamp, e.JoinerID)
+		case protocol.EventHostRelayConnected:
+			fmt.Printf("[%s] You are on the relay\n", stamp)
+		case protocol.EventJoinerRelayConnected:
+			fmt.Printf("[%s] Player #%d reached the relay\n", stamp, e.JoinerID)
+		case protocol.EventPaired:
+			fmt.Printf("[%s] Player #%d is connected to you\n", stamp, e.JoinerID)
+		case protocol.EventExpiring:
+			fmt.Printf("[%s] Session expires at %s", stamp, time.Unix(e.ExpiresAt, 0).Format("15:04:05"))
+			if e.Reason == "" {
+				// Only signaling's expiry can be pushed back
+				fmt.Printf("; extend it with:\n  sfo-helper session extend --session %s --token %s --signal %s\n", sessionID, hostToken, signalURL)
+			} else {
+				fmt.Printf(" (%s)\n", e.Reason)
+			}
+		case protocol.EventExpired:
+			fmt.Printf("[%s] The join code expired; the match in progress keeps going\n", stamp)
+		case protocol.EventEnded:
+			fmt.Printf("[%s] Session ended: %s\n", stamp, e.Reason)
+		}
 	}
-	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-	return ip
 }
 
-// ==================== CLIENT COMMANDS ====================
-
-func runHost(args []string) {
-	fs := flag.NewFlagSet("host", flag.ExitOnError)
+func runJoin(args []string) {
+	fs := flag.NewFlagSet("join", flag.ExitOnError)
 	cfg := config.DefaultConfig()
 
 	target := fs.String("target", "", "Game target address (host:port)")
 	fs.StringVar(&cfg.SignalingURL, "signal", cfg.SignalingURL, "Signaling server URL")
-	fs.StringVar(&cfg.RelayAddr, "relay", cfg.RelayAddr, "Relay server address")
+	fs.StringVar(&cfg.RelayAddr, "relay", cfg.RelayAddr, "Relay server address (host:port, or a ws:// or wss:// URL)")
+	fs.BoolVar(&cfg.RelayTLS, "relay-tls", cfg.RelayTLS, "Connect to the relay over TLS")
+	fs.StringVar(&cfg.RelayPin, "relay-pin", cfg.RelayPin, "SHA-256 fingerprint of the relay's TLS certificate to trust")
+	fs.IntVar(&cfg.TargetUDPPort, "udp-port", cfg.TargetUDPPort, "Game UDP port (0 disables UDP forwarding)")
 	fs.BoolVar(&cfg.Debug, "debug", cfg.Debug, "Enable debug logging")
+	code := fs.String("code", "", "Join code from host (required)")
 	skipWait := fs.Bool("skip-wait", false, "Skip waiting for game")
+	region := fs.String("region", "", "Preferred relay region when the server runs a relay cluster")
+	capturePath := fs.String("capture", "", "Record game traffic to this pcapng file for debugging")
 
 	fs.Parse(args)
 	cfg.LoadFromEnv()
@@ -1810,15 +3187,20 @@ func runHost(args []string) {
 		}
 	}
 
+	if *code == "" {
+		fmt.Println("Error: --code is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
 	if err := cfg.Validate(); err != nil {
 		log.Fatalf("Invalid configuration: %v", err)
 	}
 
 	fmt.Printf(banner, version)
-	fmt.Println("Mode: HOST")
+	fmt.Println("Mode: JOIN")
 	fmt.Printf("Target: %s\n", cfg.TargetAddr())
-	fmt.Printf("Signaling: %s\n", cfg.SignalingURL)
-	fmt.Printf("Relay: %s\n\n", cfg.RelayAddr)
+	fmt.Printf("Code: %s\n\n", *code)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -1832,6 +3214,9 @@ func runHost(args []string) {
 	}()
 
 	br := bridge.NewBridge(cfg.TargetAddr())
+	if *capturePath != "" {
+		defer startCapture(br, *capturePath)()
+	}
 	br.SetStateChangeCallback(func(state bridge.State) {
 		fmt.Printf("[%s] State: %s\n", time.Now().Format("15:04:05"), state)
 	})
@@ -1847,45 +3232,42 @@ func runHost(args []string) {
 		fmt.Println("Game detected!")
 	}
 
-	fmt.Println("Creating session...")
+	fmt.Println("Joining session...")
 	signaling := transport.NewSignalingClient(cfg.SignalingURL)
-	sess, err := signaling.CreateSession()
+	signaling.SetRegion(*region)
+	sess, err := signaling.JoinSession(*code)
 	if err != nil {
-		log.Fatalf("Failed to create session: %v", err)
+		fmt.Printf("\nERROR: Failed to join session: %v\n", err)
+		printErrorAdvice(err)
+		os.Exit(1)
 	}
+	useAssignedRelay(cfg, sess.RelayAssignment)
 
-	localIP := getLocalIP()
-
-	fmt.Println()
-	fmt.Println("╔═══════════════════════════════════════════════╗")
-	fmt.Println("║         SHARE THIS WITH YOUR FRIEND:          ║")
-	fmt.Println("╠═══════════════════════════════════════════════╣")
-	fmt.Printf("║  JOIN CODE: %-33s ║\n", sess.Code)
-	fmt.Printf("║  SERVER IP: %-33s ║\n", localIP)
-	fmt.Println("╚═══════════════════════════════════════════════╝")
-	fmt.Println()
-	fmt.Println("Your friend needs BOTH the code AND the IP!")
-	fmt.Println("Waiting for joiner...")
+	fmt.Printf("Joined session %s...\n", sess.SessionID[:8])
 
 	fmt.Println("Connecting to relay server...")
-	relayClient := transport.NewRelayClient(cfg.RelayAddr, false)
-	if err := relayClient.Connect(sess.SessionID, sess.RelayToken, "host"); err != nil {
+	relayClient := newRelayClient(cfg)
+	watchReconnects(relayClient)
+	if err := relayClient.Connect(sess.SessionID, sess.RelayToken, "joiner"); err != nil {
 		fmt.Printf("\nERROR: Failed to connect to relay: %v\n", err)
-		fmt.Println("Make sure the server is running and the address is correct.")
+		if !printErrorAdvice(err) {
+			fmt.Println("Make sure the server is running and the address is correct.")
+		}
 		return
 	}
 	defer relayClient.Close()
 
-	fmt.Println("Connected to relay! Waiting for your friend to join...")
-	fmt.Println("(They have 2 minutes to enter the code)")
-	fmt.Println()
+	fmt.Println("Connected to relay! Connecting to host...")
 
+	br.SetRelayVersion(relayClient.Version())
 	if err := br.ConnectRelay(relayClient.GetConn()); err != nil {
 		fmt.Printf("\nERROR: Failed to connect to game: %v\n", err)
 		fmt.Println("Make sure Street Fighter Online is running!")
 		return
 	}
 
+	connectUDPChannel(br, relayClient, cfg.TargetUDPAddr(), sess.SessionID, sess.RelayToken, "joiner")
+
 	fmt.Println()
 	fmt.Println("╔═══════════════════════════════════════════════╗")
 	fmt.Println("║    SUCCESS! CONNECTED (RELAYED)               ║")
@@ -1910,15 +3292,18 @@ func runHost(args []string) {
 	}
 }
 
-func runJoin(args []string) {
-	fs := flag.NewFlagSet("join", flag.ExitOnError)
+func runSpectate(args []string) {
+	fs := flag.NewFlagSet("spectate", flag.ExitOnError)
 	cfg := config.DefaultConfig()
 
 	target := fs.String("target", "", "Game target address (host:port)")
+	fs.IntVar(&cfg.TargetUDPPort, "udp-port", cfg.TargetUDPPort, "Game UDP port (0 disables UDP forwarding)")
 	fs.StringVar(&cfg.SignalingURL, "signal", cfg.SignalingURL, "Signaling server URL")
-	fs.StringVar(&cfg.RelayAddr, "relay", cfg.RelayAddr, "Relay server address")
+	fs.StringVar(&cfg.RelayAddr, "relay", cfg.RelayAddr, "Relay server address (host:port, or a ws:// or wss:// URL)")
+	fs.BoolVar(&cfg.RelayTLS, "relay-tls", cfg.RelayTLS, "Connect to the relay over TLS")
+	fs.StringVar(&cfg.RelayPin, "relay-pin", cfg.RelayPin, "SHA-256 fingerprint of the relay's TLS certificate to trust")
 	fs.BoolVar(&cfg.Debug, "debug", cfg.Debug, "Enable debug logging")
-	code := fs.String("code", "", "Join code from host (required)")
+	code := fs.String("code", "", "Join code of the session to watch (required)")
 	skipWait := fs.Bool("skip-wait", false, "Skip waiting for game")
 
 	fs.Parse(args)
@@ -1943,7 +3328,7 @@ func runJoin(args []string) {
 	}
 
 	fmt.Printf(banner, version)
-	fmt.Println("Mode: JOIN")
+	fmt.Println("Mode: SPECTATE")
 	fmt.Printf("Target: %s\n", cfg.TargetAddr())
 	fmt.Printf("Code: %s\n\n", *code)
 
@@ -1959,6 +3344,7 @@ func runJoin(args []string) {
 	}()
 
 	br := bridge.NewBridge(cfg.TargetAddr())
+	br.SetSpectator(true)
 	br.SetStateChangeCallback(func(state bridge.State) {
 		fmt.Printf("[%s] State: %s\n", time.Now().Format("15:04:05"), state)
 	})
@@ -1974,36 +3360,40 @@ func runJoin(args []string) {
 		fmt.Println("Game detected!")
 	}
 
-	fmt.Println("Joining session...")
+	fmt.Println("Joining as a spectator...")
 	signaling := transport.NewSignalingClient(cfg.SignalingURL)
-	sess, err := signaling.JoinSession(*code)
+	sess, err := signaling.SpectateSession(*code)
 	if err != nil {
-		log.Fatalf("Failed to join session: %v", err)
+		fmt.Printf("\nERROR: Failed to spectate session: %v\n", err)
+		printErrorAdvice(err)
+		os.Exit(1)
 	}
-
-	fmt.Printf("Joined session %s...\n", sess.SessionID[:8])
+	useAssignedRelay(cfg, sess.RelayAssignment)
 
 	fmt.Println("Connecting to relay server...")
-	relayClient := transport.NewRelayClient(cfg.RelayAddr, false)
-	if err := relayClient.Connect(sess.SessionID, sess.RelayToken, "joiner"); err != nil {
+	relayClient := newRelayClient(cfg)
+	watchReconnects(relayClient)
+	if err := relayClient.Connect(sess.SessionID, sess.RelayToken, "spectator"); err != nil {
 		fmt.Printf("\nERROR: Failed to connect to relay: %v\n", err)
-		fmt.Println("Make sure the server is running and the address is correct.")
+		if !printErrorAdvice(err) {
+			fmt.Println("Make sure the server is running and the address is correct.")
+		}
 		return
 	}
 	defer relayClient.Close()
 
-	fmt.Println("Connected to relay! Connecting to host...")
-
+	br.SetRelayVersion(relayClient.Version())
 	if err := br.ConnectRelay(relayClient.GetConn()); err != nil {
 		fmt.Printf("\nERROR: Failed to connect to game: %v\n", err)
 		fmt.Println("Make sure Street Fighter Online is running!")
 		return
 	}
+	connectUDPChannel(br, relayClient, cfg.TargetUDPAddr(), sess.SessionID, sess.RelayToken, "spectator")
 
 	fmt.Println()
 	fmt.Println("╔═══════════════════════════════════════════════╗")
-	fmt.Println("║    SUCCESS! CONNECTED (RELAYED)               ║")
-	fmt.Println("║    You can now play! Keep this window open.   ║")
+	fmt.Println("║    WATCHING (RELAYED)                         ║")
+	fmt.Println("║    Keep this window open to keep watching.    ║")
 	fmt.Println("╚═══════════════════════════════════════════════╝")
 	fmt.Println()
 
@@ -2017,13 +3407,128 @@ func runJoin(args []string) {
 
 	select {
 	case <-ctx.Done():
-		fmt.Println("\nYou stopped the session.")
+		fmt.Println("\nYou stopped watching.")
 		br.Close()
 	case <-doneCh:
-		fmt.Println("\nConnection ended.")
+		fmt.Println("\nMatch ended.")
+	}
+}
+
+// connectUDPChannel opens the relay's UDP channel for the session, using the
+// same relay settings as tcpRelay, and starts forwarding the game's datagrams
+// through it. UDP is best-effort: failures are reported and the TCP stream
+// carries on without it.
+func connectUDPChannel(br *bridge.Bridge, tcpRelay *transport.RelayClient, udpTarget, sessionID, relayToken, role string) {
+	if udpTarget == "" {
+		return
+	}
+
+	br.SetUDPTarget(udpTarget)
+	udpRelay := tcpRelay.Sibling()
+	if err := udpRelay.ConnectChannel(sessionID, relayToken, role, transport.ChannelUDP); err != nil {
+		fmt.Printf("Warning: UDP relay unavailable, continuing with TCP only: %v\n", err)
+		return
+	}
+
+	connect := br.ConnectUDPRelay
+	if role == "host" {
+		connect = br.ConnectUDPRelayMux
+	}
+	if err := connect(udpRelay.GetConn()); err != nil {
+		fmt.Printf("Warning: UDP forwarding unavailable, continuing with TCP only: %v\n", err)
+		udpRelay.Close()
+		return
+	}
+
+	fmt.Printf("UDP forwarding active (%s)\n", udpTarget)
+}
+
+// useAssignedRelay points cfg at the relay node signaling assigned to the
+// session, when the server runs a relay cluster
+func useAssignedRelay(cfg *config.Config, assigned transport.RelayAssignment) {
+	if assigned.RelayAddr == "" {
+		return
+	}
+	cfg.RelayAddr = assigned.RelayAddr
+	cfg.RelayTLS = assigned.RelayTLS
+	cfg.RelayPin = assigned.RelayPin
+	fmt.Printf("Relay node: %s (%s)\n", assigned.RelayNode, assigned.RelayAddr)
+}
+
+// newRelayClient creates a relay client with the configured TLS settings
+func newRelayClient(cfg *config.Config) *transport.RelayClient {
+	client := transport.NewRelayClient(cfg.RelayAddr, cfg.RelayTLS)
+	if err := client.SetPinnedFingerprint(cfg.RelayPin); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	return client
+}
+
+// watchReconnects reports relay reconnects while a session is being resumed
+func watchReconnects(client *transport.RelayClient) {
+	client.SetReconnectCallbacks(func(err error) {
+		fmt.Printf("[%s] Relay connection lost (%v), reconnecting...\n", time.Now().Format("15:04:05"), err)
+	}, func() {
+		fmt.Printf("[%s] Relay connection restored, session resumed\n", time.Now().Format("15:04:05"))
+	})
+}
+
+// printErrorAdvice tells the user what to do about an error the relay or
+// signaling server reported. It reports whether it had advice for err.
+func printErrorAdvice(err error) bool {
+	switch {
+	case errors.Is(err, transport.ErrRelayFull):
+		fmt.Println("The server is full right now. Try again in a few minutes.")
+	case errors.Is(err, transport.ErrTooManyConnections):
+		fmt.Println("Too many connections from your network. Close other sessions and try again.")
+	case errors.Is(err, transport.ErrInvalidCode):
+		fmt.Println("Check the code with the host. Codes expire, so ask for a new one if it's old.")
+	case errors.Is(err, transport.ErrSessionFull):
+		fmt.Println("Every player slot is taken. Ask the host to start a new session.")
+	case errors.Is(err, transport.ErrSpectatorsDenied):
+		fmt.Println("Ask the host to start the session with --spectators.")
+	case errors.Is(err, transport.ErrRelayUnavailable):
+		fmt.Println("Ask the host to start a new session.")
+	case errors.Is(err, transport.ErrInvalidToken):
+		fmt.Println("The session may have expired. Ask the host for a new code.")
+	case errors.Is(err, transport.ErrTokenUsed):
+		fmt.Println("Someone already connected with this session's credentials. Start or join a new session.")
+	case errors.Is(err, transport.ErrKicked):
+		fmt.Println("Ask the host if you should join again with a new code.")
+	default:
+		return false
+	}
+	return true
+}
+
+// startCapture records the bridge's game traffic to a pcapng file at path.
+// The returned func finishes the file once the bridge has stopped.
+func startCapture(br *bridge.Bridge, path string) func() {
+	w, err := capture.Create(path)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	br.SetCapture(w)
+	fmt.Printf("Capturing game traffic to %s\n", path)
+
+	return func() {
+		if err := w.Close(); err != nil {
+			fmt.Printf("Capture failed: %v\n", err)
+			return
+		}
+		fmt.Printf("Saved capture to %s\n", path)
 	}
 }
 
+// gameUDPAddr derives the game's UDP address (port 1627) from its TCP address
+func gameUDPAddr(gameAddr string) string {
+	host, _, err := net.SplitHostPort(gameAddr)
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, "1627")
+}
+
 func statsLoop(ctx context.Context, br *bridge.Bridge) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
@@ -2033,10 +3538,17 @@ func statsLoop(ctx context.Context, br *bridge.Bridge) {
 			return
 		case <-ticker.C:
 			stats := br.GetStats()
-			fmt.Printf("[Stats] In: %d bytes | Out: %d bytes | Uptime: %s\n",
+			fmt.Printf("[Stats] In: %d bytes | Out: %d bytes | Uptime: %s",
 				stats.BytesIn.Load(),
 				stats.BytesOut.Load(),
 				time.Since(stats.StartTime).Round(time.Second))
+			if br.Multiplexed() {
+				fmt.Printf(" | Joiners: %d", stats.Peers.Load())
+			}
+			if rtt := stats.RTT.Load(); rtt > 0 {
+				fmt.Printf(" | RTT: %s", time.Duration(rtt).Round(time.Millisecond))
+			}
+			fmt.Println()
 		}
 	}
 }
@@ -2047,16 +3559,18 @@ func runStatus(args []string) {
 
 	fs.StringVar(&cfg.SignalingURL, "signal", cfg.SignalingURL, "Signaling server URL")
 	sessionID := fs.String("session", "", "Session ID to check")
+	hostToken := fs.String("token", "", "Host token printed when the session was created")
 
 	fs.Parse(args)
 
-	if *sessionID == "" {
-		fmt.Println("Error: --session is required")
+	if *sessionID == "" || *hostToken == "" {
+		fmt.Println("Error: --session and --token are required")
 		fs.Usage()
 		os.Exit(1)
 	}
 
 	signaling := transport.NewSignalingClient(cfg.SignalingURL)
+	signaling.SetHostToken(*hostToken)
 	status, err := signaling.GetSessionStatus(*sessionID)
 	if err != nil {
 		log.Fatalf("Failed to get status: %v", err)
@@ -2064,18 +3578,166 @@ func runStatus(args []string) {
 
 	fmt.Println("Session Status:")
 	fmt.Printf("  Session ID: %s\n", status.SessionID)
+	fmt.Printf("  Join Code: %s\n", status.Code)
 	fmt.Printf("  Host Connected: %v\n", status.HostConnected)
 	fmt.Printf("  Join Connected: %v\n", status.JoinConnected)
+	fmt.Printf("  Joiners: %d/%d\n", len(status.Joiners), status.MaxJoiners)
+	for _, j := range status.Joiners {
+		fmt.Printf("    #%d joined %s\n", j.ID, time.Unix(j.JoinedAt, 0).Format(time.RFC3339))
+	}
+	if status.AllowSpectators {
+		fmt.Printf("  Spectators: %d\n", status.Spectators)
+	}
 	fmt.Printf("  Expires At: %s\n", time.Unix(status.ExpiresAt, 0).Format(time.RFC3339))
 }
 
+func runSession(args []string) {
+	var action string
+	if len(args) > 0 {
+		switch args[0] {
+		case "cancel", "extend", "kick", "new-code", "allow-spectators", "deny-spectators":
+			action, args = args[0], args[1:]
+		}
+	}
+	if action == "" {
+		fmt.Println("Usage: sfo-helper session cancel --session <id> --token <host-token> [options]")
+		fmt.Println("       sfo-helper session extend --session <id> --token <host-token> [options]")
+		fmt.Println("       sfo-helper session kick [--joiner <id>] --session <id> --token <host-token> [options]")
+		fmt.Println("       sfo-helper session new-code --session <id> --token <host-token> [options]")
+		fmt.Println("       sfo-helper session <allow-spectators|deny-spectators> --session <id> --token <host-token> [options]")
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("session", flag.ExitOnError)
+	cfg := config.DefaultConfig()
+
+	fs.StringVar(&cfg.SignalingURL, "signal", cfg.SignalingURL, "Signaling server URL")
+	sessionID := fs.String("session", "", "ID of the session you host")
+	hostToken := fs.String("token", "", "Host token printed when the session was created")
+	joinerID := fs.Uint("joiner", 0, "Joiner to kick (0 kicks every joiner)")
+
+	fs.Parse(args)
+
+	if *sessionID == "" || *hostToken == "" {
+		fmt.Println("Error: --session and --token are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	signaling := transport.NewSignalingClient(cfg.SignalingURL)
+	signaling.SetHostToken(*hostToken)
+
+	switch action {
+	case "cancel":
+		if err := signaling.CancelSession(*sessionID); err != nil {
+			log.Fatalf("Failed to cancel session: %v", err)
+		}
+		fmt.Println("Session cancelled")
+	case "extend":
+		expires, err := signaling.ExtendSession(*sessionID)
+		if err != nil {
+			log.Fatalf("Failed to extend session: %v", err)
+		}
+		fmt.Printf("Session now expires at %s\n", expires.Format(time.RFC3339))
+	case "kick":
+		kicked, err := signaling.KickJoiner(*sessionID, uint32(*joinerID))
+		if err != nil {
+			log.Fatalf("Failed to kick: %v", err)
+		}
+		if len(kicked) == 0 {
+			fmt.Println("No joiners to kick")
+			return
+		}
+		for _, id := range kicked {
+			fmt.Printf("Kicked joiner #%d\n", id)
+		}
+	case "new-code":
+		code, err := signaling.RegenerateCode(*sessionID)
+		if err != nil {
+			log.Fatalf("Failed to get a new code: %v", err)
+		}
+		fmt.Printf("New join code: %s\n", code)
+		fmt.Println("The old code no longer works.")
+	case "allow-spectators", "deny-spectators":
+		allow := action == "allow-spectators"
+		if err := signaling.SetSessionSpectators(*sessionID, allow); err != nil {
+			log.Fatalf("Failed to change spectators: %v", err)
+		}
+		if allow {
+			fmt.Println("Spectators can now watch with the join code")
+		} else {
+			fmt.Println("New spectators are refused; those already watching carry on")
+		}
+	}
+}
+
+func runLobbies(args []string) {
+	fs := flag.NewFlagSet("lobbies", flag.ExitOnError)
+	cfg := config.DefaultConfig()
+
+	fs.StringVar(&cfg.SignalingURL, "signal", cfg.SignalingURL, "Signaling server URL")
+	region := fs.String("region", "", "Only list games in this region")
+	gameVersion := fs.String("version", "", "Only list games on this game version")
+	search := fs.String("search", "", "Only list games whose nickname or skill note contains this")
+	all := fs.Bool("all", false, "Also list games that are full")
+
+	fs.Parse(args)
+
+	signaling := transport.NewSignalingClient(cfg.SignalingURL)
+	lobbies, err := signaling.ListLobbies(transport.LobbyFilter{
+		Region:      *region,
+		GameVersion: *gameVersion,
+		Search:      *search,
+		IncludeFull: *all,
+	})
+	if err != nil {
+		log.Fatalf("Failed to list lobbies: %v", err)
+	}
+
+	printLobbies(lobbies)
+	if len(lobbies) > 0 {
+		fmt.Println("\nJoin with: sfo-helper join --code <code> --signal", cfg.SignalingURL)
+	}
+}
+
+// printLobbies prints a numbered table of public games
+func printLobbies(lobbies []transport.LobbyListing) {
+	if len(lobbies) == 0 {
+		fmt.Println("No public games right now.")
+		return
+	}
+	fmt.Printf("  %-3s %-16s %-13s %-8s %-8s %-7s %s\n", "#", "HOST", "CODE", "REGION", "VERSION", "PLAYERS", "SKILL")
+	for i, l := range lobbies {
+		players := fmt.Sprintf("%d/%d", l.Players, l.MaxPlayers)
+		fmt.Printf("  %-3d %-16s %-13s %-8s %-8s %-7s %s\n", i+1, lobbyName(l), l.Code, dashIfEmpty(l.Region), dashIfEmpty(l.GameVersion), players, l.Skill)
+	}
+}
+
+// lobbyName is how a public game's host is shown
+func lobbyName(l transport.LobbyListing) string {
+	if l.Nickname == "" {
+		return "(anonymous)"
+	}
+	return l.Nickname
+}
+
+// dashIfEmpty shows an unset field as a dash
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
 func runDiagnose(args []string) {
 	fs := flag.NewFlagSet("diagnose", flag.ExitOnError)
 	cfg := config.DefaultConfig()
 
 	target := fs.String("target", "", "Game target address (host:port)")
 	fs.StringVar(&cfg.SignalingURL, "signal", cfg.SignalingURL, "Signaling server URL")
-	fs.StringVar(&cfg.RelayAddr, "relay", cfg.RelayAddr, "Relay server address")
+	fs.StringVar(&cfg.RelayAddr, "relay", cfg.RelayAddr, "Relay server address (host:port, or a ws:// or wss:// URL)")
+	fs.BoolVar(&cfg.RelayTLS, "relay-tls", cfg.RelayTLS, "Connect to the relay over TLS")
+	fs.StringVar(&cfg.RelayPin, "relay-pin", cfg.RelayPin, "SHA-256 fingerprint of the relay's TLS certificate to trust")
 
 	fs.Parse(args)
 
@@ -2110,7 +3772,7 @@ func runDiagnose(args []string) {
 	}
 
 	fmt.Printf("3. Checking relay server (%s)... ", cfg.RelayAddr)
-	if err := checkRelay(cfg.RelayAddr); err != nil {
+	if err := checkRelay(cfg); err != nil {
 		fmt.Printf("FAILED: %v\n", err)
 		allPassed = false
 	} else {
@@ -2125,19 +3787,172 @@ func runDiagnose(args []string) {
 	}
 }
 
-func checkRelay(addr string) error {
-	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
-	if err != nil {
-		// Try with TLS
-		conn, err = tls.DialWithDialer(
-			&net.Dialer{Timeout: 5 * time.Second},
-			"tcp", addr,
-			&tls.Config{MinVersion: tls.VersionTLS13, InsecureSkipVerify: true},
-		)
+// handleAdminCapture starts (POST) or stops (DELETE) capturing a session's
+// traffic to a pcapng file in dir
+func handleAdminCapture(w http.ResponseWriter, r *http.Request, rl *relay.Relay, dir, sessionID string) {
+	switch r.Method {
+	case http.MethodPost:
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Printf("Failed to create capture directory: %v", err)
+			writeError(w, http.StatusInternalServerError, protocol.CodeInternal, "Failed to create capture file")
+			return
+		}
+		// The random suffix keeps a rejected request from clobbering the
+		// file of a capture already running
+		f, err := os.CreateTemp(dir, fmt.Sprintf("%s-%s-*.pcapng", sessionID, time.Now().UTC().Format("20060102-150405")))
+		if err != nil {
+			log.Printf("Failed to create capture file: %v", err)
+			writeError(w, http.StatusInternalServerError, protocol.CodeInternal, "Failed to create capture file")
+			return
+		}
+		path := f.Name()
+		cw, err := capture.NewWriter(f)
 		if err != nil {
-			return fmt.Errorf("unreachable: %w", err)
+			f.Close()
+			os.Remove(path)
+			log.Printf("Failed to start capture: %v", err)
+			writeError(w, http.StatusInternalServerError, protocol.CodeInternal, "Failed to create capture file")
+			return
+		}
+		if err := rl.StartCapture(sessionID, cw); err != nil {
+			cw.Close()
+			os.Remove(path)
+			if errors.Is(err, relay.ErrAlreadyCapturing) {
+				writeError(w, http.StatusConflict, protocol.CodeCaptureActive, "Session is already being captured")
+			} else {
+				writeError(w, http.StatusNotFound, protocol.CodeSessionNotFound, "Session not found")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"capturing": sessionID, "file": path})
+		log.Printf("Admin started capturing session %s to %s from %s", sessionID, path, adminClientIP(r))
+
+	case http.MethodDelete:
+		if !rl.StopCapture(sessionID) {
+			writeError(w, http.StatusNotFound, protocol.CodeNotFound, "Session is not being captured")
+			return
 		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"stopped": sessionID})
+		log.Printf("Admin stopped capturing session %s from %s", sessionID, adminClientIP(r))
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, protocol.CodeBadRequest, "Method not allowed")
 	}
-	conn.Close()
-	return nil
+}
+
+func runAdmin(args []string) {
+	var action string
+	var rest []string
+	switch {
+	case len(args) >= 1 && args[0] == "drain":
+		action, rest = "drain", args[1:]
+	case len(args) >= 2 && args[0] == "sessions" && (args[1] == "list" || args[1] == "kill" ||
+		args[1] == "capture" || args[1] == "stop-capture"):
+		action, rest = args[1], args[2:]
+	default:
+		fmt.Println("Usage: sfo-helper admin sessions list [options]")
+		fmt.Println("       sfo-helper admin sessions kill <session-id> [options]")
+		fmt.Println("       sfo-helper admin sessions capture <session-id> [options]")
+		fmt.Println("       sfo-helper admin sessions stop-capture <session-id> [options]")
+		fmt.Println("       sfo-helper admin drain [options]")
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("admin", flag.ExitOnError)
+	adminURL := fs.String("admin", "http://127.0.0.1:1629", "Relay admin API URL")
+	token := fs.String("token", os.Getenv("SFO_ADMIN_TOKEN"), "Admin API token")
+
+	needsID := action != "list" && action != "drain"
+	var sessionID string
+	if needsID && len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		sessionID, rest = rest[0], rest[1:]
+	}
+	fs.Parse(rest)
+	if sessionID == "" && needsID {
+		sessionID = fs.Arg(0)
+	}
+
+	if *token == "" {
+		fmt.Println("Error: --token (or SFO_ADMIN_TOKEN) is required")
+		os.Exit(1)
+	}
+
+	client := transport.NewAdminClient(strings.TrimRight(*adminURL, "/"), *token)
+
+	if action == "drain" {
+		active, err := client.Drain()
+		if err != nil {
+			log.Fatalf("Failed to start drain: %v", err)
+		}
+		fmt.Printf("Relay is draining; %d active sessions left\n", active)
+		return
+	}
+
+	if needsID && sessionID == "" {
+		fmt.Println("Error: session ID is required")
+		os.Exit(1)
+	}
+
+	switch action {
+	case "kill":
+		if err := client.KillSession(sessionID); err != nil {
+			log.Fatalf("Failed to kill session: %v", err)
+		}
+		fmt.Printf("Session %s terminated\n", sessionID)
+		return
+	case "capture":
+		path, err := client.StartCapture(sessionID)
+		if err != nil {
+			log.Fatalf("Failed to start capture: %v", err)
+		}
+		fmt.Printf("Capturing session %s to %s on the relay\n", sessionID, path)
+		return
+	case "stop-capture":
+		if err := client.StopCapture(sessionID); err != nil {
+			log.Fatalf("Failed to stop capture: %v", err)
+		}
+		fmt.Printf("Stopped capturing session %s\n", sessionID)
+		return
+	}
+
+	list, err := client.ListSessions()
+	if err != nil {
+		log.Fatalf("Failed to list sessions: %v", err)
+	}
+
+	fmt.Printf("Active sessions: %d\n", len(list.Sessions))
+	for _, s := range list.Sessions {
+		fmt.Printf("  %s | Up: %s | Idle: %s | To host: %d bytes | From host: %d bytes\n", s.SessionID,
+			time.Duration(s.DurationSeconds)*time.Second, time.Duration(s.IdleSeconds)*time.Second,
+			s.BytesToHost, s.BytesFromHost)
+		for _, p := range s.Peers {
+			name := p.Role
+			if p.Stream != 0 && p.Role != "host" {
+				name = fmt.Sprintf("%s %d", p.Role, p.Stream)
+			}
+			fmt.Printf("    %-9s %-3s %s\n", name, p.Channel, p.RemoteAddr)
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("Pending connections: %d\n", len(list.Pending))
+	for _, p := range list.Pending {
+		fmt.Printf("  %s | %s %s from %s | Waiting: %s\n", p.SessionID, p.Role, p.Channel, p.RemoteAddr,
+			time.Duration(p.WaitingSeconds)*time.Second)
+	}
+}
+
+func checkRelay(cfg *config.Config) error {
+	var pin []byte
+	if cfg.RelayPin != "" {
+		var err error
+		if pin, err = transport.ParseFingerprint(cfg.RelayPin); err != nil {
+			return err
+		}
+	}
+	return transport.CheckRelayReachablePinned(cfg.RelayAddr, cfg.RelayTLS, pin)
 }
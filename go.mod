@@ -5,6 +5,7 @@ go 1.21
 require (
 	github.com/huin/goupnp v1.3.0
 	github.com/pion/webrtc/v3 v3.3.6
+	golang.org/x/net v0.22.0
 	golang.org/x/time v0.5.0
 )
 
@@ -30,7 +31,6 @@ require (
 	github.com/stretchr/testify v1.9.0 // indirect
 	github.com/wlynxg/anet v0.0.3 // indirect
 	golang.org/x/crypto v0.21.0 // indirect
-	golang.org/x/net v0.22.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
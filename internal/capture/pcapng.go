@@ -0,0 +1,343 @@
+// Package capture records relayed game traffic to pcapng files for
+// debugging desyncs. The relay and the bridge only see payloads, so the
+// writer synthesizes IPv4 with TCP or UDP headers around them: each game
+// connection becomes a TCP stream, handshake and teardown included, that
+// Wireshark can follow, and each datagram a UDP packet.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// Ports captures give the game when the real ones are not known. They are
+// the ports Street Fighter Online listens on.
+const (
+	DefaultTCPPort = 1626
+	DefaultUDPPort = 1627
+)
+
+var errClosed = errors.New("capture is closed")
+
+// maxSegment keeps each synthesized packet within IPv4's 64 KiB limit
+const maxSegment = 65535 - ipv4HeaderLen - tcpHeaderLen
+
+const (
+	ipv4HeaderLen = 20
+	tcpHeaderLen  = 20
+	udpHeaderLen  = 8
+
+	protoTCP = 6
+	protoUDP = 17
+
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+// pcapng block types and the link type of raw IP packets
+const (
+	blockSectionHeader = 0x0A0D0D0A
+	blockInterface     = 0x00000001
+	blockEnhanced      = 0x00000006
+	byteOrderMagic     = 0x1A2B3C4D
+	linkTypeRaw        = 101
+)
+
+// GameAddr is the address captures give the game, which serves every
+// connection, on port
+func GameAddr(port uint16) netip.AddrPort {
+	return netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, 0, 1}), port)
+}
+
+// PeerAddr is the address captures give the remote player on stream id
+func PeerAddr(id uint32) netip.AddrPort {
+	return netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, 0, 2}), uint16(49152+id%16384))
+}
+
+// Writer writes packets to a pcapng file. It is safe for concurrent use.
+// Each packet is flushed as it is recorded, so the file can be opened while
+// recording goes on and survives the process being killed. Write errors are
+// kept and returned by Close, so recording never gets in the way of the
+// traffic being recorded.
+type Writer struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	ipID   uint16
+	err    error
+}
+
+// Create creates a pcapng file at path and writes its header
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture file: %w", err)
+	}
+	w, err := NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewWriter writes a pcapng header to w and returns a Writer for it. Closing
+// the Writer closes w too if it is an io.Closer.
+func NewWriter(w io.Writer) (*Writer, error) {
+	cw := &Writer{w: bufio.NewWriter(w)}
+	if c, ok := w.(io.Closer); ok {
+		cw.closer = c
+	}
+
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1) // version 1.0
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0))
+	shb = appendOption(shb, 4, []byte("sfo-helper")) // shb_userappl
+	cw.writeBlock(blockSectionHeader, shb)
+
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], linkTypeRaw)
+	idb = appendOption(idb, 2, []byte("relay")) // if_name
+	cw.writeBlock(blockInterface, idb)
+
+	if err := cw.w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write capture header: %w", err)
+	}
+	return cw, cw.err
+}
+
+// Close flushes the capture and closes the underlying file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.w.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if w.closer != nil {
+		if err := w.closer.Close(); err != nil && w.err == nil {
+			w.err = err
+		}
+		w.closer = nil
+	}
+	err := w.err
+	if w.err == nil {
+		w.err = errClosed // drop anything recorded after closing
+	}
+	return err
+}
+
+// Datagram records one UDP datagram
+func (w *Writer) Datagram(src, dst netip.AddrPort, payload []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	udp := make([]byte, udpHeaderLen, udpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], src.Port())
+	binary.BigEndian.PutUint16(udp[2:], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpHeaderLen+len(payload)))
+	udp = append(udp, payload...)
+	binary.BigEndian.PutUint16(udp[6:], transportChecksum(src, dst, protoUDP, udp))
+
+	w.writePacketLocked(src, dst, protoUDP, udp)
+}
+
+// Stream is one synthesized TCP connection from a client to a server
+type Stream struct {
+	w              *Writer
+	client, server netip.AddrPort
+	clientSeq      uint32 // next sequence number from the client
+	serverSeq      uint32
+	closed         bool
+}
+
+// Stream records a TCP handshake between client and server and returns the
+// stream for their traffic
+func (w *Writer) Stream(client, server netip.AddrPort) *Stream {
+	s := &Stream{w: w, client: client, server: server, clientSeq: 1000, serverSeq: 5000}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s.segmentLocked(true, tcpSYN, nil)
+	s.clientSeq++
+	s.segmentLocked(false, tcpSYN|tcpACK, nil)
+	s.serverSeq++
+	s.segmentLocked(true, tcpACK, nil)
+	return s
+}
+
+// Write records data sent by the client, or by the server if fromClient is
+// false
+func (s *Stream) Write(fromClient bool, payload []byte) {
+	s.w.mu.Lock()
+	defer s.w.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	for len(payload) > 0 {
+		n := len(payload)
+		if n > maxSegment {
+			n = maxSegment
+		}
+		s.segmentLocked(fromClient, tcpPSH|tcpACK, payload[:n])
+		if fromClient {
+			s.clientSeq += uint32(n)
+		} else {
+			s.serverSeq += uint32(n)
+		}
+		payload = payload[n:]
+	}
+}
+
+// Close records the stream's teardown, once
+func (s *Stream) Close() {
+	s.w.mu.Lock()
+	defer s.w.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.segmentLocked(true, tcpFIN|tcpACK, nil)
+	s.clientSeq++
+	s.segmentLocked(false, tcpFIN|tcpACK, nil)
+	s.serverSeq++
+	s.segmentLocked(true, tcpACK, nil)
+}
+
+func (s *Stream) segmentLocked(fromClient bool, flags byte, payload []byte) {
+	src, dst := s.client, s.server
+	seq, ack := s.clientSeq, s.serverSeq
+	if !fromClient {
+		src, dst = s.server, s.client
+		seq, ack = s.serverSeq, s.clientSeq
+	}
+	if flags&tcpACK == 0 {
+		ack = 0
+	}
+
+	tcp := make([]byte, tcpHeaderLen, tcpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], src.Port())
+	binary.BigEndian.PutUint16(tcp[2:], dst.Port())
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = tcpHeaderLen / 4 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535) // window
+	tcp = append(tcp, payload...)
+	binary.BigEndian.PutUint16(tcp[16:], transportChecksum(src, dst, protoTCP, tcp))
+
+	s.w.writePacketLocked(src, dst, protoTCP, tcp)
+}
+
+// writePacketLocked wraps a TCP or UDP segment in an IPv4 header and writes
+// it as an enhanced packet block stamped with the current time
+func (w *Writer) writePacketLocked(src, dst netip.AddrPort, proto byte, segment []byte) {
+	w.ipID++
+
+	ip := make([]byte, ipv4HeaderLen, ipv4HeaderLen+len(segment))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(ipv4HeaderLen+len(segment)))
+	binary.BigEndian.PutUint16(ip[4:], w.ipID)
+	binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+	ip[8] = 64                                 // TTL
+	ip[9] = proto
+	srcIP, dstIP := src.Addr().As4(), dst.Addr().As4()
+	copy(ip[12:], srcIP[:])
+	copy(ip[16:], dstIP[:])
+	binary.BigEndian.PutUint16(ip[10:], checksum(0, ip))
+	ip = append(ip, segment...)
+
+	ts := uint64(time.Now().UnixMicro())
+	epb := make([]byte, 20, 20+len(ip)+3)
+	binary.LittleEndian.PutUint32(epb[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(epb[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(epb[12:], uint32(len(ip)))
+	binary.LittleEndian.PutUint32(epb[16:], uint32(len(ip)))
+	epb = append(epb, ip...)
+	epb = pad(epb)
+	w.writeBlock(blockEnhanced, epb)
+	if err := w.w.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// writeBlock frames body as a pcapng block of the given type
+func (w *Writer) writeBlock(blockType uint32, body []byte) {
+	if w.err != nil {
+		return
+	}
+	var typ, length [4]byte
+	binary.LittleEndian.PutUint32(typ[:], blockType)
+	binary.LittleEndian.PutUint32(length[:], uint32(12+len(body)))
+
+	w.w.Write(typ[:])
+	w.w.Write(length[:])
+	w.w.Write(body)
+	if _, err := w.w.Write(length[:]); err != nil {
+		w.err = err
+	}
+}
+
+// appendOption adds a pcapng option followed by the end of options marker
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	var head [4]byte
+	binary.LittleEndian.PutUint16(head[0:], code)
+	binary.LittleEndian.PutUint16(head[2:], uint16(len(value)))
+	b = append(b, head[:]...)
+	b = pad(append(b, value...))
+	return append(b, 0, 0, 0, 0) // opt_endofopt
+}
+
+// pad extends b to a multiple of four bytes
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// transportChecksum computes a TCP or UDP checksum over the IPv4
+// pseudo-header and the segment, whose checksum field must be zero
+func transportChecksum(src, dst netip.AddrPort, proto byte, segment []byte) uint16 {
+	pseudo := make([]byte, 12)
+	srcIP, dstIP := src.Addr().As4(), dst.Addr().As4()
+	copy(pseudo[0:], srcIP[:])
+	copy(pseudo[4:], dstIP[:])
+	pseudo[9] = proto
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(len(segment)))
+
+	sum := checksum(0, pseudo)
+	sum = checksum(^sum, segment)
+	if proto == protoUDP && sum == 0 {
+		return 0xffff
+	}
+	return sum
+}
+
+// checksum folds b into the ones' complement sum started by initial and
+// returns its complement
+func checksum(initial uint16, b []byte) uint16 {
+	sum := uint32(initial)
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,226 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+// packet is a packet read back from a capture
+type packet struct {
+	src, dst netip.AddrPort
+	proto    byte
+	flags    byte // TCP only
+	seq, ack uint32
+	payload  []byte
+}
+
+// readCapture parses a pcapng file written by Writer, checking its framing
+// and every checksum on the way
+func readCapture(t *testing.T, b []byte) []packet {
+	t.Helper()
+
+	var blocks [][]byte
+	var types []uint32
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("truncated block header: % x", b)
+		}
+		typ := binary.LittleEndian.Uint32(b[0:])
+		length := binary.LittleEndian.Uint32(b[4:])
+		if length%4 != 0 || int(length) > len(b) {
+			t.Fatalf("bad block length %d with %d bytes left", length, len(b))
+		}
+		if trailer := binary.LittleEndian.Uint32(b[length-4:]); trailer != length {
+			t.Fatalf("block trailer %d, want %d", trailer, length)
+		}
+		types = append(types, typ)
+		blocks = append(blocks, b[8:length-4])
+		b = b[length:]
+	}
+
+	if len(types) < 2 || types[0] != blockSectionHeader || types[1] != blockInterface {
+		t.Fatalf("capture starts with blocks %x", types)
+	}
+	if magic := binary.LittleEndian.Uint32(blocks[0]); magic != byteOrderMagic {
+		t.Fatalf("byte order magic %x", magic)
+	}
+	if link := binary.LittleEndian.Uint16(blocks[1]); link != linkTypeRaw {
+		t.Fatalf("link type %d", link)
+	}
+
+	var packets []packet
+	for i, body := range blocks[2:] {
+		if types[i+2] != blockEnhanced {
+			t.Fatalf("block %d has type %x", i+2, types[i+2])
+		}
+		captured := binary.LittleEndian.Uint32(body[12:])
+		if orig := binary.LittleEndian.Uint32(body[16:]); orig != captured {
+			t.Fatalf("packet %d: captured %d of %d bytes", i, captured, orig)
+		}
+		packets = append(packets, readPacket(t, body[20:20+captured]))
+	}
+	return packets
+}
+
+// readPacket parses an IPv4 packet and checks its checksums
+func readPacket(t *testing.T, ip []byte) packet {
+	t.Helper()
+
+	if ip[0] != 0x45 {
+		t.Fatalf("IP version and header length %x", ip[0])
+	}
+	if total := binary.BigEndian.Uint16(ip[2:]); int(total) != len(ip) {
+		t.Fatalf("IP total length %d, packet is %d", total, len(ip))
+	}
+	if sum := checksum(0, ip[:ipv4HeaderLen]); sum != 0 {
+		t.Fatalf("bad IP header checksum")
+	}
+
+	srcIP := netip.AddrFrom4([4]byte(ip[12:16]))
+	dstIP := netip.AddrFrom4([4]byte(ip[16:20]))
+	segment := ip[ipv4HeaderLen:]
+	p := packet{
+		proto: ip[9],
+		src:   netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(segment[0:])),
+		dst:   netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(segment[2:])),
+	}
+
+	// Summing a segment with its checksum in place gives zero
+	sum := transportChecksum(p.src, p.dst, p.proto, segment)
+	switch p.proto {
+	case protoTCP:
+		if sum != 0 {
+			t.Fatalf("bad TCP checksum")
+		}
+		p.seq = binary.BigEndian.Uint32(segment[4:])
+		p.ack = binary.BigEndian.Uint32(segment[8:])
+		p.flags = segment[13]
+		p.payload = segment[int(segment[12]>>4)*4:]
+	case protoUDP:
+		// A zero UDP checksum means none, so it comes out as 0xffff
+		if sum != 0xffff {
+			t.Fatalf("bad UDP checksum")
+		}
+		if length := binary.BigEndian.Uint16(segment[4:]); int(length) != len(segment) {
+			t.Fatalf("UDP length %d, segment is %d", length, len(segment))
+		}
+		p.payload = segment[udpHeaderLen:]
+	default:
+		t.Fatalf("protocol %d", p.proto)
+	}
+	return p
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	client, server := PeerAddr(1), GameAddr(DefaultTCPPort)
+	big := bytes.Repeat([]byte{7}, maxSegment+100)
+
+	tests := []struct {
+		name   string
+		record func(w *Writer)
+		want   []packet
+	}{
+		{
+			name:   "empty",
+			record: func(w *Writer) {},
+		},
+		{
+			name: "datagrams",
+			record: func(w *Writer) {
+				w.Datagram(client, GameAddr(DefaultUDPPort), []byte("even"))
+				w.Datagram(GameAddr(DefaultUDPPort), client, []byte("odd"))
+			},
+			want: []packet{
+				{src: client, dst: GameAddr(DefaultUDPPort), proto: protoUDP, payload: []byte("even")},
+				{src: GameAddr(DefaultUDPPort), dst: client, proto: protoUDP, payload: []byte("odd")},
+			},
+		},
+		{
+			name: "stream",
+			record: func(w *Writer) {
+				s := w.Stream(client, server)
+				s.Write(true, []byte("hello"))
+				s.Write(false, []byte("world!"))
+				s.Close()
+				s.Close()
+				s.Write(true, []byte("late"))
+			},
+			want: []packet{
+				{src: client, dst: server, proto: protoTCP, flags: tcpSYN, seq: 1000},
+				{src: server, dst: client, proto: protoTCP, flags: tcpSYN | tcpACK, seq: 5000, ack: 1001},
+				{src: client, dst: server, proto: protoTCP, flags: tcpACK, seq: 1001, ack: 5001},
+				{src: client, dst: server, proto: protoTCP, flags: tcpPSH | tcpACK, seq: 1001, ack: 5001, payload: []byte("hello")},
+				{src: server, dst: client, proto: protoTCP, flags: tcpPSH | tcpACK, seq: 5001, ack: 1006, payload: []byte("world!")},
+				{src: client, dst: server, proto: protoTCP, flags: tcpFIN | tcpACK, seq: 1006, ack: 5007},
+				{src: server, dst: client, proto: protoTCP, flags: tcpFIN | tcpACK, seq: 5007, ack: 1007},
+				{src: client, dst: server, proto: protoTCP, flags: tcpACK, seq: 1007, ack: 5008},
+			},
+		},
+		{
+			name: "segmented",
+			record: func(w *Writer) {
+				w.Stream(client, server).Write(true, big)
+			},
+			want: []packet{
+				{src: client, dst: server, proto: protoTCP, flags: tcpSYN, seq: 1000},
+				{src: server, dst: client, proto: protoTCP, flags: tcpSYN | tcpACK, seq: 5000, ack: 1001},
+				{src: client, dst: server, proto: protoTCP, flags: tcpACK, seq: 1001, ack: 5001},
+				{src: client, dst: server, proto: protoTCP, flags: tcpPSH | tcpACK, seq: 1001, ack: 5001, payload: big[:maxSegment]},
+				{src: client, dst: server, proto: protoTCP, flags: tcpPSH | tcpACK, seq: 1001 + maxSegment, ack: 5001, payload: big[maxSegment:]},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.record(w)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			w.Datagram(client, server, []byte("after close"))
+
+			got := readCapture(t, buf.Bytes())
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d packets, want %d", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				g := got[i]
+				if g.src != want.src || g.dst != want.dst || g.proto != want.proto {
+					t.Errorf("packet %d: %v -> %v proto %d, want %v -> %v proto %d", i, g.src, g.dst, g.proto, want.src, want.dst, want.proto)
+				}
+				if g.flags != want.flags || g.seq != want.seq || g.ack != want.ack {
+					t.Errorf("packet %d: flags %x seq %d ack %d, want flags %x seq %d ack %d", i, g.flags, g.seq, g.ack, want.flags, want.seq, want.ack)
+				}
+				if !bytes.Equal(g.payload, want.payload) {
+					t.Errorf("packet %d: payload of %d bytes, want %d", i, len(g.payload), len(want.payload))
+				}
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want uint16
+	}{
+		{"empty", nil, 0xffff},
+		{"RFC 1071 example", []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}, ^uint16(0xddf2)},
+		{"odd length", []byte{0x01}, ^uint16(0x0100)},
+		{"carry", []byte{0xff, 0xff, 0x00, 0x01}, ^uint16(0x0001)},
+	}
+
+	for _, tt := range tests {
+		if got := checksum(0, tt.data); got != tt.want {
+			t.Errorf("%s: checksum %#04x, want %#04x", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package bridge
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -8,6 +9,9 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/capture"
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
 )
 
 // State represents the current state of the bridge
@@ -18,6 +22,7 @@ const (
 	StateWaitingForGame
 	StateReady
 	StateConnectingRelay
+	StateWaitingForPeer
 	StateConnected
 	StateDisconnected
 	StateError
@@ -33,6 +38,8 @@ func (s State) String() string {
 		return "READY"
 	case StateConnectingRelay:
 		return "CONNECTING_RELAY"
+	case StateWaitingForPeer:
+		return "WAITING_FOR_PEER"
 	case StateConnected:
 		return "CONNECTED"
 	case StateDisconnected:
@@ -48,21 +55,42 @@ func (s State) String() string {
 type Stats struct {
 	BytesIn   atomic.Int64
 	BytesOut  atomic.Int64
+	Peers     atomic.Int32 // joiners connected to a multiplexed host
+	RTT       atomic.Int64 // latest relay round trip in nanoseconds; zero until measured
 	StartTime time.Time
 	LastError string
 }
 
 // Bridge handles the local game <-> relay connection
 type Bridge struct {
-	mu            sync.RWMutex
-	state         State
-	targetAddr    string
-	relayConn     net.Conn
-	localConn     net.Conn
-	stats         *Stats
-	onStateChange func(State)
-	stopCh        chan struct{}
-	wg            sync.WaitGroup
+	mu             sync.RWMutex
+	state          State
+	targetAddr     string
+	udpTargetAddr  string
+	relayConn      net.Conn
+	localConn      net.Conn
+	udpRelayConn   net.Conn
+	udpLocalConn   *net.UDPConn
+	mux            bool
+	spectator      bool
+	watchStream    atomic.Uint32 // host stream a spectator plays; zero until the first arrives
+	relayVersion   int
+	streams        map[uint32]*muxStream
+	udpStreams     map[uint32]*net.UDPConn
+	relayWriteMu   sync.Mutex
+	udpWriteMu     sync.Mutex
+	pinging        atomic.Bool
+	peerPaired     atomic.Bool
+	peerLeft       atomic.Bool
+	shutdownSeen   atomic.Bool
+	expirySeen     atomic.Bool
+	captureMu      sync.Mutex
+	capture        *capture.Writer
+	captureStreams map[uint32]*capture.Stream
+	stats          *Stats
+	onStateChange  func(State)
+	stopCh         chan struct{}
+	wg             sync.WaitGroup
 }
 
 // NewBridge creates a new bridge instance
@@ -82,6 +110,23 @@ func (b *Bridge) SetStateChangeCallback(cb func(State)) {
 	b.mu.Unlock()
 }
 
+// SetUDPTarget sets the game's UDP address used by ConnectUDPRelay
+func (b *Bridge) SetUDPTarget(addr string) {
+	b.mu.Lock()
+	b.udpTargetAddr = addr
+	b.mu.Unlock()
+}
+
+// SetSpectator makes this a spectator's bridge. Call it before ConnectRelay.
+// The relay sends spectators the host's data for every joiner; the bridge
+// plays the first joiner's stream to the game, on both channels, and throws
+// away whatever the game sends, since spectators cannot write to the match.
+func (b *Bridge) SetSpectator(enabled bool) {
+	b.mu.Lock()
+	b.spectator = enabled
+	b.mu.Unlock()
+}
+
 // GetState returns the current state
 func (b *Bridge) GetState() State {
 	b.mu.RLock()
@@ -133,10 +178,42 @@ func (b *Bridge) WaitForGame(timeout time.Duration) error {
 	}
 }
 
-// ConnectRelay connects to the relay and starts forwarding
+// ConnectRelay connects to the relay and starts forwarding. A version 2
+// relay says when the peer arrives, so the bridge waits in
+// StateWaitingForPeer and only dials the game then; over version 1 it dials
+// right away.
 func (b *Bridge) ConnectRelay(relayConn net.Conn) error {
 	b.setState(StateConnectingRelay)
 
+	b.mu.Lock()
+	b.relayConn = relayConn
+	b.mu.Unlock()
+
+	if !b.framed() {
+		if err := b.connectLocal(); err != nil {
+			return err
+		}
+		b.wg.Add(1)
+		go b.forwardToLocal()
+		return nil
+	}
+
+	if !isPortListening(b.targetAddr) {
+		b.stats.LastError = fmt.Sprintf("game not reachable at %s", b.targetAddr)
+		b.setState(StateError)
+		return fmt.Errorf("failed to connect to game at %s: not listening", b.targetAddr)
+	}
+
+	b.setState(StateWaitingForPeer)
+
+	b.wg.Add(1)
+	go b.framesToLocal()
+
+	return nil
+}
+
+// connectLocal dials the game and starts forwarding its traffic to the relay
+func (b *Bridge) connectLocal() error {
 	localConn, err := net.DialTimeout("tcp", b.targetAddr, 5*time.Second)
 	if err != nil {
 		b.stats.LastError = fmt.Sprintf("failed to connect to game: %v", err)
@@ -145,15 +222,18 @@ func (b *Bridge) ConnectRelay(relayConn net.Conn) error {
 	}
 
 	b.mu.Lock()
-	b.relayConn = relayConn
+	if b.isStopped() {
+		b.mu.Unlock()
+		localConn.Close()
+		return fmt.Errorf("bridge closed")
+	}
 	b.localConn = localConn
 	b.mu.Unlock()
 
 	b.setState(StateConnected)
 	b.stats.StartTime = time.Now()
 
-	b.wg.Add(2)
-	go b.forwardToLocal()
+	b.wg.Add(1)
 	go b.forwardToRelay()
 
 	return nil
@@ -181,6 +261,7 @@ func (b *Bridge) forwardToLocal() {
 
 		if n > 0 {
 			b.stats.BytesIn.Add(int64(n))
+			b.captureTCP(1, false, buf[:n])
 			if _, err := b.localConn.Write(buf[:n]); err != nil {
 				log.Printf("Error writing to local: %v", err)
 				b.Close()
@@ -190,9 +271,62 @@ func (b *Bridge) forwardToLocal() {
 	}
 }
 
+// framesToLocal is forwardToLocal for a version 2 relay connection, which
+// interleaves control frames with the game's data
+func (b *Bridge) framesToLocal() {
+	defer b.wg.Done()
+
+	for {
+		frame, err := protocol.ReadFrame(b.relayConn)
+		if err != nil {
+			if err != io.EOF && !b.isStopped() {
+				log.Printf("Error reading from relay: %v", err)
+			}
+			b.Close()
+			return
+		}
+
+		if frame.Type != protocol.FrameData {
+			b.handleControl(frame, b.sendFrame)
+			if frame.Type == protocol.FramePeerPaired && b.localConn == nil {
+				b.startKeepalive()
+				if err := b.connectLocal(); err != nil {
+					log.Printf("Peer arrived but the game is unreachable: %v", err)
+					b.Close()
+					return
+				}
+			}
+			continue
+		}
+
+		if b.localConn == nil {
+			// The relay pairs before it forwards, so there is no game to write to yet
+			continue
+		}
+		if b.spectator && !b.watching(frame.Stream) {
+			continue
+		}
+		b.stats.BytesIn.Add(int64(len(frame.Payload)))
+		b.captureTCP(1, false, frame.Payload)
+		if _, err := b.localConn.Write(frame.Payload); err != nil {
+			log.Printf("Error writing to local: %v", err)
+			b.Close()
+			return
+		}
+	}
+}
+
+// watching reports whether a spectator plays host data on stream. The first
+// stream to arrive on either channel is the one it plays.
+func (b *Bridge) watching(stream uint32) bool {
+	b.watchStream.CompareAndSwap(0, stream)
+	return b.watchStream.Load() == stream
+}
+
 func (b *Bridge) forwardToRelay() {
 	defer b.wg.Done()
 
+	framed := b.framed()
 	buf := make([]byte, 32*1024)
 	for {
 		select {
@@ -210,9 +344,15 @@ func (b *Bridge) forwardToRelay() {
 			return
 		}
 
-		if n > 0 {
+		if n > 0 && !b.spectator {
 			b.stats.BytesOut.Add(int64(n))
-			if _, err := b.relayConn.Write(buf[:n]); err != nil {
+			b.captureTCP(1, true, buf[:n])
+			if framed {
+				err = b.sendFrame(&protocol.Frame{Type: protocol.FrameData, Payload: buf[:n]})
+			} else {
+				_, err = b.relayConn.Write(buf[:n])
+			}
+			if err != nil {
 				log.Printf("Error writing to relay: %v", err)
 				b.Close()
 				return
@@ -221,6 +361,132 @@ func (b *Bridge) forwardToRelay() {
 	}
 }
 
+// ConnectUDPRelay forwards datagrams between the game's UDP port and a relay
+// connection on the UDP channel. Each datagram travels as one protocol.Frame.
+func (b *Bridge) ConnectUDPRelay(relayConn net.Conn) error {
+	b.mu.RLock()
+	udpTarget := b.udpTargetAddr
+	b.mu.RUnlock()
+
+	if udpTarget == "" {
+		return fmt.Errorf("no UDP target configured")
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", udpTarget)
+	if err != nil {
+		return fmt.Errorf("invalid UDP target %s: %w", udpTarget, err)
+	}
+
+	localConn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return fmt.Errorf("failed to open UDP socket to %s: %w", udpTarget, err)
+	}
+
+	b.mu.Lock()
+	b.udpRelayConn = relayConn
+	b.udpLocalConn = localConn
+	b.mu.Unlock()
+
+	b.wg.Add(2)
+	go b.forwardDatagramsToLocal()
+	go b.forwardDatagramsToRelay()
+
+	return nil
+}
+
+func (b *Bridge) forwardDatagramsToLocal() {
+	defer b.wg.Done()
+
+	for {
+		frame, err := protocol.ReadFrame(b.udpRelayConn)
+		if err != nil {
+			if err != io.EOF && !b.isStopped() {
+				log.Printf("Error reading datagram from relay: %v", err)
+			}
+			b.closeUDP()
+			return
+		}
+
+		if frame.Type != protocol.FrameData {
+			b.handleControl(frame, b.sendUDPFrame)
+			continue
+		}
+
+		if b.spectator && !b.watching(frame.Stream) {
+			continue
+		}
+		b.stats.BytesIn.Add(int64(len(frame.Payload)))
+		b.captureUDP(1, false, frame.Payload)
+		if _, err := b.udpLocalConn.Write(frame.Payload); err != nil && !b.isStopped() {
+			// The game may not have bound its UDP port yet; drop the datagram
+			log.Printf("Error writing datagram to local: %v", err)
+		}
+	}
+}
+
+func (b *Bridge) forwardDatagramsToRelay() {
+	defer b.wg.Done()
+
+	buf := make([]byte, protocol.MaxPayload)
+	for {
+		n, err := b.udpLocalConn.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || b.isStopped() {
+				return
+			}
+			// ICMP port unreachable surfaces here when the game isn't listening yet
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		if b.spectator {
+			continue
+		}
+		b.stats.BytesOut.Add(int64(n))
+		b.captureUDP(1, true, buf[:n])
+		frame := &protocol.Frame{Type: protocol.FrameData, Payload: buf[:n]}
+		if err := b.sendUDPFrame(frame); err != nil {
+			if !b.isStopped() {
+				log.Printf("Error writing datagram to relay: %v", err)
+			}
+			b.closeUDP()
+			return
+		}
+	}
+}
+
+// closeUDP stops UDP forwarding without tearing down the TCP stream
+func (b *Bridge) closeUDP() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.udpLocalConn != nil {
+		b.udpLocalConn.Close()
+	}
+	if b.udpRelayConn != nil {
+		b.udpRelayConn.Close()
+	}
+	for _, conn := range b.udpStreams {
+		conn.Close()
+	}
+}
+
+// sendUDPFrame writes a frame to the UDP relay connection
+func (b *Bridge) sendUDPFrame(f *protocol.Frame) error {
+	b.udpWriteMu.Lock()
+	defer b.udpWriteMu.Unlock()
+	return protocol.WriteFrame(b.udpRelayConn, f)
+}
+
+func (b *Bridge) isStopped() bool {
+	select {
+	case <-b.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
 // Close stops the bridge and closes all connections
 func (b *Bridge) Close() {
 	b.mu.Lock()
@@ -239,6 +505,19 @@ func (b *Bridge) Close() {
 	if b.relayConn != nil {
 		b.relayConn.Close()
 	}
+	if b.udpLocalConn != nil {
+		b.udpLocalConn.Close()
+	}
+	if b.udpRelayConn != nil {
+		b.udpRelayConn.Close()
+	}
+	for _, s := range b.streams {
+		s.closeLocked()
+	}
+	for _, conn := range b.udpStreams {
+		conn.Close()
+	}
+	b.captureEndAll()
 
 	b.state = StateDisconnected
 }
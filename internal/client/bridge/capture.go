@@ -0,0 +1,93 @@
+package bridge
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/capture"
+)
+
+// SetCapture records the game traffic crossing the bridge to w, as seen from
+// this side of the relay. The game is the server of every captured stream
+// and each remote player a client. Call it before connecting; the caller
+// closes w once the bridge has stopped.
+func (b *Bridge) SetCapture(w *capture.Writer) {
+	b.captureMu.Lock()
+	b.capture = w
+	b.captureStreams = make(map[uint32]*capture.Stream)
+	b.captureMu.Unlock()
+}
+
+// captureTCP records data on the game stream for joiner id, opening the
+// stream on first use
+func (b *Bridge) captureTCP(id uint32, fromGame bool, p []byte) {
+	b.captureMu.Lock()
+	if b.capture == nil {
+		b.captureMu.Unlock()
+		return
+	}
+	s := b.captureStreams[id]
+	if s == nil {
+		s = b.capture.Stream(capture.PeerAddr(id), capture.GameAddr(capturePort(b.targetAddr, capture.DefaultTCPPort)))
+		b.captureStreams[id] = s
+	}
+	b.captureMu.Unlock()
+
+	s.Write(!fromGame, p)
+}
+
+// captureUDP records a datagram to or from the game for joiner id
+func (b *Bridge) captureUDP(id uint32, fromGame bool, p []byte) {
+	b.captureMu.Lock()
+	w := b.capture
+	b.captureMu.Unlock()
+	if w == nil {
+		return
+	}
+
+	b.mu.RLock()
+	game := capture.GameAddr(capturePort(b.udpTargetAddr, capture.DefaultUDPPort))
+	b.mu.RUnlock()
+	if fromGame {
+		w.Datagram(game, capture.PeerAddr(id), p)
+	} else {
+		w.Datagram(capture.PeerAddr(id), game, p)
+	}
+}
+
+// captureEnd records the end of the game stream for joiner id
+func (b *Bridge) captureEnd(id uint32) {
+	b.captureMu.Lock()
+	s := b.captureStreams[id]
+	delete(b.captureStreams, id)
+	b.captureMu.Unlock()
+
+	if s != nil {
+		s.Close()
+	}
+}
+
+// captureEndAll records the end of every open game stream
+func (b *Bridge) captureEndAll() {
+	b.captureMu.Lock()
+	streams := b.captureStreams
+	b.captureStreams = make(map[uint32]*capture.Stream)
+	b.captureMu.Unlock()
+
+	for _, s := range streams {
+		s.Close()
+	}
+}
+
+// capturePort is the port of addr, or def if it has none
+func capturePort(addr string, def uint16) uint16 {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return def
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return def
+	}
+	return uint16(n)
+}
@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"log"
+	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// keepaliveInterval is how often a version 2 bridge pings the relay
+const keepaliveInterval = 15 * time.Second
+
+// SetRelayVersion sets the protocol version agreed with the relay, as
+// reported by transport.RelayClient.Version. From version 2 a joiner's TCP
+// channel is framed and the bridge pings the relay.
+func (b *Bridge) SetRelayVersion(version int) {
+	b.mu.Lock()
+	b.relayVersion = version
+	b.mu.Unlock()
+}
+
+// framed reports whether the TCP relay connection carries frames
+func (b *Bridge) framed() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.mux || b.relayVersion >= 2
+}
+
+// handleControl acts on a control frame from the relay. reply writes to the
+// connection the frame came from.
+func (b *Bridge) handleControl(f *protocol.Frame, reply func(*protocol.Frame) error) {
+	switch f.Type {
+	case protocol.FramePing:
+		reply(&protocol.Frame{Type: protocol.FramePong, Stream: f.Stream, Payload: f.Payload})
+
+	case protocol.FramePong:
+		if len(f.Payload) == 8 {
+			sent := int64(binary.BigEndian.Uint64(f.Payload))
+			b.stats.RTT.Store(time.Now().UnixNano() - sent)
+		}
+
+	case protocol.FramePeerPaired:
+		if !b.peerPaired.Swap(true) {
+			log.Printf("Peer connected through the relay")
+		}
+
+	case protocol.FramePeerLeft:
+		if !b.peerLeft.Swap(true) {
+			log.Printf("Relay closed the session: %s", string(f.Payload))
+		}
+
+	case protocol.FrameShutdown:
+		if !b.shutdownSeen.Swap(true) {
+			log.Printf("Relay is shutting down for maintenance; this session will be closed within %s",
+				protocol.Remaining(f))
+		}
+
+	case protocol.FrameSessionExpiring:
+		if !b.expirySeen.Swap(true) {
+			log.Printf("Session reaches the relay's time limit in %s", protocol.Remaining(f))
+		}
+	}
+}
+
+// startKeepalive starts pinging the relay on the TCP channel, once
+func (b *Bridge) startKeepalive() {
+	if b.pinging.Swap(true) {
+		return
+	}
+	b.wg.Add(1)
+	go b.keepalive()
+}
+
+// keepalive pings the relay, keeping NAT mappings warm and measuring the
+// round trip for Stats.RTT
+func (b *Bridge) keepalive() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		payload := make([]byte, 8)
+		binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+		if err := b.sendFrame(&protocol.Frame{Type: protocol.FramePing, Payload: payload}); err != nil {
+			return
+		}
+
+		select {
+		case <-b.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+const (
+	streamDialTimeout = 5 * time.Second
+	streamQueueLimit  = 256 * 1024 // bytes from the relay a joiner's game connection may fall behind by
+	streamQueueFrames = 1024
+)
+
+// muxStream is one joiner's connection to the local game. Frames from the
+// relay are queued and written by the stream's own goroutine, so dialing the
+// game for a new joiner, or a game slow to read one joiner's data, doesn't
+// hold up the others.
+type muxStream struct {
+	conn   net.Conn // set under the bridge's lock once the dial succeeds
+	queue  chan []byte
+	queued atomic.Int64 // bytes waiting in queue
+	ended  bool         // the relay closed the stream; only demuxToLocal touches it
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newMuxStream() *muxStream {
+	return &muxStream{
+		queue: make(chan []byte, streamQueueFrames),
+		done:  make(chan struct{}),
+	}
+}
+
+// push queues data for the game. It reports false if the stream has fallen
+// too far behind.
+func (s *muxStream) push(p []byte) bool {
+	if s.queued.Add(int64(len(p))) > streamQueueLimit {
+		return false
+	}
+	select {
+	case s.queue <- p:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeLocked stops the stream and closes its game connection. The caller
+// holds the bridge's lock.
+func (s *muxStream) closeLocked() {
+	s.once.Do(func() { close(s.done) })
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// ConnectRelayMux serves a multi-player room on a host. The relay connection
+// carries every joiner as a framed stream, and each joiner gets its own
+// connection to the local game. The bridge stays in StateWaitingForPeer
+// until the first joiner arrives.
+func (b *Bridge) ConnectRelayMux(relayConn net.Conn) error {
+	b.setState(StateConnectingRelay)
+
+	if !isPortListening(b.targetAddr) {
+		b.stats.LastError = fmt.Sprintf("game not reachable at %s", b.targetAddr)
+		b.setState(StateError)
+		return fmt.Errorf("failed to connect to game at %s: not listening", b.targetAddr)
+	}
+
+	b.mu.Lock()
+	b.mux = true
+	b.relayConn = relayConn
+	b.streams = make(map[uint32]*muxStream)
+	b.mu.Unlock()
+
+	b.setState(StateWaitingForPeer)
+	b.stats.StartTime = time.Now()
+
+	b.wg.Add(1)
+	go b.demuxToLocal()
+
+	b.mu.RLock()
+	version := b.relayVersion
+	b.mu.RUnlock()
+	if version >= 2 {
+		// A multiplexing host is served at once, so the relay answers pings now
+		b.startKeepalive()
+	}
+
+	return nil
+}
+
+// Multiplexed reports whether the bridge serves a multi-player room
+func (b *Bridge) Multiplexed() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.mux
+}
+
+// demuxToLocal dispatches frames from the relay to the joiners' game connections
+func (b *Bridge) demuxToLocal() {
+	defer b.wg.Done()
+
+	for {
+		frame, err := protocol.ReadFrame(b.relayConn)
+		if err != nil {
+			if err != io.EOF && !b.isStopped() {
+				log.Printf("Error reading from relay: %v", err)
+			}
+			b.Close()
+			return
+		}
+
+		switch frame.Type {
+		case protocol.FrameOpen:
+			b.openStream(frame.Stream)
+
+		case protocol.FrameData:
+			b.mu.RLock()
+			s := b.streams[frame.Stream]
+			b.mu.RUnlock()
+			if s == nil || s.ended {
+				continue
+			}
+			if !s.push(frame.Payload) {
+				log.Printf("Game fell too far behind joiner %d, dropping it", frame.Stream)
+				b.closeStream(frame.Stream, s, true)
+			}
+
+		case protocol.FrameClose:
+			b.mu.RLock()
+			s := b.streams[frame.Stream]
+			b.mu.RUnlock()
+			if s != nil && !s.ended {
+				// The game gets what is queued before the connection closes
+				s.ended = true
+				close(s.queue)
+			}
+
+		default:
+			b.handleControl(frame, b.sendFrame)
+		}
+	}
+}
+
+// openStream starts connecting a new joiner to the local game. Data for it
+// is queued until the game answers.
+func (b *Bridge) openStream(id uint32) {
+	s := newMuxStream()
+
+	b.mu.Lock()
+	if b.isStopped() {
+		b.mu.Unlock()
+		return
+	}
+	if old := b.streams[id]; old != nil {
+		if old.conn != nil {
+			b.stats.Peers.Add(-1)
+		}
+		old.closeLocked()
+	}
+	b.streams[id] = s
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go b.serveStream(id, s)
+}
+
+// serveStream dials the game for a joiner, then writes what the relay sends
+// it to the game
+func (b *Bridge) serveStream(id uint32, s *muxStream) {
+	defer b.wg.Done()
+
+	conn, err := net.DialTimeout("tcp", b.targetAddr, streamDialTimeout)
+	if err != nil {
+		log.Printf("Failed to connect joiner %d to game at %s: %v", id, b.targetAddr, err)
+		b.closeStream(id, s, true)
+		return
+	}
+
+	b.mu.Lock()
+	if b.streams[id] != s || b.isStopped() {
+		// Replaced or dropped while dialing
+		b.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conn = conn
+	b.mu.Unlock()
+
+	if b.stats.Peers.Add(1) == 1 {
+		b.setState(StateConnected)
+	}
+	log.Printf("Joiner %d connected", id)
+
+	b.wg.Add(1)
+	go b.streamToRelay(id, s)
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case p, ok := <-s.queue:
+			if !ok {
+				b.closeStream(id, s, false)
+				return
+			}
+			s.queued.Add(-int64(len(p)))
+			b.stats.BytesIn.Add(int64(len(p)))
+			b.captureTCP(id, false, p)
+			if _, err := conn.Write(p); err != nil {
+				log.Printf("Error writing to local for joiner %d: %v", id, err)
+				b.closeStream(id, s, true)
+				return
+			}
+		}
+	}
+}
+
+// streamToRelay forwards one joiner's game connection to the relay
+func (b *Bridge) streamToRelay(id uint32, s *muxStream) {
+	defer b.wg.Done()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := s.conn.Read(buf)
+		if n > 0 {
+			b.stats.BytesOut.Add(int64(n))
+			b.captureTCP(id, true, buf[:n])
+			if werr := b.sendFrame(&protocol.Frame{Type: protocol.FrameData, Stream: id, Payload: buf[:n]}); werr != nil {
+				if !b.isStopped() {
+					log.Printf("Error writing to relay: %v", werr)
+				}
+				b.Close()
+				return
+			}
+		}
+		if err != nil {
+			b.closeStream(id, s, true)
+			return
+		}
+	}
+}
+
+// closeStream drops a joiner's game connection, telling the relay if the
+// game side ended it
+func (b *Bridge) closeStream(id uint32, s *muxStream, notify bool) {
+	b.mu.Lock()
+	current := b.streams[id] == s
+	if current {
+		delete(b.streams, id)
+	}
+	connected := s.conn != nil
+	s.closeLocked()
+	b.mu.Unlock()
+
+	if !current {
+		return
+	}
+	if connected {
+		b.captureEnd(id)
+		log.Printf("Joiner %d disconnected", id)
+	}
+	if b.isStopped() {
+		if connected {
+			b.stats.Peers.Add(-1)
+		}
+		return
+	}
+	if connected && b.stats.Peers.Add(-1) == 0 {
+		b.setState(StateWaitingForPeer)
+	}
+	if notify {
+		b.sendFrame(&protocol.Frame{Type: protocol.FrameClose, Stream: id})
+	}
+}
+
+func (b *Bridge) sendFrame(f *protocol.Frame) error {
+	b.relayWriteMu.Lock()
+	defer b.relayWriteMu.Unlock()
+	return protocol.WriteFrame(b.relayConn, f)
+}
+
+// ConnectUDPRelayMux forwards datagrams for a multi-player room. Each joiner
+// gets its own UDP socket so the game sees every joiner as a separate peer.
+func (b *Bridge) ConnectUDPRelayMux(relayConn net.Conn) error {
+	b.mu.Lock()
+	udpTarget := b.udpTargetAddr
+	if udpTarget == "" {
+		b.mu.Unlock()
+		return fmt.Errorf("no UDP target configured")
+	}
+	b.udpRelayConn = relayConn
+	b.udpStreams = make(map[uint32]*net.UDPConn)
+	b.mu.Unlock()
+
+	if _, err := net.ResolveUDPAddr("udp", udpTarget); err != nil {
+		return fmt.Errorf("invalid UDP target %s: %w", udpTarget, err)
+	}
+
+	b.wg.Add(1)
+	go b.demuxDatagrams(udpTarget)
+
+	return nil
+}
+
+// demuxDatagrams dispatches datagram frames from the relay to per-joiner sockets
+func (b *Bridge) demuxDatagrams(udpTarget string) {
+	defer b.wg.Done()
+
+	raddr, _ := net.ResolveUDPAddr("udp", udpTarget)
+
+	for {
+		frame, err := protocol.ReadFrame(b.udpRelayConn)
+		if err != nil {
+			if err != io.EOF && !b.isStopped() {
+				log.Printf("Error reading datagram from relay: %v", err)
+			}
+			b.closeUDP()
+			return
+		}
+
+		switch frame.Type {
+		case protocol.FrameData:
+			conn, err := b.udpStream(frame.Stream, raddr)
+			if err != nil {
+				log.Printf("Failed to open UDP socket for joiner %d: %v", frame.Stream, err)
+				continue
+			}
+			b.stats.BytesIn.Add(int64(len(frame.Payload)))
+			b.captureUDP(frame.Stream, false, frame.Payload)
+			if _, err := conn.Write(frame.Payload); err != nil && !b.isStopped() {
+				// The game may not have bound its UDP port yet; drop the datagram
+				log.Printf("Error writing datagram to local: %v", err)
+			}
+
+		case protocol.FrameClose:
+			b.mu.Lock()
+			conn := b.udpStreams[frame.Stream]
+			delete(b.udpStreams, frame.Stream)
+			b.mu.Unlock()
+			if conn != nil {
+				conn.Close()
+			}
+
+		default:
+			b.handleControl(frame, b.sendUDPFrame)
+		}
+	}
+}
+
+// udpStream returns the joiner's UDP socket, opening it on first use
+func (b *Bridge) udpStream(id uint32, raddr *net.UDPAddr) (*net.UDPConn, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if conn := b.udpStreams[id]; conn != nil {
+		return conn, nil
+	}
+	if b.isStopped() {
+		return nil, fmt.Errorf("bridge closed")
+	}
+
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	b.udpStreams[id] = conn
+
+	b.wg.Add(1)
+	go b.datagramsToRelay(id, conn)
+
+	return conn, nil
+}
+
+// datagramsToRelay forwards the game's datagrams for one joiner to the relay
+func (b *Bridge) datagramsToRelay(id uint32, conn *net.UDPConn) {
+	defer b.wg.Done()
+
+	buf := make([]byte, protocol.MaxPayload)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || b.isStopped() {
+				return
+			}
+			// ICMP port unreachable surfaces here when the game isn't listening yet
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		b.stats.BytesOut.Add(int64(n))
+		b.captureUDP(id, true, buf[:n])
+		err = b.sendUDPFrame(&protocol.Frame{Type: protocol.FrameData, Stream: id, Payload: buf[:n]})
+		if err != nil {
+			if !b.isStopped() {
+				log.Printf("Error writing datagram to relay: %v", err)
+			}
+			b.closeUDP()
+			return
+		}
+	}
+}
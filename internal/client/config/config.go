@@ -5,14 +5,18 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Config holds the client configuration
 type Config struct {
 	TargetHost      string
 	TargetPort      int
+	TargetUDPPort   int
 	SignalingURL    string
 	RelayAddr       string
+	RelayTLS        bool
+	RelayPin        string
 	AlwaysRelay     bool
 	Debug           bool
 	LocalListenPort int
@@ -22,12 +26,13 @@ type Config struct {
 // DefaultConfig returns the default configuration
 func DefaultConfig() *Config {
 	return &Config{
-		TargetHost:   "127.0.0.1",
-		TargetPort:   1626,
-		SignalingURL: "http://localhost:1628",
-		RelayAddr:    "localhost:1627",
-		AlwaysRelay:  true,
-		Debug:        false,
+		TargetHost:    "127.0.0.1",
+		TargetPort:    1626,
+		TargetUDPPort: 1627,
+		SignalingURL:  "http://localhost:1628",
+		RelayAddr:     "localhost:1627",
+		AlwaysRelay:   true,
+		Debug:         false,
 	}
 }
 
@@ -36,17 +41,31 @@ func (c *Config) TargetAddr() string {
 	return net.JoinHostPort(c.TargetHost, strconv.Itoa(c.TargetPort))
 }
 
+// TargetUDPAddr returns the game's UDP address, or "" if UDP forwarding is disabled
+func (c *Config) TargetUDPAddr() string {
+	if c.TargetUDPPort == 0 {
+		return ""
+	}
+	return net.JoinHostPort(c.TargetHost, strconv.Itoa(c.TargetUDPPort))
+}
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
 	if c.TargetPort < 1 || c.TargetPort > 65535 {
 		return fmt.Errorf("invalid target port: %d", c.TargetPort)
 	}
+	if c.TargetUDPPort < 0 || c.TargetUDPPort > 65535 {
+		return fmt.Errorf("invalid target UDP port: %d", c.TargetUDPPort)
+	}
 	if c.SignalingURL == "" {
 		return fmt.Errorf("signaling URL is required")
 	}
 	if c.RelayAddr == "" {
 		return fmt.Errorf("relay address is required")
 	}
+	if c.RelayPin != "" && !c.RelayTLS && !strings.HasPrefix(c.RelayAddr, "wss://") {
+		return fmt.Errorf("relay pin requires relay TLS or a wss:// relay address")
+	}
 	return nil
 }
 
@@ -60,12 +79,23 @@ func (c *Config) LoadFromEnv() {
 			c.TargetPort = p
 		}
 	}
+	if port := os.Getenv("SFO_TARGET_UDP_PORT"); port != "" {
+		if p, err := strconv.Atoi(port); err == nil {
+			c.TargetUDPPort = p
+		}
+	}
 	if url := os.Getenv("SFO_SIGNALING_URL"); url != "" {
 		c.SignalingURL = url
 	}
 	if addr := os.Getenv("SFO_RELAY_ADDR"); addr != "" {
 		c.RelayAddr = addr
 	}
+	if tls := os.Getenv("SFO_RELAY_TLS"); tls == "true" || tls == "1" {
+		c.RelayTLS = true
+	}
+	if pin := os.Getenv("SFO_RELAY_PIN"); pin != "" {
+		c.RelayPin = pin
+	}
 	if debug := os.Getenv("SFO_DEBUG"); debug == "true" || debug == "1" {
 		c.Debug = true
 	}
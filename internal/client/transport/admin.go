@@ -0,0 +1,185 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// AdminClient talks to a relay's admin API
+type AdminClient struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// AdminPeer is one client connected to the relay
+type AdminPeer struct {
+	Role        string    `json:"role"`
+	Channel     string    `json:"channel"`
+	Stream      uint32    `json:"stream"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// AdminPending is a client waiting for its peer
+type AdminPending struct {
+	SessionID string `json:"sessionId"`
+	AdminPeer
+	WaitingSeconds float64 `json:"waitingSeconds"`
+}
+
+// AdminSession is a session that is relaying traffic
+type AdminSession struct {
+	SessionID       string      `json:"sessionId"`
+	Peers           []AdminPeer `json:"peers"`
+	BytesToHost     int64       `json:"bytesToHost"`
+	BytesFromHost   int64       `json:"bytesFromHost"`
+	StartedAt       time.Time   `json:"startedAt"`
+	DurationSeconds float64     `json:"durationSeconds"`
+	IdleSeconds     float64     `json:"idleSeconds"`
+}
+
+// AdminSessions is the relay's view of its connections
+type AdminSessions struct {
+	Pending  []AdminPending `json:"pending"`
+	Sessions []AdminSession `json:"sessions"`
+}
+
+// NewAdminClient creates a new admin client authenticating with token
+func NewAdminClient(baseURL, token string) *AdminClient {
+	return &AdminClient{
+		baseURL: baseURL,
+		token:   token,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// ListSessions lists pending connections and active sessions
+func (c *AdminClient) ListSessions() (*AdminSessions, error) {
+	resp, err := c.do(http.MethodGet, "/admin/sessions")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, adminError("list sessions", resp)
+	}
+
+	var result AdminSessions
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// KillSession disconnects every client of a session
+func (c *AdminClient) KillSession(sessionID string) error {
+	resp, err := c.do(http.MethodDelete, "/admin/sessions/"+url.PathEscape(sessionID))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("session not connected to the relay")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return adminError("kill session", resp)
+	}
+
+	return nil
+}
+
+// StartCapture has the relay record a session's traffic to a pcapng file.
+// It returns the file's path on the relay.
+func (c *AdminClient) StartCapture(sessionID string) (string, error) {
+	resp, err := c.do(http.MethodPost, "/admin/sessions/"+url.PathEscape(sessionID)+"/capture")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", adminError("start capture", resp)
+	}
+
+	var result struct {
+		File string `json:"file"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.File, nil
+}
+
+// StopCapture stops recording a session's traffic and closes its capture file
+func (c *AdminClient) StopCapture(sessionID string) error {
+	resp, err := c.do(http.MethodDelete, "/admin/sessions/"+url.PathEscape(sessionID)+"/capture")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("session is not being captured")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return adminError("stop capture", resp)
+	}
+
+	return nil
+}
+
+// Drain asks the relay to stop taking new games and shut down once current
+// matches end. It returns how many sessions are still active.
+func (c *AdminClient) Drain() (int, error) {
+	resp, err := c.do(http.MethodPost, "/admin/drain")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, adminError("drain", resp)
+	}
+
+	var result struct {
+		ActiveSessions int `json:"activeSessions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.ActiveSessions, nil
+}
+
+func (c *AdminClient) do(method, path string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to admin API: %w", err)
+	}
+	return resp, nil
+}
+
+func adminError(op string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%s failed: invalid admin token", op)
+	}
+	return fmt.Errorf("%s failed: %w", op, readError(resp, protocol.CodeNotFound))
+}
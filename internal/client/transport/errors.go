@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// Errors for the codes the relay and signaling server send when they refuse
+// a request. Check for them with errors.Is.
+var (
+	ErrInvalidCode        = errors.New("invalid or expired join code")
+	ErrSessionNotFound    = errors.New("session not found or expired")
+	ErrSessionFull        = errors.New("session is full")
+	ErrSpectatorsDenied   = errors.New("the host does not allow spectators")
+	ErrJoinerNotFound     = errors.New("no such joiner in the session")
+	ErrRateLimited        = errors.New("rate limit exceeded, please wait and try again")
+	ErrShuttingDown       = errors.New("server is restarting, please try again in a few minutes")
+	ErrNoRelay            = errors.New("no relay server is available, please try again later")
+	ErrRelayUnavailable   = errors.New("the session's relay server is unavailable")
+	ErrInvalidToken       = errors.New("relay token was rejected")
+	ErrTokenUsed          = errors.New("relay token was already used")
+	ErrKicked             = errors.New("the host removed you from the session")
+	ErrUnauthorized       = errors.New("not authorized")
+	ErrRelayFull          = errors.New("relay server is full")
+	ErrTooManyConnections = errors.New("too many connections to the relay from this address")
+)
+
+var codeErrors = map[protocol.ErrorCode]error{
+	protocol.CodeInvalidCode:        ErrInvalidCode,
+	protocol.CodeSessionNotFound:    ErrSessionNotFound,
+	protocol.CodeSessionFull:        ErrSessionFull,
+	protocol.CodeSpectatorsDenied:   ErrSpectatorsDenied,
+	protocol.CodeJoinerNotFound:     ErrJoinerNotFound,
+	protocol.CodeRateLimited:        ErrRateLimited,
+	protocol.CodeShuttingDown:       ErrShuttingDown,
+	protocol.CodeNoRelay:            ErrNoRelay,
+	protocol.CodeRelayUnavailable:   ErrRelayUnavailable,
+	protocol.CodeInvalidToken:       ErrInvalidToken,
+	protocol.CodeTokenMismatch:      ErrInvalidToken,
+	protocol.CodeTokenUsed:          ErrTokenUsed,
+	protocol.CodeKicked:             ErrKicked,
+	protocol.CodeUnauthorized:       ErrUnauthorized,
+	protocol.CodeServerFull:         ErrRelayFull,
+	protocol.CodeTooManyConnections: ErrTooManyConnections,
+}
+
+// ServerError is a request the relay or signaling server refused. It wraps
+// the Err value for its code, if the client knows the code.
+type ServerError struct {
+	Code    protocol.ErrorCode
+	Message string // the server's own explanation
+	Status  int    // HTTP status; zero for the relay
+}
+
+func (e *ServerError) Error() string {
+	if err := codeErrors[e.Code]; err != nil {
+		return err.Error()
+	}
+	if e.Status != 0 {
+		return fmt.Sprintf("%s (status %d)", e.Message, e.Status)
+	}
+	return e.Message
+}
+
+func (e *ServerError) Unwrap() error {
+	return codeErrors[e.Code]
+}
+
+// statusCodes stands in for the error code of servers that predate codes
+var statusCodes = map[int]protocol.ErrorCode{
+	http.StatusBadRequest:         protocol.CodeBadRequest,
+	http.StatusUnauthorized:       protocol.CodeUnauthorized,
+	http.StatusConflict:           protocol.CodeSessionFull,
+	http.StatusTooManyRequests:    protocol.CodeRateLimited,
+	http.StatusServiceUnavailable: protocol.CodeShuttingDown,
+}
+
+// readError turns an HTTP error response into a *ServerError. notFound is
+// the code a 404 from an older server stands for.
+func readError(resp *http.Response, notFound protocol.ErrorCode) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	var eb protocol.ErrorBody
+	if err := json.Unmarshal(body, &eb); err != nil || eb.Code == "" {
+		eb.Error = strings.TrimSpace(string(body))
+		eb.Code = statusCodes[resp.StatusCode]
+		if resp.StatusCode == http.StatusNotFound {
+			eb.Code = notFound
+		}
+	}
+	return &ServerError{Code: eb.Code, Message: eb.Error, Status: resp.StatusCode}
+}
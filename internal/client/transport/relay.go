@@ -1,37 +1,80 @@
 package transport
 
 import (
-	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net"
+	"strings"
 	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// Relay channels. The TCP channel carries the game's byte stream; the UDP
+// channel carries its datagrams, one protocol.Frame per datagram. With
+// protocol version 2 the TCP channel is framed too.
+const (
+	ChannelTCP = "tcp"
+	ChannelUDP = "udp"
 )
 
 // RelayClient handles connection to the relay server
 type RelayClient struct {
-	addr      string
-	useTLS    bool
-	conn      net.Conn
-	sessionID string
-	role      string
+	addr        string
+	useTLS      bool
+	pin         []byte // SHA-256 of the relay's certificate; nil uses CA verification
+	conn        net.Conn
+	sessionID   string
+	role        string
+	channel     string
+	mux         bool
+	version     int
+	resumeToken string
+	onLost      func(error)
+	onResumed   func()
 }
 
 // AuthMessage is sent to authenticate with the relay
 type AuthMessage struct {
-	SessionID  string `json:"sessionId"`
-	RelayToken string `json:"relayToken"`
-	Role       string `json:"role"`
+	SessionID   string `json:"sessionId"`
+	RelayToken  string `json:"relayToken"`
+	Role        string `json:"role"`
+	Channel     string `json:"channel,omitempty"`
+	Mux         bool   `json:"mux,omitempty"`
+	Resumable   bool   `json:"resumable,omitempty"`
+	ResumeToken string `json:"resumeToken,omitempty"`
+	RecvSeq     uint64 `json:"recvSeq,omitempty"`
+	Version     int    `json:"version,omitempty"`
 }
 
 // AuthResponse is received after authentication
 type AuthResponse struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error,omitempty"`
+	Success     bool               `json:"success"`
+	Error       string             `json:"error,omitempty"`
+	Code        protocol.ErrorCode `json:"code,omitempty"`
+	Mux         bool               `json:"mux,omitempty"`
+	ResumeToken string             `json:"resumeToken,omitempty"`
+	RecvSeq     uint64             `json:"recvSeq,omitempty"`
+	Version     int                `json:"version,omitempty"`
 }
 
-// NewRelayClient creates a new relay client
+// authError describes a failed authentication. It wraps a *ServerError, so
+// errors.Is matches the Err value for the relay's code.
+func authError(resp *AuthResponse) error {
+	return fmt.Errorf("relay authentication failed: %w", &ServerError{Code: resp.Code, Message: resp.Error})
+}
+
+// resumeGrace is how long the client keeps trying to resume a lost stream.
+// It matches the relay's default hold time.
+const resumeGrace = 30 * time.Second
+
+// NewRelayClient creates a new relay client. addr is host:port, or a ws:// or
+// wss:// URL for relays behind HTTP-only firewalls; the URL's scheme then
+// decides TLS and useTLS is ignored.
 func NewRelayClient(addr string, useTLS bool) *RelayClient {
 	return &RelayClient{
 		addr:   addr,
@@ -39,66 +82,237 @@ func NewRelayClient(addr string, useTLS bool) *RelayClient {
 	}
 }
 
-// Connect connects to the relay server and authenticates
+// Sibling returns an unconnected client for the same relay with the same TLS
+// and multiplexing settings, for opening another channel of a session
+func (c *RelayClient) Sibling() *RelayClient {
+	return &RelayClient{
+		addr:   c.addr,
+		useTLS: c.useTLS,
+		pin:    c.pin,
+		mux:    c.mux,
+	}
+}
+
+// SetPinnedFingerprint makes TLS connections trust only the relay certificate
+// with the given SHA-256 fingerprint instead of verifying it against CAs.
+// Colons and case in fingerprint are ignored.
+func (c *RelayClient) SetPinnedFingerprint(fingerprint string) error {
+	if fingerprint == "" {
+		c.pin = nil
+		return nil
+	}
+	pin, err := ParseFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+	c.pin = pin
+	return nil
+}
+
+// ParseFingerprint parses a SHA-256 certificate fingerprint written as hex,
+// with or without colons
+func ParseFingerprint(fingerprint string) ([]byte, error) {
+	clean := strings.NewReplacer(":", "", " ", "").Replace(strings.TrimSpace(fingerprint))
+	pin, err := hex.DecodeString(clean)
+	if err != nil || len(pin) != sha256.Size {
+		return nil, fmt.Errorf("invalid relay fingerprint %q: expected a SHA-256 hex digest", fingerprint)
+	}
+	return pin, nil
+}
+
+// SetReconnectCallbacks sets callbacks invoked when the relay connection is
+// lost and when the session has been resumed on a new connection
+func (c *RelayClient) SetReconnectCallbacks(onLost func(error), onResumed func()) {
+	c.onLost = onLost
+	c.onResumed = onResumed
+}
+
+// SetMultiplexed makes a host connection carry all of its joiners. Traffic
+// then arrives as protocol frames tagged with each joiner's stream ID.
+func (c *RelayClient) SetMultiplexed(enabled bool) {
+	c.mux = enabled
+}
+
+// Connect connects to the relay server and authenticates on the TCP channel
 func (c *RelayClient) Connect(sessionID, relayToken, role string) error {
+	return c.ConnectChannel(sessionID, relayToken, role, ChannelTCP)
+}
+
+// ConnectChannel connects to the relay server and authenticates on the given
+// channel. If the relay supports it, the returned connection transparently
+// reconnects and resumes the session after a transient network failure.
+func (c *RelayClient) ConnectChannel(sessionID, relayToken, role, channel string) error {
 	c.sessionID = sessionID
 	c.role = role
+	c.channel = channel
 
-	var conn net.Conn
-	var err error
+	conn, err := c.dial()
+	if err != nil {
+		return fmt.Errorf("failed to connect to relay: %w", err)
+	}
+
+	authResp, err := c.authenticate(conn, &AuthMessage{
+		SessionID:  sessionID,
+		RelayToken: relayToken,
+		Role:       role,
+		Channel:    channel,
+		Mux:        c.mux,
+		Resumable:  true,
+		Version:    protocol.Version,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	if !authResp.Success {
+		conn.Close()
+		return authError(authResp)
+	}
+
+	if c.mux && !authResp.Mux {
+		conn.Close()
+		return fmt.Errorf("relay does not support multi-player rooms")
+	}
+
+	// Relays that predate version 2 don't say, and speak version 1
+	c.version = authResp.Version
+	if c.version == 0 {
+		c.version = 1
+	}
 
+	if authResp.ResumeToken == "" {
+		// Relay without session resume support
+		c.conn = conn
+		return nil
+	}
+
+	c.resumeToken = authResp.ResumeToken
+	rc := protocol.NewResumableConn(conn, protocol.DefaultResumeWindow, resumeGrace)
+	rc.SetRedial(c.redial)
+	rc.SetCallbacks(c.onLost, c.onResumed)
+	c.conn = rc
+	return nil
+}
+
+// redial reconnects to the relay and resumes the stream
+func (c *RelayClient) redial(recvSeq uint64) (net.Conn, uint64, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	authResp, err := c.authenticate(conn, &AuthMessage{
+		SessionID:   c.sessionID,
+		Role:        c.role,
+		Channel:     c.channel,
+		ResumeToken: c.resumeToken,
+		RecvSeq:     recvSeq,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+
+	if !authResp.Success {
+		conn.Close()
+		return nil, 0, fmt.Errorf("%w: %s", protocol.ErrResumeRejected, authResp.Error)
+	}
+
+	return conn, authResp.RecvSeq, nil
+}
+
+func (c *RelayClient) dial() (net.Conn, error) {
+	if IsWebSocketAddr(c.addr) {
+		return dialWebSocket(c.addr, c.pin, 10*time.Second)
+	}
 	if c.useTLS {
-		conn, err = tls.DialWithDialer(
+		return tls.DialWithDialer(
 			&net.Dialer{Timeout: 10 * time.Second},
 			"tcp",
 			c.addr,
-			&tls.Config{MinVersion: tls.VersionTLS13},
+			pinnedTLSConfig(c.pin),
 		)
-	} else {
-		conn, err = net.DialTimeout("tcp", c.addr, 10*time.Second)
 	}
+	return net.DialTimeout("tcp", c.addr, 10*time.Second)
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to connect to relay: %w", err)
+// formatFingerprint writes a fingerprint as colon-separated hex, as the server prints it
+func formatFingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
 	}
+	return strings.Join(parts, ":")
+}
 
-	conn.SetDeadline(time.Now().Add(15 * time.Second))
+// pinnedTLSConfig returns the client TLS config. With a pin, the relay's
+// self-signed certificate is trusted if and only if its fingerprint matches.
+func pinnedTLSConfig(pin []byte) *tls.Config {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS13}
+	if pin == nil {
+		return cfg
+	}
 
-	authMsg := AuthMessage{
-		SessionID:  sessionID,
-		RelayToken: relayToken,
-		Role:       role,
+	cfg.InsecureSkipVerify = true // replaced by the fingerprint check below
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("relay presented no certificate")
+		}
+		sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+		if !bytes.Equal(sum[:], pin) {
+			return fmt.Errorf("relay certificate fingerprint mismatch: got %s", formatFingerprint(sum[:]))
+		}
+		return nil
 	}
+	return cfg
+}
+
+// authenticate sends authMsg and reads the relay's response
+func (c *RelayClient) authenticate(conn net.Conn, authMsg *AuthMessage) (*AuthResponse, error) {
+	conn.SetDeadline(time.Now().Add(15 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
 	data, _ := json.Marshal(authMsg)
 	data = append(data, '\n')
 
 	if _, err := conn.Write(data); err != nil {
-		conn.Close()
-		return fmt.Errorf("failed to send auth message: %w", err)
+		return nil, fmt.Errorf("failed to send auth message: %w", err)
 	}
 
-	reader := bufio.NewReader(conn)
-	respLine, err := reader.ReadBytes('\n')
+	respLine, err := readLine(conn)
 	if err != nil {
-		conn.Close()
-		return fmt.Errorf("failed to read auth response: %w", err)
+		return nil, fmt.Errorf("failed to read auth response: %w", err)
 	}
 
 	var authResp AuthResponse
 	if err := json.Unmarshal(respLine, &authResp); err != nil {
-		conn.Close()
-		return fmt.Errorf("invalid auth response: %w", err)
+		return nil, fmt.Errorf("invalid auth response: %w", err)
 	}
 
-	if !authResp.Success {
-		conn.Close()
-		return fmt.Errorf("relay authentication failed: %s", authResp.Error)
-	}
+	return &authResp, nil
+}
 
-	conn.SetDeadline(time.Time{})
+// readLine reads a single newline-terminated line without reading past it,
+// since stream data may follow the auth response immediately
+func readLine(conn net.Conn) ([]byte, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < 4096 {
+		if _, err := conn.Read(b); err != nil {
+			return nil, err
+		}
+		if b[0] == '\n' {
+			return line, nil
+		}
+		line = append(line, b[0])
+	}
+	return nil, fmt.Errorf("auth response too long")
+}
 
-	c.conn = conn
-	return nil
+// Version returns the protocol version agreed with the relay
+func (c *RelayClient) Version() int {
+	return c.version
 }
 
 // GetConn returns the underlying connection for forwarding
@@ -116,15 +330,23 @@ func (c *RelayClient) Close() error {
 
 // CheckRelayReachable tests if the relay server is reachable
 func CheckRelayReachable(addr string, useTLS bool) error {
+	return CheckRelayReachablePinned(addr, useTLS, nil)
+}
+
+// CheckRelayReachablePinned is CheckRelayReachable for a relay whose
+// certificate is pinned by fingerprint (see ParseFingerprint)
+func CheckRelayReachablePinned(addr string, useTLS bool, pin []byte) error {
 	var conn net.Conn
 	var err error
 
-	if useTLS {
+	if IsWebSocketAddr(addr) {
+		conn, err = dialWebSocket(addr, pin, 5*time.Second)
+	} else if useTLS {
 		conn, err = tls.DialWithDialer(
 			&net.Dialer{Timeout: 5 * time.Second},
 			"tcp",
 			addr,
-			&tls.Config{MinVersion: tls.VersionTLS13},
+			pinnedTLSConfig(pin),
 		)
 	} else {
 		conn, err = net.DialTimeout("tcp", addr, 5*time.Second)
@@ -1,43 +1,118 @@
 package transport
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
 )
 
 // SignalingClient communicates with the signaling server
 type SignalingClient struct {
-	baseURL    string
-	httpClient *http.Client
+	baseURL         string
+	region          string
+	allowSpectators bool
+	lobby           *Lobby
+	hostToken       string
+	hostSession     string // session created by CreateSession
+	httpClient      *http.Client
+}
+
+// RelayAssignment is the relay node signaling assigned to a session in a
+// relay cluster. It is empty when the server runs a single relay, and the
+// client uses its configured relay.
+type RelayAssignment struct {
+	RelayNode string `json:"relayNode,omitempty"`
+	RelayAddr string `json:"relayAddr,omitempty"`
+	RelayTLS  bool   `json:"relayTLS,omitempty"`
+	RelayPin  string `json:"relayPin,omitempty"`
 }
 
 // CreateSessionResponse is the response from creating a session
 type CreateSessionResponse struct {
-	SessionID  string `json:"sessionId"`
-	Code       string `json:"code"`
-	HostToken  string `json:"hostToken"`
-	RelayToken string `json:"relayToken"`
-	ExpiresAt  int64  `json:"expiresAt"`
+	SessionID       string `json:"sessionId"`
+	Code            string `json:"code"`
+	HostToken       string `json:"hostToken"`
+	RelayToken      string `json:"relayToken"`
+	ExpiresAt       int64  `json:"expiresAt"`
+	AllowSpectators bool   `json:"allowSpectators"`
+	Public          bool   `json:"public"`
+	RelayAssignment
 }
 
 // JoinSessionResponse is the response from joining a session
 type JoinSessionResponse struct {
 	SessionID     string `json:"sessionId"`
+	JoinerID      uint32 `json:"joinerId"`
 	JoinToken     string `json:"joinToken"`
 	RelayToken    string `json:"relayToken"`
 	HostConnected bool   `json:"hostConnected"`
+	RelayAssignment
 }
 
-// SessionStatus is the status of a session
-type SessionStatus struct {
+// SpectateSessionResponse is the response from spectating a session
+type SpectateSessionResponse struct {
 	SessionID     string `json:"sessionId"`
+	SpectatorID   uint32 `json:"spectatorId"`
+	RelayToken    string `json:"relayToken"`
 	HostConnected bool   `json:"hostConnected"`
-	JoinConnected bool   `json:"joinConnected"`
-	ExpiresAt     int64  `json:"expiresAt"`
+	RelayAssignment
+}
+
+// SessionStatus is the status of a session, as its host sees it
+type SessionStatus struct {
+	SessionID       string          `json:"sessionId"`
+	Code            string          `json:"code"`
+	HostConnected   bool            `json:"hostConnected"`
+	JoinConnected   bool            `json:"joinConnected"`
+	Joiners         []SessionJoiner `json:"joiners"`
+	MaxJoiners      int             `json:"maxJoiners"`
+	AllowSpectators bool            `json:"allowSpectators"`
+	Spectators      int             `json:"spectators"`
+	RelayNode       string          `json:"relayNode,omitempty"`
+	CreatedAt       int64           `json:"createdAt"`
+	ExpiresAt       int64           `json:"expiresAt"`
+}
+
+// SessionJoiner is a joiner admitted to a session
+type SessionJoiner struct {
+	ID       uint32 `json:"id"`
+	JoinedAt int64  `json:"joinedAt"`
+}
+
+// Lobby is what the host of a public session shows in the lobby browser
+type Lobby struct {
+	Nickname    string `json:"nickname,omitempty"`
+	Region      string `json:"region,omitempty"`
+	Skill       string `json:"skill,omitempty"`
+	GameVersion string `json:"gameVersion,omitempty"`
+}
+
+// LobbyListing is a public session in the lobby browser
+type LobbyListing struct {
+	Code string `json:"code"`
+	Lobby
+	Players         int   `json:"players"`
+	MaxPlayers      int   `json:"maxPlayers"`
+	AllowSpectators bool  `json:"allowSpectators"`
+	CreatedAt       int64 `json:"createdAt"`
+}
+
+// LobbyFilter narrows down ListLobbies. Empty fields match every lobby.
+type LobbyFilter struct {
+	Region      string
+	GameVersion string
+	Search      string // matched against the nickname and skill note
+	IncludeFull bool
 }
 
 // NewSignalingClient creates a new signaling client
@@ -50,48 +125,108 @@ func NewSignalingClient(baseURL string) *SignalingClient {
 	}
 }
 
+// SetRegion sets the region whose relay node CreateSession and JoinSession
+// ask for when the server runs a relay cluster
+func (c *SignalingClient) SetRegion(region string) {
+	c.region = region
+}
+
+// SetAllowSpectators sets whether sessions made by CreateSession admit
+// spectators
+func (c *SignalingClient) SetAllowSpectators(allow bool) {
+	c.allowSpectators = allow
+}
+
+// SetLobby makes sessions created by CreateSession public, listed in the
+// lobby browser with lobby's details. A nil lobby keeps them private.
+func (c *SignalingClient) SetLobby(lobby *Lobby) {
+	c.lobby = lobby
+}
+
+// SetHostToken sets the host token that authenticates the session
+// management calls. CreateSession sets it to the new session's token.
+func (c *SignalingClient) SetHostToken(token string) {
+	c.hostToken = token
+}
+
 // CreateSession creates a new session
 func (c *SignalingClient) CreateSession() (*CreateSessionResponse, error) {
-	resp, err := c.httpClient.Post(c.baseURL+"/session/create", "application/json", nil)
+	var reqBody io.Reader
+	if c.region != "" || c.allowSpectators || c.lobby != nil {
+		req := map[string]interface{}{}
+		if c.region != "" {
+			req["region"] = c.region
+		}
+		if c.allowSpectators {
+			req["allowSpectators"] = true
+		}
+		if c.lobby != nil {
+			req["public"] = true
+			req["lobby"] = c.lobby
+		}
+		b, _ := json.Marshal(req)
+		reqBody = bytes.NewReader(b)
+	}
+	resp, err := c.httpClient.Post(c.baseURL+"/session/create", "application/json", reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to signaling server: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("create session failed: %s (status %d)", string(body), resp.StatusCode)
+		return nil, readError(resp, protocol.CodeNotFound)
 	}
 
 	var result CreateSessionResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
+	c.hostToken = result.HostToken
+	c.hostSession = result.SessionID
 
 	return &result, nil
 }
 
 // JoinSession joins an existing session with a code
 func (c *SignalingClient) JoinSession(code string) (*JoinSessionResponse, error) {
-	reqBody, _ := json.Marshal(map[string]string{"code": code})
+	req := map[string]string{"code": code}
+	if c.region != "" {
+		req["region"] = c.region
+	}
+	reqBody, _ := json.Marshal(req)
 	resp, err := c.httpClient.Post(c.baseURL+"/session/join", "application/json", bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to signaling server: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("invalid or expired join code")
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp, protocol.CodeInvalidCode)
 	}
-	if resp.StatusCode == http.StatusTooManyRequests {
-		return nil, fmt.Errorf("rate limit exceeded, please wait and try again")
+
+	var result JoinSessionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
+
+	return &result, nil
+}
+
+// SpectateSession asks to watch a session with its join code. The host must
+// have allowed spectators.
+func (c *SignalingClient) SpectateSession(code string) (*SpectateSessionResponse, error) {
+	reqBody, _ := json.Marshal(map[string]string{"code": code})
+	resp, err := c.httpClient.Post(c.baseURL+"/session/spectate", "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to signaling server: %w", err)
+	}
+	defer resp.Body.Close()
+
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("join session failed: %s (status %d)", string(body), resp.StatusCode)
+		return nil, readError(resp, protocol.CodeInvalidCode)
 	}
 
-	var result JoinSessionResponse
+	var result SpectateSessionResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
@@ -99,20 +234,56 @@ func (c *SignalingClient) JoinSession(code string) (*JoinSessionResponse, error)
 	return &result, nil
 }
 
-// GetSessionStatus gets the current status of a session
-func (c *SignalingClient) GetSessionStatus(sessionID string) (*SessionStatus, error) {
-	resp, err := c.httpClient.Get(c.baseURL + "/session/" + sessionID + "/status")
+// ListLobbies lists the public sessions that match filter
+func (c *SignalingClient) ListLobbies(filter LobbyFilter) ([]LobbyListing, error) {
+	q := url.Values{}
+	if filter.Region != "" {
+		q.Set("region", filter.Region)
+	}
+	if filter.GameVersion != "" {
+		q.Set("version", filter.GameVersion)
+	}
+	if filter.Search != "" {
+		q.Set("q", filter.Search)
+	}
+	if filter.IncludeFull {
+		q.Set("full", "1")
+	}
+	u := c.baseURL + "/lobbies"
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	resp, err := c.httpClient.Get(u)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to signaling server: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("session not found or expired")
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp, protocol.CodeNotFound)
+	}
+
+	var result struct {
+		Lobbies []LobbyListing `json:"lobbies"`
 	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return result.Lobbies, nil
+}
+
+// GetSessionStatus gets the current status of a session. It needs the
+// session's host token.
+func (c *SignalingClient) GetSessionStatus(sessionID string) (*SessionStatus, error) {
+	resp, err := c.hostRequest(http.MethodGet, sessionID, "/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("get status failed: %s (status %d)", string(body), resp.StatusCode)
+		return nil, readError(resp, protocol.CodeSessionNotFound)
 	}
 
 	var result SessionStatus
@@ -123,6 +294,241 @@ func (c *SignalingClient) GetSessionStatus(sessionID string) (*SessionStatus, er
 	return &result, nil
 }
 
+// CancelSession ends a session: its code stops working and its players are
+// disconnected. It needs the session's host token.
+func (c *SignalingClient) CancelSession(sessionID string) error {
+	resp, err := c.hostRequest(http.MethodDelete, sessionID, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return readError(resp, protocol.CodeSessionNotFound)
+	}
+	return nil
+}
+
+// ExtendSession pushes a session's expiry back to a full TTL from now and
+// returns the new expiry. It needs the session's host token.
+func (c *SignalingClient) ExtendSession(sessionID string) (time.Time, error) {
+	resp, err := c.hostRequest(http.MethodPost, sessionID, "/extend", nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, readError(resp, protocol.CodeSessionNotFound)
+	}
+
+	var result struct {
+		ExpiresAt int64 `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return time.Unix(result.ExpiresAt, 0), nil
+}
+
+// KickJoiner removes a joiner from a session, or every joiner if joinerID is
+// zero, and returns the IDs of the joiners removed. Kicked joiners are
+// disconnected and their tokens stop working. It needs the session's host
+// token.
+func (c *SignalingClient) KickJoiner(sessionID string, joinerID uint32) ([]uint32, error) {
+	resp, err := c.hostRequest(http.MethodPost, sessionID, "/kick", map[string]uint32{"joinerId": joinerID})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp, protocol.CodeSessionNotFound)
+	}
+
+	var result struct {
+		Kicked []uint32 `json:"kicked"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return result.Kicked, nil
+}
+
+// RegenerateCode gives a session a new join code and returns it. The old
+// code stops working. It needs the session's host token.
+func (c *SignalingClient) RegenerateCode(sessionID string) (string, error) {
+	resp, err := c.hostRequest(http.MethodPost, sessionID, "/code", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", readError(resp, protocol.CodeSessionNotFound)
+	}
+
+	var result struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	return result.Code, nil
+}
+
+// SetSessionSpectators sets whether a running session admits spectators.
+// Spectators already watching are not disconnected when they are denied. It
+// needs the session's host token.
+func (c *SignalingClient) SetSessionSpectators(sessionID string, allow bool) error {
+	resp, err := c.hostRequest(http.MethodPost, sessionID, "/spectators", map[string]bool{"allow": allow})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp, protocol.CodeSessionNotFound)
+	}
+	return nil
+}
+
+// maxEventBackoff caps the wait between attempts to reopen a broken event
+// stream
+const maxEventBackoff = 30 * time.Second
+
+// Subscribe streams the lifecycle events of the session CreateSession
+// created. The channel is closed once the session ends or ctx is cancelled.
+// A broken stream is reopened, but events sent while it was down are
+// missed; GetSessionStatus tells where the session stands. After an
+// EventExpired signaling no longer knows the session, so a stream that
+// breaks then can't be reopened and the channel is closed.
+func (c *SignalingClient) Subscribe(ctx context.Context) (<-chan protocol.SessionEvent, error) {
+	if c.hostSession == "" {
+		return nil, errors.New("no session to subscribe to")
+	}
+	body, err := c.openEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan protocol.SessionEvent, 16)
+	go func() {
+		defer close(events)
+		for {
+			ended := readEvents(ctx, body, events)
+			body.Close()
+			if ended || ctx.Err() != nil {
+				return
+			}
+
+			backoff := time.Second
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				body, err = c.openEvents(ctx)
+				var serverErr *ServerError
+				if errors.As(err, &serverErr) {
+					// The session is gone or the host token was revoked
+					return
+				}
+				if err == nil {
+					break
+				}
+				if backoff *= 2; backoff > maxEventBackoff {
+					backoff = maxEventBackoff
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// openEvents opens the host's event stream
+func (c *SignalingClient) openEvents(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/session/"+url.PathEscape(c.hostSession)+"/events", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.hostToken)
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The stream stays open for as long as the session, past httpClient's timeout
+	stream := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := stream.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to signaling server: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, readError(resp, protocol.CodeSessionNotFound)
+	}
+	return resp.Body, nil
+}
+
+// readEvents passes the server-sent events in body on to events until the
+// stream ends. It reports whether the session ended.
+func readEvents(ctx context.Context, body io.Reader, events chan<- protocol.SessionEvent) bool {
+	scanner := bufio.NewScanner(body)
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			// Event names repeat the type in the data; comments keep the stream alive
+			if v, ok := strings.CutPrefix(line, "data:"); ok {
+				data.WriteString(strings.TrimPrefix(v, " "))
+			}
+			continue
+		}
+		if data.Len() == 0 {
+			continue
+		}
+
+		var e protocol.SessionEvent
+		err := json.Unmarshal([]byte(data.String()), &e)
+		data.Reset()
+		if err != nil {
+			continue
+		}
+		select {
+		case events <- e:
+		case <-ctx.Done():
+			return false
+		}
+		if e.Type == protocol.EventEnded {
+			return true
+		}
+	}
+	return false
+}
+
+// hostRequest sends a session management request authenticated with the
+// host token
+func (c *SignalingClient) hostRequest(method, sessionID, action string, body interface{}) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.baseURL+"/session/"+url.PathEscape(sessionID)+action, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.hostToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to signaling server: %w", err)
+	}
+	return resp, nil
+}
+
 // Health checks if the signaling server is reachable
 func (c *SignalingClient) Health() error {
 	resp, err := c.httpClient.Get(c.baseURL + "/health")
@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// IsWebSocketAddr reports whether a relay address is a ws:// or wss:// URL
+func IsWebSocketAddr(addr string) bool {
+	return strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://")
+}
+
+// dialWebSocket connects to a relay's WebSocket endpoint. A URL without a
+// path gets the relay's default /relay. wss:// verifies the certificate like
+// a TLS relay, honouring pin.
+func dialWebSocket(addr string, pin []byte, timeout time.Duration) (net.Conn, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid relay URL %q: %w", addr, err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/relay"
+	}
+
+	origin := &url.URL{Scheme: "http", Host: u.Host}
+	if u.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+
+	config, err := websocket.NewConfig(u.String(), origin.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid relay URL %q: %w", addr, err)
+	}
+	if u.Scheme == "wss" {
+		config.TlsConfig = pinnedTLSConfig(pin)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ws, err := config.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
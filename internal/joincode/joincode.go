@@ -0,0 +1,285 @@
+// Package joincode generates the short codes players type to join a
+// session and turns what they typed back into the code that was issued.
+// Codes are drawn uniformly from Crockford's base32 alphabet, which leaves
+// out I, L, O and U, optionally followed by a check character, or from a
+// list of words.
+package joincode
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+)
+
+// Crockford is Crockford's base32 alphabet
+const Crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Code formats
+const (
+	FormatBase32 = "base32"
+	FormatWords  = "words"
+)
+
+// groupSize is how many characters of a base32 code are shown together
+const groupSize = 3
+
+// Errors returned by Generate and Normalize
+var (
+	ErrExhausted  = errors.New("no free join code found")
+	ErrInvalid    = errors.New("not a valid join code")
+	ErrCheckDigit = errors.New("join code has a typo")
+)
+
+// aliases are characters players type for ones they misread, used when the
+// alphabet lacks the first and has the second
+var aliases = map[rune]rune{'O': '0', 'I': '1', 'L': '1'}
+
+// Config describes the codes a Generator makes. Zero Format, Length,
+// Alphabet, Words and Attempts take the defaults.
+type Config struct {
+	Format     string   // FormatBase32 (default) or FormatWords
+	Length     int      // characters, or words, drawn at random (default 5, or 3 words)
+	Alphabet   string   // characters of base32 codes (default Crockford)
+	CheckDigit bool     // append a check character to base32 codes
+	Words      []string // word list for FormatWords (default WordList)
+	Prefix     string   // put in front of every code, e.g. "SFO"
+	Attempts   int      // codes to try before giving up on collisions (default 16)
+}
+
+// DefaultConfig is the format the signaling servers use unless configured:
+// "SFO-" and five base32 characters plus a check character, about 33
+// million codes
+var DefaultConfig = Config{
+	Format:     FormatBase32,
+	Length:     5,
+	CheckDigit: true,
+	Prefix:     "SFO",
+}
+
+// Generator makes join codes in one format
+type Generator struct {
+	cfg   Config
+	words map[string]bool
+}
+
+// New checks cfg and returns a Generator for it
+func New(cfg Config) (*Generator, error) {
+	if cfg.Format == "" {
+		cfg.Format = FormatBase32
+	}
+	if cfg.Attempts <= 0 {
+		cfg.Attempts = 16
+	}
+	cfg.Prefix = strings.ToUpper(cfg.Prefix)
+	if strings.IndexFunc(cfg.Prefix, func(c rune) bool { return !unicode.IsLetter(c) && !unicode.IsDigit(c) }) >= 0 {
+		return nil, fmt.Errorf("join code prefix may only hold letters and digits")
+	}
+
+	g := &Generator{}
+	switch cfg.Format {
+	case FormatBase32:
+		if cfg.Length == 0 {
+			cfg.Length = 5
+		}
+		if cfg.Alphabet == "" {
+			cfg.Alphabet = Crockford
+		}
+		cfg.Alphabet = strings.ToUpper(cfg.Alphabet)
+		if len(cfg.Alphabet) < 2 {
+			return nil, fmt.Errorf("join code alphabet needs at least 2 characters")
+		}
+		for i, c := range cfg.Alphabet {
+			if c > unicode.MaxASCII || !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				return nil, fmt.Errorf("join code alphabet may only hold letters and digits, not %q", c)
+			}
+			if strings.IndexRune(cfg.Alphabet, c) != i {
+				return nil, fmt.Errorf("join code alphabet repeats %q", c)
+			}
+		}
+	case FormatWords:
+		if cfg.Length == 0 {
+			cfg.Length = 3
+		}
+		if cfg.Words == nil {
+			cfg.Words = WordList
+		}
+		words := make([]string, len(cfg.Words))
+		g.words = make(map[string]bool, len(cfg.Words))
+		for i, w := range cfg.Words {
+			w = strings.ToUpper(w)
+			if w == "" || strings.IndexFunc(w, func(c rune) bool { return !unicode.IsLetter(c) }) >= 0 {
+				return nil, fmt.Errorf("join code word %q may only hold letters", w)
+			}
+			if g.words[w] {
+				return nil, fmt.Errorf("join code word list repeats %q", w)
+			}
+			g.words[w] = true
+			words[i] = w
+		}
+		cfg.Words = words
+		if len(cfg.Words) < 2 {
+			return nil, fmt.Errorf("join code word list needs at least 2 words")
+		}
+	default:
+		return nil, fmt.Errorf("unknown join code format %q", cfg.Format)
+	}
+	if cfg.Length < 1 {
+		return nil, fmt.Errorf("join code length must be at least 1")
+	}
+
+	g.cfg = cfg
+	return g, nil
+}
+
+// Default returns a Generator for DefaultConfig
+func Default() *Generator {
+	g, err := New(DefaultConfig)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// Generate returns a random code for which taken reports false, trying
+// again when a code is already in use
+func (g *Generator) Generate(taken func(code string) bool) (string, error) {
+	for i := 0; i < g.cfg.Attempts; i++ {
+		code, err := g.random()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate join code: %w", err)
+		}
+		if taken == nil || !taken(code) {
+			return code, nil
+		}
+	}
+	return "", ErrExhausted
+}
+
+// Normalize turns a code as a player typed it into the form Generate
+// returned: case, spacing, dashes and the prefix don't matter, and
+// characters commonly misread for others are corrected. It returns
+// ErrCheckDigit for a base32 code whose check character doesn't match, and
+// ErrInvalid for anything else that cannot be a code.
+func (g *Generator) Normalize(input string) (string, error) {
+	fields := strings.FieldsFunc(strings.ToUpper(input), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+
+	if g.cfg.Format == FormatWords {
+		if len(fields) == g.cfg.Length+1 && g.cfg.Prefix != "" && fields[0] == g.cfg.Prefix {
+			fields = fields[1:]
+		}
+		if len(fields) != g.cfg.Length {
+			return "", ErrInvalid
+		}
+		for _, w := range fields {
+			if !g.words[w] {
+				return "", ErrInvalid
+			}
+		}
+		return g.format(fields), nil
+	}
+
+	body := strings.Join(fields, "")
+	size := g.cfg.Length
+	if g.cfg.CheckDigit {
+		size++
+	}
+	// Strip the prefix only when what's left is the length of a code, as a
+	// code may itself start with the prefix's letters
+	if len(body) == len(g.cfg.Prefix)+size && strings.HasPrefix(body, g.cfg.Prefix) {
+		body = body[len(g.cfg.Prefix):]
+	}
+	if len(body) != size {
+		return "", ErrInvalid
+	}
+
+	b := []byte(body)
+	for i, c := range b {
+		if strings.IndexByte(g.cfg.Alphabet, c) >= 0 {
+			continue
+		}
+		to, ok := aliases[rune(c)]
+		if !ok || strings.IndexRune(g.cfg.Alphabet, to) < 0 {
+			return "", ErrInvalid
+		}
+		b[i] = byte(to)
+	}
+	if g.cfg.CheckDigit && checkChar(g.cfg.Alphabet, b[:g.cfg.Length]) != b[g.cfg.Length] {
+		return "", ErrCheckDigit
+	}
+	return g.format(groups(string(b))), nil
+}
+
+// random draws one code
+func (g *Generator) random() (string, error) {
+	if g.cfg.Format == FormatWords {
+		words := make([]string, g.cfg.Length)
+		for i := range words {
+			n, err := randIndex(len(g.cfg.Words))
+			if err != nil {
+				return "", err
+			}
+			words[i] = g.cfg.Words[n]
+		}
+		return g.format(words), nil
+	}
+
+	b := make([]byte, g.cfg.Length, g.cfg.Length+1)
+	for i := range b {
+		n, err := randIndex(len(g.cfg.Alphabet))
+		if err != nil {
+			return "", err
+		}
+		b[i] = g.cfg.Alphabet[n]
+	}
+	if g.cfg.CheckDigit {
+		b = append(b, checkChar(g.cfg.Alphabet, b))
+	}
+	return g.format(groups(string(b))), nil
+}
+
+// format joins the parts of a code with dashes after the prefix
+func (g *Generator) format(parts []string) string {
+	if g.cfg.Prefix != "" {
+		parts = append([]string{g.cfg.Prefix}, parts...)
+	}
+	return strings.Join(parts, "-")
+}
+
+// groups splits s into runs of groupSize characters
+func groups(s string) []string {
+	var out []string
+	for len(s) > groupSize {
+		out = append(out, s[:groupSize])
+		s = s[groupSize:]
+	}
+	return append(out, s)
+}
+
+// randIndex returns a uniformly random index below n
+func randIndex(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
+
+// checkChar computes the Luhn mod N check character of body over alphabet.
+// It catches any one mistyped character and most swaps of two neighbouring
+// ones.
+func checkChar(alphabet string, body []byte) byte {
+	n := len(alphabet)
+	factor := 2
+	sum := 0
+	for i := len(body) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(alphabet, body[i])
+		factor = 3 - factor
+		sum += addend/n + addend%n
+	}
+	return alphabet[(n-sum%n)%n]
+}
@@ -0,0 +1,163 @@
+package joincode
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// withCheck appends body's check character over Crockford's alphabet
+func withCheck(body string) string {
+	return body + string(checkChar(Crockford, []byte(body)))
+}
+
+func TestCheckCharCatchesTypos(t *testing.T) {
+	tests := []string{"5EPTB", "00000", "ZZZZZ", "01234", "VWXYZ"}
+
+	for _, body := range tests {
+		code := withCheck(body)
+		for i := 0; i < len(body); i++ {
+			for _, c := range []byte(Crockford) {
+				if c == body[i] {
+					continue
+				}
+				typo := []byte(code)
+				typo[i] = c
+				if checkChar(Crockford, typo[:len(body)]) == typo[len(body)] {
+					t.Errorf("%s: typo %s passes the check", code, typo)
+				}
+			}
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	g := Default()
+	aliased := withCheck("01X4Z")
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		err   error
+	}{
+		{"as issued", "SFO-5EP-TBB", "SFO-5EP-TBB", nil},
+		{"lower case", "sfo-5ep-tbb", "SFO-5EP-TBB", nil},
+		{"no dashes", "SFO5EPTBB", "SFO-5EP-TBB", nil},
+		{"no prefix", "5EP-TBB", "SFO-5EP-TBB", nil},
+		{"spaces", " 5ep tbb ", "SFO-5EP-TBB", nil},
+		{"misread letters", "SFO-" + strings.NewReplacer("0", "o", "1", "l").Replace(aliased), "SFO-" + aliased[:3] + "-" + aliased[3:], nil},
+		{"typo", "SFO-5EP-TBC", "", ErrCheckDigit},
+		{"swapped", "SFO-E5P-TBB", "", ErrCheckDigit},
+		{"too short", "SFO-5EP-TB", "", ErrInvalid},
+		{"too long", "SFO-5EP-TBBB", "", ErrInvalid},
+		{"not in alphabet", "SFO-5EP-TBU", "", ErrInvalid},
+		{"empty", "", "", ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		got, err := g.Normalize(tt.input)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("%s: Normalize(%q) = %q, %v; want %q, %v", tt.name, tt.input, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestWordCodes(t *testing.T) {
+	g, err := New(Config{Format: FormatWords, Words: []string{"squid", "lion", "jewel"}, Prefix: "sfo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		err   error
+	}{
+		{"as issued", "SFO-SQUID-LION-JEWEL", "SFO-SQUID-LION-JEWEL", nil},
+		{"lower case", "sfo-squid-lion-jewel", "SFO-SQUID-LION-JEWEL", nil},
+		{"no prefix", "squid lion jewel", "SFO-SQUID-LION-JEWEL", nil},
+		{"repeated word", "lion lion lion", "SFO-LION-LION-LION", nil},
+		{"unknown word", "squid lion tiger", "", ErrInvalid},
+		{"too few words", "squid lion", "", ErrInvalid},
+		{"too many words", "SFO-SQUID-LION-JEWEL-LION", "", ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		got, err := g.Normalize(tt.input)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("%s: Normalize(%q) = %q, %v; want %q, %v", tt.name, tt.input, got, err, tt.want, tt.err)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		code, err := g.Generate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := g.Normalize(code); err != nil || got != code {
+			t.Fatalf("Normalize(%q) = %q, %v", code, got, err)
+		}
+	}
+	if WordList[0] != strings.ToLower(WordList[0]) {
+		t.Error("New changed the default word list")
+	}
+}
+
+func TestGenerateRetriesCollisions(t *testing.T) {
+	tests := []struct {
+		name      string
+		taken     int // codes reported taken before one is free; -1 for all
+		wantCalls int
+		err       error
+	}{
+		{"free", 0, 1, nil},
+		{"collisions", 3, 4, nil},
+		{"last attempt", 4, 5, nil},
+		{"exhausted", -1, 5, ErrExhausted},
+	}
+
+	for _, tt := range tests {
+		g, err := New(Config{Attempts: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls := 0
+		code, err := g.Generate(func(string) bool {
+			calls++
+			return tt.taken < 0 || calls <= tt.taken
+		})
+		if !errors.Is(err, tt.err) || calls != tt.wantCalls {
+			t.Errorf("%s: %d calls, %v; want %d calls, %v", tt.name, calls, err, tt.wantCalls, tt.err)
+		}
+		if err == nil {
+			if _, err := g.Normalize(code); err != nil {
+				t.Errorf("%s: generated %q does not normalize: %v", tt.name, code, err)
+			}
+		}
+	}
+}
+
+func TestNewRejectsBadConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"unknown format", Config{Format: "emoji"}},
+		{"prefix punctuation", Config{Prefix: "SF-O"}},
+		{"short alphabet", Config{Alphabet: "A"}},
+		{"repeated character", Config{Alphabet: "ABCA"}},
+		{"alphabet punctuation", Config{Alphabet: "AB-"}},
+		{"negative length", Config{Length: -1}},
+		{"one word", Config{Format: FormatWords, Words: []string{"lion"}}},
+		{"repeated word", Config{Format: FormatWords, Words: []string{"lion", "LION"}}},
+		{"word with digits", Config{Format: FormatWords, Words: []string{"lion", "r2d2"}}},
+	}
+
+	for _, tt := range tests {
+		if _, err := New(tt.cfg); err == nil {
+			t.Errorf("%s: New accepted %+v", tt.name, tt.cfg)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package joincode
+
+// WordList is the default word list for FormatWords codes: 256 short,
+// distinct English words, so three words give about 16.7 million codes
+var WordList = []string{
+	"acid", "acorn", "actor", "agent", "album", "alert", "alien", "alpha",
+	"amber", "angel", "ankle", "apple", "apron", "arena", "arrow", "atlas",
+	"attic", "autumn", "badge", "bagel", "baker", "bamboo", "banjo", "barn",
+	"basil", "beach", "beard", "berry", "bison", "blade", "blaze", "bloom",
+	"board", "bonus", "boots", "brave", "bread", "brick", "bridge", "brook",
+	"brush", "bucket", "buddy", "bugle", "cabin", "cable", "cactus", "camel",
+	"candle", "canoe", "canyon", "cargo", "carrot", "castle", "cedar",
+	"chalk", "cherry", "chess", "chief", "cider", "cinema", "circus",
+	"citrus", "clay", "cliff", "cloud", "clover", "coast", "cobra", "cocoa",
+	"comet", "coral", "cotton", "cougar", "crane", "crater", "crown",
+	"crystal", "cube", "cycle", "daisy", "dance", "delta", "denim", "desert",
+	"diesel", "dingo", "disco", "dolphin", "donkey", "dragon", "drum",
+	"eagle", "echo", "elbow", "ember", "engine", "falcon", "feather", "fern",
+	"ferry", "fiddle", "flame", "flute", "forest", "fossil", "fox", "galaxy",
+	"garden", "garlic", "gecko", "ginger", "glacier", "globe", "goose",
+	"granite", "grape", "gravel", "guitar", "hammer", "harbor", "hazel",
+	"helmet", "hero", "hippo", "honey", "hornet", "igloo", "island", "ivory",
+	"jacket", "jaguar", "jelly", "jewel", "jungle", "kayak", "kettle", "kiwi",
+	"koala", "ladder", "lagoon", "lemon", "lily", "lion", "llama", "lobster",
+	"lotus", "lunar", "magnet", "mango", "maple", "marble", "meadow", "melon",
+	"meteor", "mint", "mirror", "mongoose", "moose", "mosaic", "motor",
+	"mustard", "nectar", "needle", "ninja", "noodle", "nova", "nugget",
+	"oasis", "ocean", "olive", "onion", "opal", "orbit", "orchid", "otter",
+	"owl", "oyster", "paddle", "panda", "papaya", "parrot", "pebble",
+	"pepper", "piano", "pickle", "pilot", "pine", "pirate", "planet", "plum",
+	"polar", "pony", "potato", "prism", "pumpkin", "puzzle", "quartz",
+	"quill", "rabbit", "radar", "raven", "reef", "rhino", "ribbon", "river",
+	"robin", "rocket", "rodeo", "ruby", "saddle", "salmon", "sapphire",
+	"saturn", "scarf", "shadow", "shark", "shell", "sierra", "silver",
+	"sketch", "sloth", "snow", "sonic", "spark", "spider", "sponge", "squid",
+	"storm", "sugar", "summit", "sunset", "swan", "tango", "temple",
+	"thunder", "tiger", "timber", "toast", "tomato", "topaz", "torch",
+	"tornado", "tractor", "tulip", "tundra", "turtle", "umbrella", "unicorn",
+	"valley", "velvet", "violet", "volcano", "wafer", "walnut", "walrus",
+}
@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/joincode"
 )
 
 // Session holds WebRTC signaling data
@@ -28,6 +30,7 @@ type SignalServer struct {
 	byCodes  map[string]string
 	mu       sync.RWMutex
 	ttl      time.Duration
+	codes    *joincode.Generator
 }
 
 // NewSignalServer creates a new signaling server
@@ -36,6 +39,7 @@ func NewSignalServer(ttl time.Duration) *SignalServer {
 		sessions: make(map[string]*Session),
 		byCodes:  make(map[string]string),
 		ttl:      ttl,
+		codes:    joincode.Default(),
 	}
 	go s.cleanupLoop()
 	return s
@@ -178,7 +182,13 @@ func (s *SignalServer) CreateSession() (*Session, error) {
 	defer s.mu.Unlock()
 
 	id := generateID()
-	code := generateCode()
+	code, err := s.codes.Generate(func(code string) bool {
+		_, taken := s.byCodes[code]
+		return taken
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	session := &Session{
 		ID:          id,
@@ -196,6 +206,11 @@ func (s *SignalServer) CreateSession() (*Session, error) {
 
 // JoinSession finds a session by code
 func (s *SignalServer) JoinSession(code string) (*Session, error) {
+	code, err := s.codes.Normalize(code)
+	if err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -290,14 +305,3 @@ func generateID() string {
 	rand.Read(b)
 	return fmt.Sprintf("%x", b)
 }
-
-func generateCode() string {
-	const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, 4)
-	rand.Read(b)
-	code := make([]byte, 4)
-	for i := 0; i < 4; i++ {
-		code[i] = chars[int(b[i])%len(chars)]
-	}
-	return fmt.Sprintf("SFO-%s", string(code))
-}
@@ -0,0 +1,37 @@
+package protocol
+
+// ErrorCode says why the relay or signaling server refused a request. The
+// relay sends it in its auth response and signaling in the "code" field of
+// its JSON error bodies, next to a message meant for people. Clients decide
+// what to do from the code, never the message.
+type ErrorCode string
+
+const (
+	CodeBadRequest         ErrorCode = "bad_request"          // malformed request or wrong method
+	CodeUnauthorized       ErrorCode = "unauthorized"         // missing or wrong credentials
+	CodeNotFound           ErrorCode = "not_found"            // no such endpoint or resource
+	CodeRateLimited        ErrorCode = "rate_limited"         // too many requests from this address
+	CodeShuttingDown       ErrorCode = "shutting_down"        // draining before a restart
+	CodeInternal           ErrorCode = "internal"             // the server failed
+	CodeInvalidCode        ErrorCode = "invalid_code"         // join code is wrong or expired
+	CodeSessionNotFound    ErrorCode = "session_not_found"    // session ID is wrong or expired
+	CodeSessionFull        ErrorCode = "session_full"         // session has all the joiners it takes
+	CodeSpectatorsDenied   ErrorCode = "spectators_denied"    // host does not allow spectators
+	CodeJoinerNotFound     ErrorCode = "joiner_not_found"     // session has no joiner with that ID
+	CodeNoRelay            ErrorCode = "no_relay"             // no relay node can take a new session
+	CodeRelayUnavailable   ErrorCode = "relay_unavailable"    // the session's relay node is gone
+	CodeInvalidToken       ErrorCode = "invalid_token"        // relay token is forged or expired
+	CodeTokenMismatch      ErrorCode = "token_mismatch"       // relay token is for another session or role
+	CodeTokenUsed          ErrorCode = "token_used"           // relay token was already used on this channel
+	CodeKicked             ErrorCode = "kicked"               // the host removed this joiner from the session
+	CodeServerFull         ErrorCode = "server_full"          // relay is at its session or waiting limit
+	CodeTooManyConnections ErrorCode = "too_many_connections" // relay's per-address limit
+	CodeResumeRejected     ErrorCode = "resume_rejected"      // stream to resume is gone
+	CodeCaptureActive      ErrorCode = "capture_active"       // session is already being captured
+)
+
+// ErrorBody is the JSON body of an HTTP error response from signaling
+type ErrorBody struct {
+	Error string    `json:"error"`
+	Code  ErrorCode `json:"code"`
+}
@@ -0,0 +1,27 @@
+package protocol
+
+// EventType names a session lifecycle event. Signaling streams them to the
+// host from GET /session/{id}/events as server-sent events, with the type
+// as the SSE event name and a SessionEvent as the data.
+type EventType string
+
+const (
+	EventJoinerClaimed        EventType = "joiner-claimed"         // a joiner redeemed the join code
+	EventHostRelayConnected   EventType = "host-relay-connected"   // the host reached the relay on a channel
+	EventJoinerRelayConnected EventType = "joiner-relay-connected" // a joiner reached the relay on a channel
+	EventPaired               EventType = "paired"                 // the relay started forwarding for a joiner
+	EventExpiring             EventType = "expiring"               // the session ends soon unless extended
+	EventExpired              EventType = "expired"                // the join code expired; players on the relay play on
+	EventEnded                EventType = "ended"                  // the session is over; the stream closes
+)
+
+// SessionEvent is one event in a session's event stream
+type SessionEvent struct {
+	Type      EventType `json:"type"`
+	SessionID string    `json:"sessionId"`
+	JoinerID  uint32    `json:"joinerId,omitempty"`
+	Channel   string    `json:"channel,omitempty"`
+	Reason    string    `json:"reason,omitempty"`    // why the session is ending
+	ExpiresAt int64     `json:"expiresAt,omitempty"` // when an expiring session ends, unix seconds
+	Time      int64     `json:"time"`                // unix seconds
+}
@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Version is the newest relay protocol this build speaks. In version 1 a
+// joiner's TCP channel is a raw byte pipe; only UDP channels and
+// multiplexing hosts are framed. Version 2 frames every channel, so the
+// relay and clients can exchange control frames alongside game data.
+const Version = 2
+
+// FrameType identifies the kind of frame
+type FrameType uint8
+
+const (
+	// FrameData carries a single datagram or a chunk of stream data
+	FrameData FrameType = 1
+	// FrameOpen announces a new joiner stream to a multiplexing host
+	FrameOpen FrameType = 2
+	// FrameClose ends a joiner stream; either side may send it. From the
+	// relay the payload may give the reason as text.
+	FrameClose FrameType = 3
+	// FrameShutdown warns that the relay is draining. Its payload is the
+	// number of seconds left before the relay closes remaining sessions.
+	FrameShutdown FrameType = 4
+
+	// Control frames from version 2. Clients ignore types they don't know.
+
+	// FramePing asks the other side to echo the payload in a FramePong
+	FramePing FrameType = 5
+	// FramePong answers a FramePing
+	FramePong FrameType = 6
+	// FramePeerPaired tells a waiting client its peer has arrived
+	FramePeerPaired FrameType = 7
+	// FramePeerLeft tells a client its peer, or with stream zero the whole
+	// session, is gone. The payload gives the reason as text.
+	FramePeerLeft FrameType = 8
+	// FrameSessionExpiring warns that the session will hit its time limit.
+	// Its payload is the number of seconds left.
+	FrameSessionExpiring FrameType = 9
+)
+
+// MaxPayload is the largest payload a single frame may carry
+const MaxPayload = 64 * 1024
+
+const headerSize = 9
+
+// Frame is a single length-prefixed message on a framed relay channel.
+// Wire format: type (1 byte) | stream (4 bytes) | length (4 bytes) | payload
+type Frame struct {
+	Type    FrameType
+	Stream  uint32
+	Payload []byte
+}
+
+// WriteFrame writes a frame to w in a single call
+func WriteFrame(w io.Writer, f *Frame) error {
+	if len(f.Payload) > MaxPayload {
+		return fmt.Errorf("frame payload too large: %d bytes", len(f.Payload))
+	}
+
+	buf := make([]byte, headerSize+len(f.Payload))
+	buf[0] = byte(f.Type)
+	binary.BigEndian.PutUint32(buf[1:5], f.Stream)
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(f.Payload)))
+	copy(buf[headerSize:], f.Payload)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFrame reads the next frame from r
+func ReadFrame(r io.Reader) (*Frame, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[5:9])
+	if length > MaxPayload {
+		return nil, fmt.Errorf("frame payload too large: %d bytes", length)
+	}
+
+	f := &Frame{
+		Type:    FrameType(header[0]),
+		Stream:  binary.BigEndian.Uint32(header[1:5]),
+		Payload: make([]byte, length),
+	}
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// NewCountdownFrame builds a FrameShutdown or FrameSessionExpiring
+// announcing how long is left
+func NewCountdownFrame(t FrameType, remaining time.Duration) *Frame {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(remaining/time.Second))
+	return &Frame{Type: t, Payload: payload}
+}
+
+// Remaining returns the time left announced by a countdown frame
+func Remaining(f *Frame) time.Duration {
+	if len(f.Payload) < 4 {
+		return 0
+	}
+	return time.Duration(binary.BigEndian.Uint32(f.Payload)) * time.Second
+}
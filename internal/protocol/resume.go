@@ -0,0 +1,449 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultResumeWindow is how many recently written bytes are kept for replay
+const DefaultResumeWindow = 1024 * 1024
+
+// ErrResumeWindow is returned when a peer asks to resume from a position that
+// is no longer held in the replay buffer
+var ErrResumeWindow = errors.New("resume position outside replay window")
+
+// ErrResumeRejected is returned by a RedialFunc when the other end refuses to
+// resume the stream; the stream is closed without further attempts
+var ErrResumeRejected = errors.New("resume rejected")
+
+// RedialFunc re-establishes the underlying connection of a ResumableConn.
+// It is given the number of bytes received so far and must return the new
+// connection together with the number of bytes the peer has received.
+type RedialFunc func(recvSeq uint64) (conn net.Conn, peerRecvSeq uint64, err error)
+
+// ResumableConn is a byte stream that survives the loss of its underlying
+// connection. Both ends count the bytes they have read; written bytes are kept
+// in a bounded replay buffer so that, after a reconnect, each side can resend
+// exactly what the other has not yet received.
+//
+// The relay side waits for the client to come back with Attach; the client
+// side redials on its own when a RedialFunc is set. A clean close (EOF) from
+// the peer is final, while any other error starts the grace period.
+type ResumableConn struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	writeMu sync.Mutex
+
+	conn    net.Conn // nil while detached
+	gen     uint64   // bumped every time conn is retired
+	recvSeq uint64
+	sentSeq uint64
+	replay  []byte // tail of the written stream, ending at sentSeq
+	window  int
+
+	grace      time.Duration
+	graceTimer *time.Timer
+	redial     RedialFunc
+	onDetach   func(error)
+	onResume   func()
+
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	err error // terminal error once the stream is dead
+}
+
+// NewResumableConn wraps conn. A zero grace period disables resuming.
+func NewResumableConn(conn net.Conn, window int, grace time.Duration) *ResumableConn {
+	c := &ResumableConn{
+		conn:       conn,
+		window:     window,
+		grace:      grace,
+		localAddr:  conn.LocalAddr(),
+		remoteAddr: conn.RemoteAddr(),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// SetRedial makes the stream reconnect on its own after a failure
+func (c *ResumableConn) SetRedial(fn RedialFunc) {
+	c.mu.Lock()
+	c.redial = fn
+	c.mu.Unlock()
+}
+
+// SetCallbacks sets callbacks invoked when the underlying connection is lost
+// and when the stream is resumed on a new one
+func (c *ResumableConn) SetCallbacks(onDetach func(error), onResume func()) {
+	c.mu.Lock()
+	c.onDetach = onDetach
+	c.onResume = onResume
+	c.mu.Unlock()
+}
+
+// Read reads from the current connection, waiting out reconnects
+func (c *ResumableConn) Read(p []byte) (int, error) {
+	for {
+		conn, gen, err := c.current()
+		if err != nil {
+			return 0, err
+		}
+
+		n, rerr := conn.Read(p)
+
+		c.mu.Lock()
+		if gen != c.gen {
+			// The connection was retired mid-read; the peer resends these bytes
+			c.mu.Unlock()
+			continue
+		}
+		c.recvSeq += uint64(n)
+		c.mu.Unlock()
+
+		if n > 0 {
+			return n, nil
+		}
+		if rerr == io.EOF {
+			c.fail(io.EOF)
+			return 0, io.EOF
+		}
+		if isTimeout(rerr) {
+			return 0, rerr
+		}
+		if rerr != nil {
+			c.lost(gen, rerr)
+		}
+	}
+}
+
+// Write records p for replay and writes it to the current connection. If the
+// connection is lost, Write blocks until the stream is resumed or dies.
+func (c *ResumableConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.Lock()
+	for c.conn == nil && c.err == nil {
+		c.cond.Wait()
+	}
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return 0, err
+	}
+	c.appendReplay(p)
+	conn, gen := c.conn, c.gen
+	c.mu.Unlock()
+
+	if n, err := conn.Write(p); err != nil {
+		if isTimeout(err) {
+			// A deadline is the caller's business, not a lost connection; forget
+			// the part that never went out so the sequence numbers stay in step
+			c.mu.Lock()
+			c.unappendReplay(len(p) - n)
+			c.mu.Unlock()
+			return n, err
+		}
+		c.lost(gen, err)
+
+		// p is in the replay buffer and is resent once the stream is resumed
+		c.mu.Lock()
+		for c.err == nil && (c.gen == gen || c.conn == nil) {
+			c.cond.Wait()
+		}
+		err := c.err
+		c.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Attach resumes the stream on conn. peerRecvSeq is the number of bytes the
+// peer has received; handshake, if set, is called with the number of bytes
+// received from the peer before any replayed data is written.
+func (c *ResumableConn) Attach(conn net.Conn, peerRecvSeq uint64, handshake func(recvSeq uint64) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+
+	if peerRecvSeq > c.sentSeq || c.sentSeq-peerRecvSeq > uint64(len(c.replay)) {
+		c.failLocked(ErrResumeWindow)
+		return ErrResumeWindow
+	}
+
+	if c.conn != nil {
+		c.detachLocked(fmt.Errorf("superseded by a resumed connection"))
+	}
+
+	if handshake != nil {
+		if err := handshake(c.recvSeq); err != nil {
+			return err
+		}
+	}
+
+	missing := c.replay[uint64(len(c.replay))-(c.sentSeq-peerRecvSeq):]
+	if len(missing) > 0 {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		_, err := conn.Write(missing)
+		conn.SetWriteDeadline(time.Time{})
+		if err != nil {
+			return fmt.Errorf("failed to replay %d bytes: %w", len(missing), err)
+		}
+	}
+
+	c.conn = conn
+	c.remoteAddr = conn.RemoteAddr()
+	if c.graceTimer != nil {
+		c.graceTimer.Stop()
+		c.graceTimer = nil
+	}
+	if c.onResume != nil {
+		go c.onResume()
+	}
+	c.cond.Broadcast()
+	return nil
+}
+
+// Close closes the stream. The peer sees a clean EOF and does not try to resume.
+func (c *ResumableConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil
+	}
+	c.err = net.ErrClosed
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.cleanupLocked()
+	return nil
+}
+
+// Detached reports whether the stream is currently waiting to be resumed
+func (c *ResumableConn) Detached() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn == nil && c.err == nil
+}
+
+// LocalAddr returns the local address of the most recent connection
+func (c *ResumableConn) LocalAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.localAddr
+}
+
+// RemoteAddr returns the remote address of the most recent connection
+func (c *ResumableConn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remoteAddr
+}
+
+// SetDeadline applies to the current connection only
+func (c *ResumableConn) SetDeadline(t time.Time) error {
+	if conn := c.attached(); conn != nil {
+		return conn.SetDeadline(t)
+	}
+	return nil
+}
+
+// SetReadDeadline applies to the current connection only
+func (c *ResumableConn) SetReadDeadline(t time.Time) error {
+	if conn := c.attached(); conn != nil {
+		return conn.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// SetWriteDeadline applies to the current connection only
+func (c *ResumableConn) SetWriteDeadline(t time.Time) error {
+	if conn := c.attached(); conn != nil {
+		return conn.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+func (c *ResumableConn) attached() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+// current waits until a connection is attached or the stream is dead
+func (c *ResumableConn) current() (net.Conn, uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.conn == nil && c.err == nil {
+		c.cond.Wait()
+	}
+	if c.err != nil {
+		return nil, 0, c.err
+	}
+	return c.conn, c.gen, nil
+}
+
+// lost handles an I/O error on the connection of generation gen
+func (c *ResumableConn) lost(gen uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen || c.err != nil {
+		return
+	}
+
+	if c.grace <= 0 {
+		c.failLocked(err)
+		return
+	}
+
+	c.detachLocked(err)
+
+	if c.redial != nil {
+		go c.redialLoop(c.gen)
+	}
+}
+
+// detachLocked drops the current connection and starts the grace period
+func (c *ResumableConn) detachLocked(err error) {
+	c.retireLocked()
+
+	if c.onDetach != nil {
+		go c.onDetach(err)
+	}
+
+	if c.graceTimer != nil {
+		return
+	}
+	c.graceTimer = time.AfterFunc(c.grace, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.conn == nil && c.err == nil {
+			c.failLocked(fmt.Errorf("connection lost and not resumed within %s: %w", c.grace, err))
+		}
+	})
+}
+
+func (c *ResumableConn) redialLoop(gen uint64) {
+	backoff := 250 * time.Millisecond
+
+	for {
+		c.mu.Lock()
+		if c.err != nil || c.gen != gen || c.conn != nil {
+			c.mu.Unlock()
+			return
+		}
+		recvSeq := c.recvSeq
+		redial := c.redial
+		c.mu.Unlock()
+
+		conn, peerRecvSeq, err := redial(recvSeq)
+		if errors.Is(err, ErrResumeRejected) {
+			c.fail(err)
+			return
+		}
+		if err == nil {
+			if err = c.Attach(conn, peerRecvSeq, nil); err == nil {
+				return
+			}
+			conn.Close()
+			if errors.Is(err, ErrResumeWindow) {
+				return
+			}
+		}
+
+		time.Sleep(backoff)
+		if backoff < 2*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// retireLocked drops the current connection without a clean close, so the
+// other end sees a reset rather than EOF and keeps the stream resumable
+func (c *ResumableConn) retireLocked() {
+	if c.conn != nil {
+		abortConn(c.conn)
+		c.conn = nil
+	}
+	c.gen++
+}
+
+func (c *ResumableConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.failLocked(err)
+	}
+}
+
+func (c *ResumableConn) failLocked(err error) {
+	c.err = err
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.cleanupLocked()
+}
+
+func (c *ResumableConn) cleanupLocked() {
+	c.gen++
+	c.replay = nil
+	if c.graceTimer != nil {
+		c.graceTimer.Stop()
+		c.graceTimer = nil
+	}
+	c.cond.Broadcast()
+}
+
+// appendReplay keeps at least the last window bytes written. The buffer is
+// compacted only once it doubles so that trimming stays cheap.
+func (c *ResumableConn) appendReplay(p []byte) {
+	c.sentSeq += uint64(len(p))
+	c.replay = append(c.replay, p...)
+	if len(c.replay) > 2*c.window {
+		c.replay = append(c.replay[:0], c.replay[len(c.replay)-c.window:]...)
+	}
+}
+
+// unappendReplay drops the last n bytes recorded by appendReplay
+func (c *ResumableConn) unappendReplay(n int) {
+	if n > len(c.replay) {
+		n = len(c.replay)
+	}
+	c.replay = c.replay[:len(c.replay)-n]
+	c.sentSeq -= uint64(n)
+}
+
+// isTimeout reports whether err is a deadline set by the caller expiring
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// abortConn closes conn with a TCP reset where possible
+func abortConn(conn net.Conn) {
+	raw := conn
+	if nc, ok := raw.(interface{ NetConn() net.Conn }); ok {
+		raw = nc.NetConn()
+	}
+	if tcp, ok := raw.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
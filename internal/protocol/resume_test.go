@@ -0,0 +1,225 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordConn is a connection that keeps what is written to it
+type recordConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordConn) Write(p []byte) (int, error)      { return c.written.Write(p) }
+func (c *recordConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *recordConn) LocalAddr() net.Addr              { return nil }
+func (c *recordConn) RemoteAddr() net.Addr             { return nil }
+func (c *recordConn) Close() error                     { return nil }
+
+func TestAttachReplaysWhatThePeerMissed(t *testing.T) {
+	tests := []struct {
+		name        string
+		peerRecvSeq uint64
+		want        string
+		err         error
+	}{
+		{"nothing missed", 20, "", nil},
+		{"tail missed", 15, "fghij", nil},
+		{"whole window missed", 12, "cdefghij", nil},
+		{"before the window", 11, "", ErrResumeWindow},
+		{"nothing received", 0, "", ErrResumeWindow},
+		{"ahead of what was sent", 21, "", ErrResumeWindow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewResumableConn(&recordConn{}, 8, time.Minute)
+			// Written in chunks, so the replay buffer is compacted to the window
+			for _, chunk := range []string{"01234", "56789", "abcde", "fghij"} {
+				c.appendReplay([]byte(chunk))
+			}
+			c.recvSeq = 7
+
+			next := &recordConn{}
+			var handshakeSeq uint64
+			var replayedEarly bool
+			err := c.Attach(next, tt.peerRecvSeq, func(recvSeq uint64) error {
+				handshakeSeq = recvSeq
+				replayedEarly = next.written.Len() > 0
+				return nil
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Attach: %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				if _, err := c.Write([]byte("x")); err == nil {
+					t.Fatal("stream still writable after a failed resume")
+				}
+				return
+			}
+			if handshakeSeq != 7 || replayedEarly {
+				t.Errorf("handshake saw recvSeq %d, replay before it %v", handshakeSeq, replayedEarly)
+			}
+			if got := next.written.String(); got != tt.want {
+				t.Errorf("replayed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		writes  []string
+		unwrite int // bytes dropped by unappendReplay after the writes
+		sentSeq uint64
+		replay  string
+	}{
+		{"under the window", []string{"ab", "cd"}, 0, 4, "abcd"},
+		{"up to twice the window kept", []string{"abcd", "efgh"}, 0, 8, "abcdefgh"},
+		{"compacted to the window", []string{"abcd", "efgh", "i"}, 0, 9, "fghi"},
+		{"timed out write forgotten", []string{"abc", "def"}, 2, 4, "abcd"},
+		{"unwrite past the buffer", []string{"abcd", "efgh", "i"}, 9, 5, ""},
+	}
+
+	for _, tt := range tests {
+		c := NewResumableConn(&recordConn{}, 4, time.Minute)
+		for _, w := range tt.writes {
+			c.appendReplay([]byte(w))
+		}
+		c.unappendReplay(tt.unwrite)
+		if c.sentSeq != tt.sentSeq || string(c.replay) != tt.replay {
+			t.Errorf("%s: sentSeq %d, replay %q; want %d, %q", tt.name, c.sentSeq, c.replay, tt.sentSeq, tt.replay)
+		}
+	}
+}
+
+// tcpPair returns the two ends of a loopback TCP connection
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+func readN(t *testing.T, r io.Reader, n int) string {
+	t.Helper()
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("reading %d bytes: %v", n, err)
+	}
+	return string(buf)
+}
+
+func TestResumeAfterLostConnection(t *testing.T) {
+	server, client := tcpPair(t)
+	c := NewResumableConn(server, DefaultResumeWindow, time.Minute)
+	defer c.Close()
+
+	client.Write([]byte("abc"))
+	if got := readN(t, c, 3); got != "abc" {
+		t.Fatalf("read %q", got)
+	}
+	if _, err := c.Write([]byte("xyz")); err != nil {
+		t.Fatal(err)
+	}
+	readN(t, client, 1) // the client only takes in "x" before the connection drops
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	abortConn(client)
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.Detached() {
+		if time.Now().After(deadline) {
+			t.Fatal("stream did not notice the lost connection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Writes wait for the stream to be resumed
+	wrote := make(chan error, 1)
+	go func() {
+		_, err := c.Write([]byte("123"))
+		wrote <- err
+	}()
+	select {
+	case err := <-wrote:
+		t.Fatalf("write while detached returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	server2, client2 := tcpPair(t)
+	var recvSeq uint64
+	if err := c.Attach(server2, 1, func(seq uint64) error { recvSeq = seq; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if recvSeq != 3 {
+		t.Errorf("handshake got recvSeq %d, want 3", recvSeq)
+	}
+	if err := <-wrote; err != nil {
+		t.Fatal(err)
+	}
+	if got := readN(t, client2, 5); got != "yz123" {
+		t.Errorf("client read %q after resuming, want %q", got, "yz123")
+	}
+
+	client2.Write([]byte("m"))
+	select {
+	case err := <-readErr:
+		if err != nil {
+			t.Fatalf("read across the reconnect: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read did not resume")
+	}
+	if c.recvSeq != 4 {
+		t.Errorf("recvSeq %d, want 4", c.recvSeq)
+	}
+}
+
+func TestGraceRunsOut(t *testing.T) {
+	server, client := tcpPair(t)
+	c := NewResumableConn(server, DefaultResumeWindow, 50*time.Millisecond)
+
+	abortConn(client)
+	_, err := c.Read(make([]byte, 1))
+	if err == nil || !strings.Contains(err.Error(), "not resumed") {
+		t.Fatalf("read: %v", err)
+	}
+	if _, err := c.Write([]byte("x")); err == nil {
+		t.Fatal("write succeeded on a dead stream")
+	}
+	if err := c.Attach(&recordConn{}, 0, nil); err == nil {
+		t.Fatal("attach succeeded on a dead stream")
+	}
+}
@@ -2,8 +2,10 @@ package auth
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -12,9 +14,32 @@ import (
 
 // TokenClaims represents the claims in a signed token
 type TokenClaims struct {
-	SessionID string `json:"sid"`
-	Role      string `json:"role"`
-	ExpiresAt int64  `json:"exp"`
+	ID        string    `json:"jti,omitempty"` // unique per token, so the relay can refuse reuse
+	SessionID string    `json:"sid"`
+	Role      string    `json:"role"`
+	JoinerID  uint32    `json:"jid,omitempty"`
+	Node      string    `json:"node,omitempty"` // relay node the token is valid on; empty for any
+	Home      *HomeNode `json:"home,omitempty"` // host's relay node, when the joiner is placed elsewhere
+	Via       string    `json:"via,omitempty"`  // relay node that linked the joiner through
+	ExpiresAt int64     `json:"exp"`
+	Limits
+}
+
+// HomeNode is the relay node serving a session's host, as carried in the
+// token of a joiner placed on another node. That node links the joiner
+// through to it.
+type HomeNode struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+	TLS  bool   `json:"tls,omitempty"`
+	Pin  string `json:"pin,omitempty"`
+}
+
+// Limits are the traffic limits the relay enforces on a session. They are
+// optional claims, so signaling can issue different tiers; zero means none.
+type Limits struct {
+	RateLimit int64 `json:"bps,omitempty"`   // bytes per second in each direction
+	ByteQuota int64 `json:"quota,omitempty"` // total bytes for the session
 }
 
 // Signer handles token signing and verification
@@ -27,8 +52,19 @@ func NewSigner(secret string) *Signer {
 	return &Signer{secret: []byte(secret)}
 }
 
-// Sign creates a signed token from claims
+// Sign creates a signed token from claims. Each token gets a unique ID
+// unless claims already has one.
 func (s *Signer) Sign(claims *TokenClaims) (string, error) {
+	if claims.ID == "" {
+		id, err := newTokenID()
+		if err != nil {
+			return "", err
+		}
+		c := *claims
+		c.ID = id
+		claims = &c
+	}
+
 	payload, err := json.Marshal(claims)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal claims: %w", err)
@@ -44,6 +80,14 @@ func (s *Signer) Sign(claims *TokenClaims) (string, error) {
 	return token, nil
 }
 
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
 // Verify verifies a token and returns its claims
 func (s *Signer) Verify(token string) (*TokenClaims, error) {
 	parts := strings.Split(token, ".")
@@ -81,11 +125,74 @@ func (s *Signer) Verify(token string) (*TokenClaims, error) {
 	return &claims, nil
 }
 
-// CreateRelayToken creates a signed token for relay authentication
-func (s *Signer) CreateRelayToken(sessionID, role string, ttl time.Duration) (string, error) {
+// CreateRelayToken creates a signed token for relay authentication on the
+// given relay node, or on any relay if node is empty
+func (s *Signer) CreateRelayToken(sessionID, node, role string, limits Limits, ttl time.Duration) (string, error) {
 	claims := &TokenClaims{
 		SessionID: sessionID,
 		Role:      role,
+		Node:      node,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+		Limits:    limits,
+	}
+	return s.Sign(claims)
+}
+
+// CreateJoinerRelayToken creates a relay token for one joiner of a session
+func (s *Signer) CreateJoinerRelayToken(sessionID, node string, joinerID uint32, limits Limits, ttl time.Duration) (string, error) {
+	claims := &TokenClaims{
+		SessionID: sessionID,
+		Role:      "joiner",
+		JoinerID:  joinerID,
+		Node:      node,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+		Limits:    limits,
+	}
+	return s.Sign(claims)
+}
+
+// CreateLinkedJoinerRelayToken creates a relay token for a joiner placed on
+// node while the session's host is on home
+func (s *Signer) CreateLinkedJoinerRelayToken(sessionID, node string, home *HomeNode, joinerID uint32, limits Limits, ttl time.Duration) (string, error) {
+	claims := &TokenClaims{
+		SessionID: sessionID,
+		Role:      "joiner",
+		JoinerID:  joinerID,
+		Node:      node,
+		Home:      home,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+		Limits:    limits,
+	}
+	return s.Sign(claims)
+}
+
+// CreateLinkToken creates the token a relay node presents to a joiner's home
+// node to link the joiner through. joiner holds the joiner's own claims.
+func (s *Signer) CreateLinkToken(joiner *TokenClaims, via string, ttl time.Duration) (string, error) {
+	if joiner.Home == nil {
+		return "", fmt.Errorf("token has no home node")
+	}
+	claims := &TokenClaims{
+		SessionID: joiner.SessionID,
+		Role:      joiner.Role,
+		JoinerID:  joiner.JoinerID,
+		Node:      joiner.Home.ID,
+		Via:       via,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+		Limits:    joiner.Limits,
+	}
+	return s.Sign(claims)
+}
+
+// NodeRole is the role in tokens that relay nodes present to signaling
+const NodeRole = "node"
+
+// CreateNodeToken creates a token a relay node uses to authenticate its
+// heartbeats to signaling
+func (s *Signer) CreateNodeToken(node string, ttl time.Duration) (string, error) {
+	claims := &TokenClaims{
+		Role:      NodeRole,
+		Node:      node,
 		ExpiresAt: time.Now().Add(ttl).Unix(),
 	}
 	return s.Sign(claims)
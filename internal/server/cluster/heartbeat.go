@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HeartbeatPath is the signaling endpoint relay nodes report to
+const HeartbeatPath = "/cluster/heartbeat"
+
+// RunHeartbeat reports a relay node to the cluster's signaling server every
+// HeartbeatInterval until ctx is done. status is called for each report;
+// token returns the bearer token that proves the node shares the cluster
+// secret. revoked is called with the joiners and sessions signaling says the
+// node must refuse, so kicks and cancellations reach every node within a
+// heartbeat.
+func RunHeartbeat(ctx context.Context, signalingURL string, token func() (string, error), status func() Node, revoked func([]Revocation)) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	url := strings.TrimSuffix(signalingURL, "/") + HeartbeatPath
+
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	failing := false
+	for {
+		resp, err := sendHeartbeat(ctx, client, url, token, status())
+		if err == nil && len(resp.Revoked) > 0 {
+			revoked(resp.Revoked)
+		}
+		if err != nil && !failing {
+			log.Printf("Cluster heartbeat failed: %v", err)
+		} else if err == nil && failing {
+			log.Printf("Cluster heartbeat restored")
+		}
+		failing = err != nil
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sendHeartbeat(ctx context.Context, client *http.Client, url string, token func() (string, error), node Node) (*HeartbeatResponse, error) {
+	bearer, err := token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign heartbeat: %w", err)
+	}
+	body, err := json.Marshal(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode heartbeat: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+bearer)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result HeartbeatResponse
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		// Signaling servers that predate kicks answer with no body
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return nil, fmt.Errorf("failed to decode heartbeat response: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("signaling answered %s", resp.Status)
+	}
+	return &result, nil
+}
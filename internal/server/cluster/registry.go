@@ -0,0 +1,193 @@
+// Package cluster tracks the relay nodes behind a signaling server, so it can
+// hand each new session to a live, lightly loaded relay.
+package cluster
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HeartbeatInterval is how often a relay node reports to signaling
+const HeartbeatInterval = 10 * time.Second
+
+// NodeTimeout is how long a node is assigned sessions after its last heartbeat
+const NodeTimeout = 3 * HeartbeatInterval
+
+// ErrNoNodes is returned by Pick when relay nodes are registered but none can take a session
+var ErrNoNodes = errors.New("no relay node available")
+
+// Node is a relay node as it reports itself in heartbeats
+type Node struct {
+	ID          string    `json:"id"`
+	Region      string    `json:"region,omitempty"`
+	Addr        string    `json:"addr"`                  // address clients dial: host:port or a ws(s):// URL
+	TLS         bool      `json:"tls,omitempty"`         // plain TCP address serves TLS
+	Fingerprint string    `json:"fingerprint,omitempty"` // SHA-256 of the node's certificate, for pinning
+	Sessions    int       `json:"sessions"`              // current load: paired and waiting sessions
+	Draining    bool      `json:"draining,omitempty"`
+	LastSeen    time.Time `json:"lastSeen"`
+}
+
+// alive reports whether the node has heartbeated recently
+func (n *Node) alive(now time.Time) bool {
+	return now.Sub(n.LastSeen) < NodeTimeout
+}
+
+// Revocation is a joiner kicked by its host, or with a zero JoinerID a
+// session its host cancelled. Every relay node refuses the tokens until they
+// have all expired.
+type Revocation struct {
+	SessionID string    `json:"sessionId"`
+	JoinerID  uint32    `json:"joinerId"`
+	Until     time.Time `json:"until"`
+}
+
+// HeartbeatResponse is what signaling answers a heartbeat with
+type HeartbeatResponse struct {
+	Revoked []Revocation `json:"revoked,omitempty"`
+}
+
+// Registry holds the relay nodes known to a signaling server
+type Registry struct {
+	mu       sync.Mutex
+	nodes    map[string]*Node
+	assigned map[string]int // sessions handed to each node since its last heartbeat
+	revoked  []Revocation
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		nodes:    make(map[string]*Node),
+		assigned: make(map[string]int),
+	}
+}
+
+// Heartbeat records a node's latest report
+func (r *Registry) Heartbeat(n Node) {
+	n.LastSeen = time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nodes[n.ID] = &n
+	r.assigned[n.ID] = 0
+}
+
+// Revoke has every node refuse a kicked joiner's or cancelled session's
+// tokens until the given time
+func (r *Registry) Revoke(rev Revocation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revoked = append(r.revokedLocked(), rev)
+}
+
+// Revoked lists the joiners and sessions nodes must refuse, which they are sent with the
+// answer to every heartbeat
+func (r *Registry) Revoked() []Revocation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revoked = r.revokedLocked()
+	return append([]Revocation(nil), r.revoked...)
+}
+
+// revokedLocked returns the revocations that have not yet run out
+func (r *Registry) revokedLocked() []Revocation {
+	now := time.Now()
+	live := r.revoked[:0]
+	for _, rev := range r.revoked {
+		if now.Before(rev.Until) {
+			live = append(live, rev)
+		}
+	}
+	return live
+}
+
+// Empty reports whether no relay node has ever registered. Signaling then
+// leaves relay selection to the clients.
+func (r *Registry) Empty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.nodes) == 0
+}
+
+// Pick assigns a session to the least loaded live node, preferring region
+// when any node there can take it
+func (r *Registry) Pick(region string) (Node, error) {
+	return r.pick(region, false)
+}
+
+// PickInRegion is Pick restricted to the nodes of region
+func (r *Registry) PickInRegion(region string) (Node, error) {
+	return r.pick(region, true)
+}
+
+func (r *Registry) pick(region string, strict bool) (Node, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var best *Node
+	bestLoad := 0
+	better := func(n *Node, load int) bool {
+		if best == nil {
+			return true
+		}
+		if inRegion := n.Region == region; region != "" && inRegion != (best.Region == region) {
+			return inRegion
+		}
+		if load != bestLoad {
+			return load < bestLoad
+		}
+		return n.ID < best.ID
+	}
+
+	for _, n := range r.nodes {
+		if !n.alive(now) || n.Draining || (strict && n.Region != region) {
+			continue
+		}
+		if load := n.Sessions + r.assigned[n.ID]; better(n, load) {
+			best, bestLoad = n, load
+		}
+	}
+
+	if best == nil {
+		return Node{}, ErrNoNodes
+	}
+	// Count it until the node's next heartbeat reports the session itself
+	r.assigned[best.ID]++
+	return *best, nil
+}
+
+// Get returns a node by ID, whether or not it is still alive
+func (r *Registry) Get(id string) (Node, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return Node{}, false
+	}
+	return *n, true
+}
+
+// Nodes lists the registered nodes by ID, dropping any that have been silent
+// for long enough that they are presumed gone
+func (r *Registry) Nodes() []Node {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	nodes := make([]Node, 0, len(r.nodes))
+	for id, n := range r.nodes {
+		if now.Sub(n.LastSeen) > 10*NodeTimeout {
+			delete(r.nodes, id)
+			delete(r.assigned, id)
+			continue
+		}
+		nodes = append(nodes, *n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
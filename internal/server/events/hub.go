@@ -0,0 +1,81 @@
+// Package events fans session lifecycle events out to the clients watching
+// each session
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// queueSize bounds how many events may wait for a slow subscriber before it
+// is dropped
+const queueSize = 64
+
+// Hub delivers each published event to the subscribers of its session
+type Hub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan protocol.SessionEvent]struct{}
+}
+
+// NewHub creates a hub with no subscribers
+func NewHub() *Hub {
+	return &Hub{subs: make(map[string]map[chan protocol.SessionEvent]struct{})}
+}
+
+// Subscribe returns a channel of the events of a session and a func that
+// unsubscribes. The channel is closed if the subscriber falls too far
+// behind, or after an EventEnded.
+func (h *Hub) Subscribe(sessionID string) (<-chan protocol.SessionEvent, func()) {
+	ch := make(chan protocol.SessionEvent, queueSize)
+
+	h.mu.Lock()
+	subs, ok := h.subs[sessionID]
+	if !ok {
+		subs = make(map[chan protocol.SessionEvent]struct{})
+		h.subs[sessionID] = subs
+	}
+	subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[sessionID][ch]; ok {
+			h.removeLocked(sessionID, ch)
+		}
+	}
+}
+
+// Publish sends e to the subscribers of its session, stamping it with the
+// current time if it has none. It never blocks.
+func (h *Hub) Publish(e protocol.SessionEvent) {
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[e.SessionID] {
+		select {
+		case ch <- e:
+		default:
+			h.removeLocked(e.SessionID, ch)
+			continue
+		}
+		if e.Type == protocol.EventEnded {
+			h.removeLocked(e.SessionID, ch)
+		}
+	}
+}
+
+// removeLocked unsubscribes ch and closes it
+func (h *Hub) removeLocked(sessionID string, ch chan protocol.SessionEvent) {
+	subs := h.subs[sessionID]
+	delete(subs, ch)
+	if len(subs) == 0 {
+		delete(h.subs, sessionID)
+	}
+	close(ch)
+}
@@ -0,0 +1,63 @@
+package proxyproto
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the address of the client behind an HTTP request.
+// X-Forwarded-For and X-Real-IP are only honoured when the request came from
+// a trusted proxy; anyone else could put whatever they like in them.
+// X-Forwarded-For is read from the right, skipping trusted proxies, so the
+// first hop that isn't one of them is the client.
+func ClientIP(r *http.Request, trusted []*net.IPNet) string {
+	ip := hostIP(r.RemoteAddr)
+	if !contains(trusted, ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xri) != nil {
+			return xri
+		}
+		return ip
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// A proxy we trust would not have written this
+			return ip
+		}
+		ip = hop
+		if !contains(trusted, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// hostIP strips the port from addr
+func hostIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func contains(nets []*net.IPNet, s string) bool {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package proxyproto
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseCIDRs("10.0.0.0/8, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		xri    string
+		want   string
+	}{
+		{"direct", "203.0.113.7:40000", nil, "", "203.0.113.7"},
+		{"spoofed forwarded header", "203.0.113.7:40000", []string{"198.51.100.1"}, "", "203.0.113.7"},
+		{"spoofed real IP", "203.0.113.7:40000", nil, "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:5000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"trusted IPv6 proxy", "[::1]:5000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"client prepends a hop", "10.0.0.1:5000", []string{"192.0.2.9, 198.51.100.1"}, "", "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.1:5000", []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"}, "", "198.51.100.1"},
+		{"only trusted hops", "10.0.0.1:5000", []string{"10.0.0.2"}, "", "10.0.0.2"},
+		{"garbage hop", "10.0.0.1:5000", []string{"not-an-ip"}, "", "10.0.0.1"},
+		{"real IP from trusted proxy", "10.0.0.1:5000", nil, "198.51.100.1", "198.51.100.1"},
+		{"bad real IP", "10.0.0.1:5000", nil, "nope", "10.0.0.1"},
+		{"no headers", "10.0.0.1:5000", nil, "", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		r := &http.Request{RemoteAddr: tt.remote, Header: http.Header{}}
+		for _, v := range tt.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if tt.xri != "" {
+			r.Header.Set("X-Real-IP", tt.xri)
+		}
+		if got := ClientIP(r, trusted); got != tt.want {
+			t.Errorf("%s: ClientIP = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
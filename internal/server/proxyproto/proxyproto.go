@@ -0,0 +1,264 @@
+// Package proxyproto reads HAProxy PROXY protocol headers (version 1 and 2)
+// so servers behind a TCP load balancer see the real client address.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderTimeout bounds how long a trusted connection may take to send its header
+const HeaderTimeout = 5 * time.Second
+
+// v1 headers are at most 107 bytes, CRLF included
+const maxV1Header = 107
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// ParseCIDRs parses a comma-separated list of CIDRs. A bare IP address is
+// taken as a single host.
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", s, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Listener wraps a listener so connections from trusted sources must start
+// with a PROXY protocol header. Connections from anywhere else are passed
+// through untouched, so a client can't spoof its address by sending one.
+type Listener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+// NewListener creates a listener that accepts PROXY headers from the trusted networks
+func NewListener(inner net.Listener, trusted []*net.IPNet) *Listener {
+	return &Listener{Listener: inner, trusted: trusted}
+}
+
+// Accept waits for the next connection. The header is read on the
+// connection's first Read or RemoteAddr call, so a slow client can't stall
+// the accept loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, br: bufio.NewReaderSize(conn, 256)}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a trusted proxy. RemoteAddr reports the client
+// address from the PROXY header.
+type Conn struct {
+	net.Conn
+	br *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+// Read reads data that follows the header
+func (c *Conn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.br.Read(p)
+}
+
+// RemoteAddr returns the client address the proxy reported, or the proxy's
+// own address for health checks and unknown address families
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *Conn) readHeader() {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+
+	headerDeadline := time.Now().Add(HeaderTimeout)
+	if !deadline.IsZero() && deadline.Before(headerDeadline) {
+		headerDeadline = deadline
+	}
+	c.Conn.SetReadDeadline(headerDeadline)
+	c.remote, c.err = readHeader(c.br)
+
+	// Put back whatever deadline the caller had asked for
+	c.mu.Lock()
+	c.Conn.SetReadDeadline(c.deadline)
+	c.mu.Unlock()
+
+	if c.err != nil {
+		c.err = fmt.Errorf("proxy protocol from %s: %w", c.Conn.RemoteAddr(), c.err)
+	}
+}
+
+// readHeader consumes a version 1 or 2 header. A nil address means the
+// header carried none.
+func readHeader(br *bufio.Reader) (net.Addr, error) {
+	prefix, err := br.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if bytes.Equal(prefix, v1Prefix) {
+		return readV1(br)
+	}
+
+	sig, err := br.Peek(len(v2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if !bytes.Equal(sig, v2Signature) {
+		return nil, fmt.Errorf("missing header")
+	}
+	return readV2(br)
+}
+
+// readV1 parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n"
+func readV1(br *bufio.Reader) (net.Addr, error) {
+	line, err := br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > maxV1Header {
+		return nil, fmt.Errorf("v1 header too long")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading v1 header: %w", err)
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("v1 header not terminated by CRLF")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed v1 header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid v1 source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid v1 source port %q", fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 parses the binary header
+func readV2(br *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, fmt.Errorf("reading v2 header: %w", err)
+	}
+
+	verCmd, family := hdr[12], hdr[13]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 version %d", verCmd>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, fmt.Errorf("reading v2 addresses: %w", err)
+	}
+
+	switch verCmd & 0x0F {
+	case 0x0:
+		// LOCAL: the proxy's own health check
+		return nil, nil
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("unsupported v2 command %d", verCmd&0x0F)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, fmt.Errorf("short v2 IPv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, fmt.Errorf("short v2 IPv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	default:
+		// UDP, unix sockets and UNSPEC keep the proxy's address
+		return nil, nil
+	}
+}
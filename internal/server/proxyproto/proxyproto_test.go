@@ -0,0 +1,179 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// v2Header builds a version 2 header with the given command, family and
+// address block
+func v2Header(cmd, family byte, addrs []byte) string {
+	var b bytes.Buffer
+	b.Write(v2Signature)
+	b.WriteByte(0x20 | cmd)
+	b.WriteByte(family)
+	binary.Write(&b, binary.BigEndian, uint16(len(addrs)))
+	b.Write(addrs)
+	return b.String()
+}
+
+// v2Addrs builds an address block for src and dst
+func v2Addrs(src, dst net.IP, sport, dport uint16) []byte {
+	var b bytes.Buffer
+	b.Write(src)
+	b.Write(dst)
+	binary.Write(&b, binary.BigEndian, sport)
+	binary.Write(&b, binary.BigEndian, dport)
+	return b.Bytes()
+}
+
+func TestReadHeader(t *testing.T) {
+	ipv4 := v2Addrs(net.IPv4(203, 0, 113, 7).To4(), net.IPv4(10, 0, 0, 1).To4(), 40000, 1627)
+	ipv6 := v2Addrs(net.ParseIP("2001:db8::7"), net.ParseIP("2001:db8::1"), 40000, 1627)
+
+	tests := []struct {
+		name   string
+		header string
+		want   string // client address; empty if the header carries none
+		err    string // part of the error, if one is expected
+	}{
+		{"v1 TCP4", "PROXY TCP4 203.0.113.7 10.0.0.1 40000 1627\r\n", "203.0.113.7:40000", ""},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::7 2001:db8::1 40000 1627\r\n", "[2001:db8::7]:40000", ""},
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\n", "", ""},
+		{"v1 UNKNOWN with addresses", "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n", "", ""},
+		{"v1 family mismatch", "PROXY TCP4 2001:db8::7 10.0.0.1 40000 1627\r\n", "", "source address"},
+		{"v1 bad port", "PROXY TCP4 203.0.113.7 10.0.0.1 70000 1627\r\n", "", "source port"},
+		{"v1 missing field", "PROXY TCP4 203.0.113.7 10.0.0.1 40000\r\n", "", "malformed"},
+		{"v1 bare LF", "PROXY TCP4 203.0.113.7 10.0.0.1 40000 1627\n", "", "CRLF"},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", "", "too long"},
+		{"v2 TCP over IPv4", v2Header(0x1, 0x11, ipv4), "203.0.113.7:40000", ""},
+		{"v2 TCP over IPv6", v2Header(0x1, 0x21, ipv6), "[2001:db8::7]:40000", ""},
+		{"v2 with TLVs", v2Header(0x1, 0x11, append(ipv4, 0x04, 0x00, 0x01, 0xff)), "203.0.113.7:40000", ""},
+		{"v2 LOCAL", v2Header(0x0, 0x00, nil), "", ""},
+		{"v2 UDP", v2Header(0x1, 0x12, ipv4), "", ""},
+		{"v2 short IPv4 block", v2Header(0x1, 0x11, ipv4[:8]), "", "short"},
+		{"v2 short IPv6 block", v2Header(0x1, 0x21, ipv6[:20]), "", "short"},
+		{"v2 unknown command", v2Header(0x2, 0x11, ipv4), "", "command"},
+		{"v2 wrong version", strings.Replace(v2Header(0x1, 0x11, ipv4), "\x21", "\x11", 1), "", "version"},
+		{"v2 truncated", v2Header(0x1, 0x11, ipv4)[:20], "", "reading v2"},
+		{"no header", "GET / HTTP/1.1\r\n\r\n", "", "missing header"},
+	}
+
+	for _, tt := range tests {
+		br := bufio.NewReaderSize(strings.NewReader(tt.header+"payload"), 256)
+		addr, err := readHeader(br)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want one about %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tt.want {
+			t.Errorf("%s: address %q, want %q", tt.name, got, tt.want)
+		}
+		if rest, _ := io.ReadAll(br); string(rest) != "payload" {
+			t.Errorf("%s: %q left after the header, want %q", tt.name, rest, "payload")
+		}
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		list     string
+		contains []string
+		excludes []string
+		err      bool
+	}{
+		{list: ""},
+		{list: "10.0.0.0/8", contains: []string{"10.1.2.3"}, excludes: []string{"11.0.0.1"}},
+		{list: "127.0.0.1, ::1", contains: []string{"127.0.0.1", "::1"}, excludes: []string{"127.0.0.2", "::2"}},
+		{list: "192.168.0.0/16,,2001:db8::/32", contains: []string{"192.168.9.9", "2001:db8::5"}, excludes: []string{"192.169.0.1"}},
+		{list: "10.0.0.0/33", err: true},
+		{list: "example.com", err: true},
+	}
+
+	for _, tt := range tests {
+		nets, err := ParseCIDRs(tt.list)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseCIDRs(%q): %v", tt.list, err)
+			continue
+		}
+		l := &Listener{trusted: nets}
+		for _, ip := range tt.contains {
+			if !l.isTrusted(&net.TCPAddr{IP: net.ParseIP(ip)}) {
+				t.Errorf("ParseCIDRs(%q) does not trust %s", tt.list, ip)
+			}
+		}
+		for _, ip := range tt.excludes {
+			if l.isTrusted(&net.TCPAddr{IP: net.ParseIP(ip)}) {
+				t.Errorf("ParseCIDRs(%q) trusts %s", tt.list, ip)
+			}
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted string
+		want    string // RemoteAddr seen by the server; empty for the real one
+		data    string // what the server reads
+	}{
+		{"trusted proxy", "127.0.0.1", "203.0.113.7:40000", "hello"},
+		{"untrusted source", "10.0.0.0/8", "", "PROXY TCP4 203.0.113.7 10.0.0.1 40000 1627\r\nhello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trusted, err := ParseCIDRs(tt.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln := NewListener(inner, trusted)
+			defer ln.Close()
+
+			client, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			client.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 40000 1627\r\nhello"))
+
+			conn, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			want := tt.want
+			if want == "" {
+				want = client.LocalAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != want {
+				t.Errorf("RemoteAddr %s, want %s", got, want)
+			}
+			buf := make([]byte, len(tt.data))
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != tt.data {
+				t.Errorf("read %q, %v; want %q", buf, err, tt.data)
+			}
+		})
+	}
+}
@@ -73,13 +73,15 @@ func (l *Limiter) doCleanup() {
 type MultiLimiter struct {
 	create *Limiter
 	join   *Limiter
+	list   *Limiter
 }
 
-// NewMultiLimiter creates limiters for create and join operations
+// NewMultiLimiter creates limiters for create, join and list operations
 func NewMultiLimiter() *MultiLimiter {
 	return &MultiLimiter{
 		create: NewLimiter(10.0/60.0, 3),
 		join:   NewLimiter(30.0/60.0, 10),
+		list:   NewLimiter(60.0/60.0, 20),
 	}
 }
 
@@ -92,3 +94,8 @@ func (m *MultiLimiter) AllowCreate(ip string) bool {
 func (m *MultiLimiter) AllowJoin(ip string) bool {
 	return m.join.Allow(ip)
 }
+
+// AllowList checks if a lobby listing request is allowed
+func (m *MultiLimiter) AllowList(ip string) bool {
+	return m.list.Allow(ip)
+}
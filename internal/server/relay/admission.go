@@ -0,0 +1,118 @@
+package relay
+
+import (
+	"net"
+	"sync"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// AdmissionLimits caps how much work the relay takes on. Zero leaves a limit
+// off.
+type AdmissionLimits struct {
+	MaxSessions   int // sessions relaying traffic at once
+	MaxPending    int // clients waiting for their peer
+	MaxConnsPerIP int // open connections from one source address
+}
+
+// SetAdmissionLimits sets the limits new clients are admitted under. Clients
+// already connected are not affected.
+func (r *Relay) SetAdmissionLimits(limits AdmissionLimits) {
+	r.mu.Lock()
+	r.limits = limits
+	r.mu.Unlock()
+}
+
+// trackConn counts an open connection against its source address until the
+// returned func is called. It runs before the client has sent anything, and
+// reports false when the address already has as many connections open as
+// the per-address limit allows.
+func (r *Relay) trackConn(addr net.Addr) (string, func(), bool) {
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if max := r.limits.MaxConnsPerIP; max > 0 && r.conns[ip] >= max {
+		return ip, func() {}, false
+	}
+	r.conns[ip]++
+
+	var once sync.Once
+	return ip, func() {
+		once.Do(func() {
+			r.mu.Lock()
+			if r.conns[ip]--; r.conns[ip] <= 0 {
+				delete(r.conns, ip)
+			}
+			r.mu.Unlock()
+		})
+	}, true
+}
+
+// admit decides whether an authenticated client may join. It returns a code
+// and message when the client must be turned away. The per-address limit is
+// applied earlier by trackConn. Clients of a session already relaying
+// traffic skip the session and pending limits, so a full relay never splits
+// up a match, and clients whose peer is already waiting skip the pending
+// limit.
+func (r *Relay) admit(info *TokenInfo, channel string) (protocol.ErrorCode, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	limits := r.limits
+	if s, ok := r.sessions[info.SessionID]; ok && s.isPaired() {
+		return "", ""
+	}
+
+	if limits.MaxSessions > 0 && r.pairedSessionsLocked() >= limits.MaxSessions {
+		return protocol.CodeServerFull, "Relay is full"
+	}
+
+	// Spectators never wait for a peer
+	if limits.MaxPending > 0 && info.Role != "spectator" && !r.wouldPairLocked(info, channel) &&
+		r.pendingLocked() >= limits.MaxPending {
+		return protocol.CodeServerFull, "Relay is full"
+	}
+
+	return "", ""
+}
+
+// wouldPairLocked reports whether a client is paired as soon as it arrives
+// because its peer is already waiting
+func (r *Relay) wouldPairLocked(info *TokenInfo, channel string) bool {
+	rm, ok := r.rooms[pendingKey(info.SessionID, channel)]
+	if !ok {
+		return false
+	}
+	if info.Role == "host" {
+		return rm.waitingJoiner() != nil
+	}
+	return rm.host != nil
+}
+
+// pendingLocked counts the clients waiting for their peer
+func (r *Relay) pendingLocked() int {
+	n := 0
+	for _, rm := range r.rooms {
+		for _, p := range rm.members() {
+			if !p.paired {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// pairedSessionsLocked counts the sessions relaying traffic
+func (r *Relay) pairedSessionsLocked() int {
+	n := 0
+	for _, s := range r.sessions {
+		if s.isPaired() {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,68 @@
+package relay
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+func TestConnLimitBeforeAuth(t *testing.T) {
+	r := NewRelay(testValidator{}, time.Minute, 0)
+	r.SetAdmissionLimits(AdmissionLimits{MaxConnsPerIP: 2})
+	addr := startRelay(t, r)
+
+	// Two clients that never send their auth message use up the limit
+	var silent []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		silent = append(silent, conn)
+	}
+	waitConns(t, r, 2)
+
+	// The next one is turned away without being asked to authenticate
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var resp AuthResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		t.Fatalf("reading refusal: %v", err)
+	}
+	if resp.Success || resp.Code != protocol.CodeTooManyConnections {
+		t.Fatalf("over the limit: success %v, code %q", resp.Success, resp.Code)
+	}
+
+	silent[0].Close()
+	waitConns(t, r, 1)
+	if _, resp := dialRelay(t, addr, "host", 0); !resp.Success {
+		t.Fatalf("under the limit: %s (%s)", resp.Error, resp.Code)
+	}
+}
+
+// waitConns waits for the relay to count n connections from loopback
+func waitConns(t *testing.T, r *Relay, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.Lock()
+		got := r.conns["127.0.0.1"]
+		r.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("relay counts %d connections, want %d", got, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,115 @@
+package relay
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/capture"
+)
+
+// Errors returned by StartCapture
+var (
+	ErrSessionNotFound  = errors.New("session is not connected to this relay")
+	ErrAlreadyCapturing = errors.New("session is already being captured")
+)
+
+// sessionCapture records a session's game traffic. The host is the server
+// of every captured stream and each joiner a client.
+type sessionCapture struct {
+	w *capture.Writer
+
+	mu      sync.Mutex
+	streams map[uint32]*capture.Stream // TCP channel, by joiner stream ID
+}
+
+// record captures game data between joiner j and the host
+func (c *sessionCapture) record(j *PendingConnection, toHost bool, p []byte) {
+	peer := capture.PeerAddr(j.Stream)
+	if j.Channel == ChannelUDP {
+		host := capture.GameAddr(capture.DefaultUDPPort)
+		if toHost {
+			c.w.Datagram(peer, host, p)
+		} else {
+			c.w.Datagram(host, peer, p)
+		}
+		return
+	}
+
+	c.mu.Lock()
+	s := c.streams[j.Stream]
+	if s == nil {
+		s = c.w.Stream(peer, capture.GameAddr(capture.DefaultTCPPort))
+		c.streams[j.Stream] = s
+	}
+	c.mu.Unlock()
+	s.Write(toHost, p)
+}
+
+// end records the end of joiner j's game stream
+func (c *sessionCapture) end(j *PendingConnection) {
+	if j.Role != "joiner" || j.Channel != ChannelTCP {
+		return
+	}
+	c.mu.Lock()
+	s := c.streams[j.Stream]
+	delete(c.streams, j.Stream)
+	c.mu.Unlock()
+
+	if s != nil {
+		s.Close()
+	}
+}
+
+// close ends every open stream and closes the capture file
+func (c *sessionCapture) close() error {
+	c.mu.Lock()
+	for id, s := range c.streams {
+		s.Close()
+		delete(c.streams, id)
+	}
+	c.mu.Unlock()
+	return c.w.Close()
+}
+
+// StartCapture records a paired session's game traffic to w until
+// StopCapture is called or the session ends. The relay closes w.
+func (r *Relay) StartCapture(sessionID string, w *capture.Writer) error {
+	r.mu.Lock()
+	s, ok := r.sessions[sessionID]
+	r.mu.Unlock()
+
+	if !ok || !s.isPaired() {
+		return ErrSessionNotFound
+	}
+	c := &sessionCapture{w: w, streams: make(map[uint32]*capture.Stream)}
+	if !s.capture.CompareAndSwap(nil, c) {
+		return ErrAlreadyCapturing
+	}
+	log.Printf("Capturing session %s", sessionID)
+	return nil
+}
+
+// StopCapture stops capturing a session's traffic. It reports whether the
+// session was being captured.
+func (r *Relay) StopCapture(sessionID string) bool {
+	r.mu.Lock()
+	s, ok := r.sessions[sessionID]
+	r.mu.Unlock()
+
+	return ok && s.stopCapture()
+}
+
+// stopCapture closes the session's capture, if it has one
+func (s *liveSession) stopCapture() bool {
+	c := s.capture.Swap(nil)
+	if c == nil {
+		return false
+	}
+	if err := c.close(); err != nil {
+		log.Printf("Capture of session %s failed: %v", s.sessionID, err)
+	} else {
+		log.Printf("Stopped capturing session %s", s.sessionID)
+	}
+	return true
+}
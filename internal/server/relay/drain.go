@@ -0,0 +1,108 @@
+package relay
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// Drain stops the relay from taking new pairings ahead of a restart. Clients
+// still waiting for a peer are disconnected, and clients in a match are told
+// the relay will close within timeout. Resumes are still accepted so matches
+// in progress survive network blips while draining.
+func (r *Relay) Drain(timeout time.Duration) {
+	var waiting, playing []*PendingConnection
+
+	r.mu.Lock()
+	if r.draining {
+		r.mu.Unlock()
+		return
+	}
+	r.draining = true
+
+	for key, rm := range r.rooms {
+		for _, p := range rm.members() {
+			if !p.paired {
+				r.leaveRoomLocked(key, p)
+				waiting = append(waiting, p)
+			}
+		}
+	}
+	for _, s := range r.sessions {
+		s.mu.Lock()
+		for p := range s.members {
+			if p.paired {
+				playing = append(playing, p)
+			}
+		}
+		s.mu.Unlock()
+	}
+	r.mu.Unlock()
+
+	log.Printf("Draining: %d waiting clients disconnected, closing remaining sessions within %s", len(waiting), timeout)
+
+	for _, p := range waiting {
+		p.link.drop("relay shutting down")
+	}
+	notice := protocol.NewCountdownFrame(protocol.FrameShutdown, timeout)
+	for _, p := range playing {
+		go p.link.send(notice)
+	}
+}
+
+// Draining reports whether Drain has been called
+func (r *Relay) Draining() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.draining
+}
+
+// ActiveSessions counts the sessions with at least one joiner paired to its host
+func (r *Relay) ActiveSessions() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	active := 0
+	for _, s := range r.sessions {
+		s.mu.Lock()
+		for p := range s.members {
+			if p.Role != "host" && p.paired {
+				active++
+				break
+			}
+		}
+		s.mu.Unlock()
+	}
+	return active
+}
+
+// WaitIdle blocks until no session is active or ctx is done
+func (r *Relay) WaitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for r.ActiveSessions() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// CloseAll disconnects every client of every session
+func (r *Relay) CloseAll(reason string) {
+	r.mu.Lock()
+	sessions := make([]*liveSession, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		sessions = append(sessions, s)
+	}
+	r.mu.Unlock()
+
+	for _, s := range sessions {
+		s.close(reason)
+	}
+}
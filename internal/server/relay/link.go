@@ -0,0 +1,106 @@
+package relay
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// dropNoticeTimeout bounds how long the relay tries to tell a client why it
+// is being disconnected
+const dropNoticeTimeout = 2 * time.Second
+
+// link is the relay's side of one client connection. Version 1 clients on
+// the TCP channel, other than multiplexing hosts, get a raw byte pipe; every
+// other connection is framed. Control frames only go to framed links, and
+// framed clients ignore types they don't know, so v1 framed clients are safe.
+type link struct {
+	conn    net.Conn
+	framed  bool
+	writeMu sync.Mutex
+	buf     []byte // read buffer for raw links
+	dropped sync.Once
+}
+
+func newLink(conn net.Conn, framed bool) *link {
+	return &link{conn: conn, framed: framed}
+}
+
+// readFrame returns the next frame from a framed link, answering pings
+func (l *link) readFrame() (*protocol.Frame, error) {
+	for {
+		f, err := protocol.ReadFrame(l.conn)
+		if err != nil {
+			return nil, err
+		}
+		if f.Type == protocol.FramePing {
+			l.send(&protocol.Frame{Type: protocol.FramePong, Stream: f.Stream, Payload: f.Payload})
+			continue
+		}
+		return f, nil
+	}
+}
+
+// readData returns the next chunk of game data from the client. The chunk
+// is only valid until the next call.
+func (l *link) readData() ([]byte, error) {
+	if !l.framed {
+		if l.buf == nil {
+			l.buf = make([]byte, 32*1024)
+		}
+		n, err := l.conn.Read(l.buf)
+		if n > 0 {
+			return l.buf[:n], nil
+		}
+		return nil, err
+	}
+
+	for {
+		f, err := l.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if f.Type == protocol.FrameData {
+			return f.Payload, nil
+		}
+	}
+}
+
+// writeData sends game data to the client
+func (l *link) writeData(p []byte) error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	if !l.framed {
+		_, err := l.conn.Write(p)
+		return err
+	}
+	return protocol.WriteFrame(l.conn, &protocol.Frame{Type: protocol.FrameData, Payload: p})
+}
+
+// send writes a frame to a framed link; raw links have no room for it
+func (l *link) send(f *protocol.Frame) error {
+	if !l.framed {
+		return nil
+	}
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	return protocol.WriteFrame(l.conn, f)
+}
+
+// drop tells a framed client why it is being disconnected, then closes it.
+// The first reason given wins. It does not block, so a stalled client cannot
+// hold up the caller.
+func (l *link) drop(reason string) {
+	l.dropped.Do(func() {
+		go func() {
+			if l.framed {
+				l.conn.SetWriteDeadline(time.Now().Add(dropNoticeTimeout))
+				l.send(&protocol.Frame{Type: protocol.FramePeerLeft, Payload: []byte(reason)})
+			}
+			l.conn.Close()
+		}()
+	})
+}
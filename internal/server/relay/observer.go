@@ -0,0 +1,40 @@
+package relay
+
+import "time"
+
+// Observer hears about the lifecycle of the relay's sessions. Its methods
+// are called without the relay's locks held and must not block.
+type Observer interface {
+	// ClientConnected is called once a host or joiner has authenticated on
+	// a channel. joinerID is zero for hosts.
+	ClientConnected(sessionID, role, channel string, joinerID uint32)
+
+	// Paired is called when the relay starts forwarding for a joiner
+	Paired(sessionID, channel string, joinerID uint32)
+
+	// Expiring is called shortly before a session reaches the relay's max
+	// duration
+	Expiring(sessionID string, remaining time.Duration)
+
+	// Ended is called once the last client of a session has left
+	Ended(sessionID string)
+}
+
+// SetObserver lets o hear about the relay's sessions
+func (r *Relay) SetObserver(o Observer) {
+	r.mu.Lock()
+	r.observer = o
+	r.mu.Unlock()
+}
+
+// notify calls f with the observer, if there is one. The relay lock must not
+// be held.
+func (r *Relay) notify(f func(o Observer)) {
+	r.mu.Lock()
+	o := r.observer
+	r.mu.Unlock()
+
+	if o != nil {
+		f(o)
+	}
+}
@@ -1,12 +1,24 @@
 package relay
 
 import (
+	"crypto/rand"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"sync"
 	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// Channel names carried in AuthMessage. Each channel of a session is paired
+// independently, so the game's TCP stream and UDP datagrams travel on
+// separate relay connections authenticated with the same token.
+const (
+	ChannelTCP = "tcp"
+	ChannelUDP = "udp"
 )
 
 // AuthMessage is sent by clients to authenticate with the relay
@@ -14,12 +26,31 @@ type AuthMessage struct {
 	SessionID  string `json:"sessionId"`
 	RelayToken string `json:"relayToken"`
 	Role       string `json:"role"`
+	Channel    string `json:"channel,omitempty"`
+
+	// Mux is set by hosts that accept many joiners. The relay then frames
+	// each joiner's traffic with its stream ID on the host's connection.
+	Mux bool `json:"mux,omitempty"`
+
+	// Resumable asks the relay to keep the stream alive across reconnects.
+	// ResumeToken and RecvSeq are set instead when reattaching to one.
+	Resumable   bool   `json:"resumable,omitempty"`
+	ResumeToken string `json:"resumeToken,omitempty"`
+	RecvSeq     uint64 `json:"recvSeq,omitempty"`
+
+	// Version is the newest protocol.Version the client speaks; zero means 1
+	Version int `json:"version,omitempty"`
 }
 
 // AuthResponse is sent back to clients after authentication
 type AuthResponse struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error,omitempty"`
+	Success     bool               `json:"success"`
+	Error       string             `json:"error,omitempty"`
+	Code        protocol.ErrorCode `json:"code,omitempty"`
+	Mux         bool               `json:"mux,omitempty"`
+	ResumeToken string             `json:"resumeToken,omitempty"`
+	RecvSeq     uint64             `json:"recvSeq,omitempty"`
+	Version     int                `json:"version,omitempty"` // protocol version for this connection
 }
 
 // PendingConnection represents a client waiting to be paired
@@ -27,38 +58,127 @@ type PendingConnection struct {
 	Conn      net.Conn
 	Role      string
 	SessionID string
+	Channel   string
+	Stream    uint32 // joiner stream ID; zero for hosts
 	CreatedAt time.Time
+
+	conn    *pendingConn // Conn, which may read ahead while waiting
+	link    *link        // how the relay speaks to Conn
+	paired  bool
+	wake    chan struct{} // closed once paired or removed from the room
+	woken   bool
+	done    chan struct{}        // closed once the paired session has ended
+	host    *muxHost             // set when a multiplexing host takes this joiner
+	out     chan []byte          // data from a multiplexing host, queued for the joiner
+	feed    chan *protocol.Frame // host data teed to a spectator
+	session *liveSession
+}
+
+// resumableStream is a registered stream that a client may reattach to
+type resumableStream struct {
+	conn      *protocol.ResumableConn
+	sessionID string
+	role      string
+	channel   string
+}
+
+// TokenInfo is what a relay token grants
+type TokenInfo struct {
+	ID        string    // unique token ID; the relay takes each token once per channel
+	ExpiresAt time.Time // when the token stops being valid
+	SessionID string
+	Role      string
+	JoinerID  uint32
+	RateLimit int64 // bytes per second in each direction; zero is unshaped
+	ByteQuota int64 // total bytes for the session; zero is unlimited
+
+	Upstream *Upstream // set when the session's host is on another relay node
+	Via      string    // relay node that linked this joiner through, if any
 }
 
 // TokenValidator validates relay tokens
 type TokenValidator interface {
-	Validate(token string) (sessionID, role string, err error)
+	Validate(token string) (*TokenInfo, error)
 }
 
-// Relay handles pairing and forwarding between host and joiner
+// expiryWarning is how long before the max duration clients are warned
+const expiryWarning = 5 * time.Minute
+
+// Relay handles pairing and forwarding between a host and its joiners
 type Relay struct {
 	mu          sync.Mutex
-	pending     map[string]*PendingConnection
+	rooms       map[string]*room
+	sessions    map[string]*liveSession
+	resumable   map[string]*resumableStream
 	validator   TokenValidator
 	pairTimeout time.Duration
-	maxDuration time.Duration
+	maxDuration time.Duration // zero is no cap
+	idleTimeout time.Duration
+	resumeGrace time.Duration
+	draining    bool
+	upstream    UpstreamDialer
+	observer    Observer
+	limits      AdmissionLimits
+	conns       map[string]int       // open connections by source IP
+	used        map[string]time.Time // token ID and channel to token expiry
+	revoked     map[string]time.Time // session, or session and joiner ID, to when its tokens have all expired
+	lastSweep   time.Time
 }
 
-// NewRelay creates a new relay instance
+// NewRelay creates a new relay instance. A zero maxDuration lets sessions
+// run for as long as their clients stay and keep playing.
 func NewRelay(validator TokenValidator, pairTimeout, maxDuration time.Duration) *Relay {
 	r := &Relay{
-		pending:     make(map[string]*PendingConnection),
+		rooms:       make(map[string]*room),
+		sessions:    make(map[string]*liveSession),
+		resumable:   make(map[string]*resumableStream),
+		conns:       make(map[string]int),
+		used:        make(map[string]time.Time),
+		revoked:     make(map[string]time.Time),
 		validator:   validator,
 		pairTimeout: pairTimeout,
 		maxDuration: maxDuration,
 	}
-	go r.cleanupLoop()
 	return r
 }
 
+// wakeLocked tells a waiting client that it was paired or removed
+func (p *PendingConnection) wakeLocked() {
+	if !p.woken {
+		p.woken = true
+		close(p.wake)
+		if p.conn != nil {
+			p.conn.claim()
+		}
+	}
+}
+
+// SetResumeGrace sets how long a resumable stream is held open for its
+// client to reconnect. Zero disables session resume.
+func (r *Relay) SetResumeGrace(grace time.Duration) {
+	r.mu.Lock()
+	r.resumeGrace = grace
+	r.mu.Unlock()
+}
+
+// SetIdleTimeout sets how long a paired session may go without relaying
+// any game data before it is closed. Zero disables the idle timeout.
+func (r *Relay) SetIdleTimeout(timeout time.Duration) {
+	r.mu.Lock()
+	r.idleTimeout = timeout
+	r.mu.Unlock()
+}
+
 // HandleConnection processes a new client connection
 func (r *Relay) HandleConnection(conn net.Conn) {
-	defer conn.Close()
+	ip, release, ok := r.trackConn(conn.RemoteAddr())
+	defer release()
+	if !ok {
+		log.Printf("Rejected connection from %s: too many connections", ip)
+		r.sendAuthError(conn, protocol.CodeTooManyConnections, "Too many connections from your address")
+		conn.Close()
+		return
+	}
 
 	conn.SetDeadline(time.Now().Add(10 * time.Second))
 
@@ -66,142 +186,455 @@ func (r *Relay) HandleConnection(conn net.Conn) {
 	var authMsg AuthMessage
 	if err := decoder.Decode(&authMsg); err != nil {
 		log.Printf("Failed to read auth message: %v", err)
-		r.sendAuthResponse(conn, false, "Invalid auth message")
+		r.sendAuthError(conn, protocol.CodeBadRequest, "Invalid auth message")
+		conn.Close()
+		return
+	}
+
+	if authMsg.ResumeToken != "" {
+		if !r.resume(conn, &authMsg) {
+			conn.Close()
+		}
 		return
 	}
 
-	sessionID, role, err := r.validator.Validate(authMsg.RelayToken)
+	var stream net.Conn = conn
+	defer func() { stream.Close() }()
+
+	info, err := r.validator.Validate(authMsg.RelayToken)
 	if err != nil {
 		log.Printf("Token validation failed: %v", err)
-		r.sendAuthResponse(conn, false, "Invalid token")
+		r.sendAuthError(conn, protocol.CodeInvalidToken, "Invalid token")
 		return
 	}
+	sessionID, role := info.SessionID, info.Role
 
 	if sessionID != authMsg.SessionID || role != authMsg.Role {
 		log.Printf("Token mismatch")
-		r.sendAuthResponse(conn, false, "Token mismatch")
+		r.sendAuthError(conn, protocol.CodeTokenMismatch, "Token mismatch")
 		return
 	}
 
-	log.Printf("Authenticated %s for session %s", role, sessionID)
+	if r.isCancelled(sessionID) {
+		log.Printf("Rejected %s for session %s: cancelled by the host", role, sessionID)
+		r.sendAuthError(conn, protocol.CodeSessionNotFound, "Session was cancelled by the host")
+		return
+	}
 
-	if err := r.sendAuthResponse(conn, true, ""); err != nil {
-		log.Printf("Failed to send auth response: %v", err)
+	if role == "joiner" && r.isRevoked(sessionID, joinerStream(info)) {
+		log.Printf("Rejected joiner %d for session %s: kicked by the host", joinerStream(info), sessionID)
+		r.sendAuthError(conn, protocol.CodeKicked, "Removed from the session by the host")
 		return
 	}
 
-	conn.SetDeadline(time.Time{})
+	channel := authMsg.Channel
+	if channel == "" {
+		channel = ChannelTCP
+	}
+	if channel != ChannelTCP && channel != ChannelUDP {
+		log.Printf("Unknown channel %q", channel)
+		r.sendAuthError(conn, protocol.CodeBadRequest, "Unknown channel")
+		return
+	}
+
+	if role == "spectator" && authMsg.Version < 2 {
+		log.Printf("Rejected spectator for session %s: protocol version 1", sessionID)
+		r.sendAuthError(conn, protocol.CodeBadRequest, "Spectators need protocol version 2")
+		return
+	}
 
 	r.mu.Lock()
-	pending, hasPending := r.pending[sessionID]
+	draining := r.draining
+	r.mu.Unlock()
+	if draining {
+		log.Printf("Rejected %s for session %s (%s): draining", role, sessionID, channel)
+		r.sendAuthError(conn, protocol.CodeShuttingDown, "Relay is shutting down")
+		return
+	}
 
-	if hasPending && pending.Role != role {
-		delete(r.pending, sessionID)
-		r.mu.Unlock()
+	// Linked joiners stop counting against the per-address limit; another
+	// relay node sends them all from one address
+	if info.Via != "" {
+		release()
+	}
 
-		var hostConn, joinerConn net.Conn
-		if role == "host" {
-			hostConn = conn
-			joinerConn = pending.Conn
-		} else {
-			hostConn = pending.Conn
-			joinerConn = conn
+	if code, reason := r.admit(info, channel); code != "" {
+		log.Printf("Rejected %s for session %s (%s) from %s: %s", role, sessionID, channel, ip, reason)
+		r.sendAuthError(conn, code, reason)
+		return
+	}
+
+	if !r.useToken(info, channel) {
+		log.Printf("Rejected %s for session %s (%s) from %s: token already used", role, sessionID, channel, ip)
+		r.sendAuthError(conn, protocol.CodeTokenUsed, "Token already used")
+		return
+	}
+
+	var upstream net.Conn
+	if info.Upstream != nil {
+		if role != "joiner" {
+			log.Printf("Rejected %s for session %s: only joiners are linked to another relay", role, sessionID)
+			r.sendAuthError(conn, protocol.CodeTokenMismatch, "Token mismatch")
+			return
 		}
+		upstream, err = r.dialUpstream(info.Upstream, sessionID, channel)
+		if err != nil {
+			log.Printf("Failed to link session %s (%s) to relay node %s: %v", sessionID, channel, info.Upstream.Node, err)
+			r.sendAuthError(conn, protocol.CodeRelayUnavailable, "Host's relay is unreachable")
+			return
+		}
+		defer upstream.Close()
+	}
 
-		r.pairConnections(sessionID, hostConn, joinerConn)
+	if info.Via != "" {
+		log.Printf("Authenticated %s for session %s (%s) via relay node %s", role, sessionID, channel, info.Via)
 	} else {
-		if hasPending {
-			pending.Conn.Close()
+		log.Printf("Authenticated %s for session %s (%s)", role, sessionID, channel)
+	}
+
+	resp := &AuthResponse{Success: true, Mux: authMsg.Mux && role == "host"}
+	if authMsg.Version >= 2 {
+		resp.Version = protocol.Version
+	}
+
+	r.mu.Lock()
+	grace := r.resumeGrace
+	r.mu.Unlock()
+
+	if authMsg.Resumable && grace > 0 {
+		token, err := generateResumeToken()
+		if err != nil {
+			log.Printf("Failed to generate resume token: %v", err)
+			r.sendAuthError(conn, protocol.CodeInternal, "Internal error")
+			return
 		}
 
-		r.pending[sessionID] = &PendingConnection{
-			Conn:      conn,
-			Role:      role,
-			SessionID: sessionID,
-			CreatedAt: time.Now(),
+		rc := protocol.NewResumableConn(conn, protocol.DefaultResumeWindow, grace)
+		rc.SetCallbacks(func(err error) {
+			log.Printf("Lost %s in session %s (%s), holding for %s: %v", role, sessionID, channel, grace, err)
+		}, func() {
+			log.Printf("Resumed %s in session %s (%s)", role, sessionID, channel)
+		})
+
+		r.mu.Lock()
+		r.resumable[token] = &resumableStream{conn: rc, sessionID: sessionID, role: role, channel: channel}
+		r.mu.Unlock()
+		defer r.forgetResumable(token)
+
+		stream = rc
+		resp.ResumeToken = token
+	}
+
+	if err := writeAuthResponse(conn, resp); err != nil {
+		log.Printf("Failed to send auth response: %v", err)
+		return
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	pc := newPendingConn(stream)
+	defer pc.Close()
+	p := &PendingConnection{
+		Conn:      pc,
+		Role:      role,
+		SessionID: sessionID,
+		Channel:   channel,
+		CreatedAt: time.Now(),
+		conn:      pc,
+		link:      newLink(pc, channel == ChannelUDP || resp.Mux || resp.Version >= 2),
+		wake:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if role == "joiner" {
+		p.Stream = joinerStream(info)
+	}
+	p.session = r.join(info, p)
+	defer r.leave(p.session, p)
+	key := pendingKey(sessionID, channel)
+	if role != "spectator" {
+		r.notify(func(o Observer) { o.ClientConnected(sessionID, role, channel, p.Stream) })
+	}
+
+	switch role {
+	case "host":
+		r.handleHost(key, p, resp.Mux)
+		return
+	case "spectator":
+		r.serveSpectator(key, p)
+		return
+	}
+
+	if upstream != nil {
+		r.serveUpstream(p, upstream, info.Upstream.Node)
+		return
+	}
+	p.out = make(chan []byte, joinerQueueSize)
+	r.handleJoiner(key, p)
+}
+
+// handleHost places a host in its room. A multiplexing host takes every
+// joiner; any other host is paired with the first joiner as before rooms.
+func (r *Relay) handleHost(key string, p *PendingConnection, mux bool) {
+	r.mu.Lock()
+	rm := r.roomLocked(key)
+	if rm.host != nil {
+		log.Printf("Replacing host in %s", key)
+		rm.host.link.drop("replaced by a newer connection")
+		rm.host.wakeLocked()
+	}
+
+	if mux {
+		h := newMuxHost(p)
+		rm.host = p
+		rm.mux = h
+		p.paired = true
+		for _, j := range rm.joiners {
+			if !j.paired {
+				j.paired = true
+				j.host = h
+				j.wakeLocked()
+			}
 		}
 		r.mu.Unlock()
 
-		r.waitForPair(conn, sessionID, role)
+		r.serveMuxHost(key, p, h)
+		return
+	}
+
+	if j := rm.waitingJoiner(); j != nil {
+		delete(rm.joiners, j.Stream)
+		j.paired = true
+		j.wakeLocked()
+		r.dropRoomLocked(key, rm)
+		r.mu.Unlock()
+
+		r.pairConnections(p, j)
+		close(j.done)
+		return
+	}
+
+	rm.host = p
+	rm.mux = nil
+	hangup := p.conn.watch()
+	r.mu.Unlock()
+
+	if r.waitForPair(p, key, hangup) {
+		// The joiner's handler is forwarding; stay registered until it ends
+		<-p.done
 	}
 }
 
-func (r *Relay) sendAuthResponse(conn net.Conn, success bool, errMsg string) error {
-	resp := AuthResponse{Success: success, Error: errMsg}
+// handleJoiner places a joiner in its room and serves it once a host is there
+func (r *Relay) handleJoiner(key string, p *PendingConnection) {
+	r.mu.Lock()
+	rm := r.roomLocked(key)
+	var replaced *PendingConnection
+	if old := rm.joiners[p.Stream]; old != nil {
+		log.Printf("Replacing joiner %d in %s", p.Stream, key)
+		delete(rm.joiners, p.Stream)
+		old.link.drop("replaced by a newer connection")
+		old.wakeLocked()
+		replaced = old
+	}
+
+	if rm.mux != nil {
+		rm.joiners[p.Stream] = p
+		p.paired = true
+		p.host = rm.mux
+		r.mu.Unlock()
+
+		if replaced != nil && replaced.host == p.host {
+			// The host must see the old stream close before the new one opens
+			p.host.send(&protocol.Frame{Type: protocol.FrameClose, Stream: p.Stream, Payload: []byte("replaced by a newer connection")})
+		}
+		r.serveMuxJoiner(key, p)
+		return
+	}
+
+	if h := rm.host; h != nil && !h.paired {
+		rm.host = nil
+		h.paired = true
+		h.wakeLocked()
+		r.dropRoomLocked(key, rm)
+		r.mu.Unlock()
+
+		r.pairConnections(h, p)
+		close(h.done)
+		return
+	}
+
+	rm.joiners[p.Stream] = p
+	hangup := p.conn.watch()
+	r.mu.Unlock()
+
+	if !r.waitForPair(p, key, hangup) {
+		return
+	}
+
+	r.mu.Lock()
+	host := p.host
+	r.mu.Unlock()
+
+	if host != nil {
+		r.serveMuxJoiner(key, p)
+		return
+	}
+	// A single-joiner host's handler is forwarding; stay registered until it ends
+	<-p.done
+}
+
+// resume reattaches a reconnecting client to its stream. It reports whether
+// conn was handed over to the stream.
+func (r *Relay) resume(conn net.Conn, authMsg *AuthMessage) bool {
+	r.mu.Lock()
+	rs, ok := r.resumable[authMsg.ResumeToken]
+	r.mu.Unlock()
+
+	if !ok || rs.sessionID != authMsg.SessionID || rs.role != authMsg.Role {
+		log.Printf("Rejected resume for session %s: unknown token", authMsg.SessionID)
+		r.sendAuthError(conn, protocol.CodeResumeRejected, "Unknown or expired resume token")
+		return false
+	}
+
+	err := rs.conn.Attach(conn, authMsg.RecvSeq, func(recvSeq uint64) error {
+		if err := writeAuthResponse(conn, &AuthResponse{Success: true, RecvSeq: recvSeq}); err != nil {
+			return err
+		}
+		conn.SetDeadline(time.Time{})
+		return nil
+	})
+	if err != nil {
+		log.Printf("Resume failed for %s in session %s (%s): %v", rs.role, rs.sessionID, rs.channel, err)
+		r.sendAuthError(conn, protocol.CodeResumeRejected, "Resume failed")
+		return false
+	}
+
+	return true
+}
+
+func (r *Relay) forgetResumable(token string) {
+	r.mu.Lock()
+	delete(r.resumable, token)
+	r.mu.Unlock()
+}
+
+func generateResumeToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+// pendingKey identifies one channel of a session in the rooms map
+func pendingKey(sessionID, channel string) string {
+	return sessionID + "/" + channel
+}
+
+// sendAuthError turns a client away with an error code and a message
+func (r *Relay) sendAuthError(conn net.Conn, code protocol.ErrorCode, errMsg string) error {
+	return writeAuthResponse(conn, &AuthResponse{Success: false, Error: errMsg, Code: code})
+}
+
+func writeAuthResponse(conn net.Conn, resp *AuthResponse) error {
 	data, _ := json.Marshal(resp)
 	data = append(data, '\n')
 	_, err := conn.Write(data)
 	return err
 }
 
-func (r *Relay) waitForPair(conn net.Conn, sessionID, role string) {
-	deadline := time.Now().Add(r.pairTimeout)
-
-	for {
-		r.mu.Lock()
-		pending, stillPending := r.pending[sessionID]
-		if !stillPending || pending.Conn != conn {
-			r.mu.Unlock()
-			return
-		}
-		r.mu.Unlock()
-
-		if time.Now().After(deadline) {
-			log.Printf("Pair timeout for %s in session %s", role, sessionID)
-			r.mu.Lock()
-			if p, ok := r.pending[sessionID]; ok && p.Conn == conn {
-				delete(r.pending, sessionID)
-			}
-			r.mu.Unlock()
-			return
+// waitForPair blocks until p is paired, leaves its room, hangs up or times
+// out. It reports whether p was paired.
+func (r *Relay) waitForPair(p *PendingConnection, key string, hangup <-chan struct{}) bool {
+	timer := time.NewTimer(r.pairTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-p.wake:
+	case <-hangup:
+		if errors.Is(p.conn.err, errReadAhead) {
+			log.Printf("Dropping %s in %s: %v", p.Role, key, errReadAhead)
+		} else {
+			log.Printf("%s in %s hung up while waiting", p.Role, key)
 		}
+	case <-timer.C:
+		log.Printf("Pair timeout for %s in %s", p.Role, key)
+	}
 
-		time.Sleep(100 * time.Millisecond)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !p.paired {
+		r.leaveRoomLocked(key, p)
 	}
+	return p.paired
 }
 
-func (r *Relay) pairConnections(sessionID string, hostConn, joinerConn net.Conn) {
-	log.Printf("Paired session %s", sessionID)
+// pairConnections forwards between a host and its only joiner until either
+// side leaves
+func (r *Relay) pairConnections(host, joiner *PendingConnection) {
+	sessionID, channel := host.SessionID, host.Channel
+	sess := host.session
+	sess.markPaired()
+	log.Printf("Paired session %s (%s)", sessionID, channel)
+	r.notify(func(o Observer) { o.Paired(sessionID, channel, joiner.Stream) })
 
-	deadline := time.Now().Add(r.maxDuration)
-	hostConn.SetDeadline(deadline)
-	joinerConn.SetDeadline(deadline)
+	host.link.send(&protocol.Frame{Type: protocol.FramePeerPaired, Stream: joiner.Stream})
+	joiner.link.send(&protocol.Frame{Type: protocol.FramePeerPaired})
+
+	stop := r.limitDuration(sessionID, "Session "+sessionID, func() []*PendingConnection {
+		return []*PendingConnection{host, joiner}
+	})
+	defer stop()
 
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
 		defer wg.Done()
-		io.Copy(joinerConn, hostConn)
-		joinerConn.Close()
+		sess.forward(joiner.link, host.link, joiner, false)
+		joiner.link.drop("host left")
 	}()
 
 	go func() {
 		defer wg.Done()
-		io.Copy(hostConn, joinerConn)
-		hostConn.Close()
+		sess.forward(host.link, joiner.link, joiner, true)
+		host.link.drop("joiner left")
 	}()
 
 	wg.Wait()
-	log.Printf("Session %s ended", sessionID)
+	log.Printf("Session %s ended (%s)", sessionID, channel)
 }
 
-func (r *Relay) cleanupLoop() {
-	ticker := time.NewTicker(10 * time.Second)
-	for range ticker.C {
-		r.cleanup()
+// limitDuration ends a session once it reaches the relay's max duration,
+// warning its clients shortly before. It uses timers rather than socket
+// deadlines so the cap survives resumed connections. members is called when
+// a timer fires. The returned func stops the timers.
+func (r *Relay) limitDuration(sessionID, name string, members func() []*PendingConnection) func() {
+	if r.maxDuration <= 0 {
+		return func() {}
 	}
-}
 
-func (r *Relay) cleanup() {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	lead := expiryWarning
+	if lead > r.maxDuration/2 {
+		lead = r.maxDuration / 2
+	}
 
-	threshold := time.Now().Add(-r.pairTimeout)
-	for sessionID, pending := range r.pending {
-		if pending.CreatedAt.Before(threshold) {
-			pending.Conn.Close()
-			delete(r.pending, sessionID)
+	warn := time.AfterFunc(r.maxDuration-lead, func() {
+		notice := protocol.NewCountdownFrame(protocol.FrameSessionExpiring, lead)
+		for _, p := range members() {
+			go p.link.send(notice)
 		}
+		r.notify(func(o Observer) { o.Expiring(sessionID, lead) })
+	})
+	capTimer := time.AfterFunc(r.maxDuration, func() {
+		log.Printf("%s reached max duration (%s)", name, r.maxDuration)
+		for _, p := range members() {
+			p.link.drop("session reached its time limit")
+		}
+	})
+
+	return func() {
+		warn.Stop()
+		capTimer.Stop()
 	}
 }
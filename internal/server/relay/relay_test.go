@@ -0,0 +1,86 @@
+package relay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// testValidator accepts tokens of the form "role joinerID", all for
+// session "s1". Each token is its own ID, so it can be used once per channel.
+type testValidator struct{}
+
+func (testValidator) Validate(token string) (*TokenInfo, error) {
+	info := &TokenInfo{ID: token, SessionID: "s1"}
+	if _, err := fmt.Sscanf(token, "%s %d", &info.Role, &info.JoinerID); err != nil {
+		return nil, fmt.Errorf("bad test token %q: %w", token, err)
+	}
+	return info, nil
+}
+
+// startRelay serves r on a loopback port and returns its address
+func startRelay(t *testing.T, r *Relay) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.HandleConnection(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// dialRelay authenticates to the relay at addr with a test token and
+// returns the connection and the relay's answer
+func dialRelay(t *testing.T, addr, role string, joinerID uint32) (net.Conn, AuthResponse) {
+	t.Helper()
+	return authRelay(t, addr, AuthMessage{SessionID: "s1", RelayToken: fmt.Sprintf("%s %d", role, joinerID), Role: role})
+}
+
+// authRelay sends msg to the relay at addr and returns the connection and
+// the relay's answer
+func authRelay(t *testing.T, addr string, msg AuthMessage) (net.Conn, AuthResponse) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if err := json.NewEncoder(conn).Encode(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the answer a byte at a time so no game data is consumed with it
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReaderSize(oneByteReader{conn}, 16).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("reading auth response: %v", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	var resp AuthResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		t.Fatalf("bad auth response %q: %v", line, err)
+	}
+	return conn, resp
+}
+
+type oneByteReader struct {
+	conn net.Conn
+}
+
+func (r oneByteReader) Read(p []byte) (int, error) {
+	return r.conn.Read(p[:1])
+}
@@ -0,0 +1,44 @@
+package relay
+
+import "time"
+
+// usedSweepInterval is how often expired entries are dropped from the
+// used-token cache
+const usedSweepInterval = time.Minute
+
+// useToken records that a client authenticated with a token on channel. It
+// reports false if the token was already used there, so a leaked token
+// cannot take a session's slot from the client it was issued to. Clients
+// that lose their connection come back with a resume token instead. Tokens
+// without an ID are not tracked.
+func (r *Relay) useToken(info *TokenInfo, channel string) bool {
+	if info.ID == "" {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastSweep) >= usedSweepInterval {
+		// Expired tokens fail validation, so there is no need to remember
+		// them. Tokens stay valid through the second they expire in.
+		for key, expires := range r.used {
+			if now.After(expires.Add(time.Second)) {
+				delete(r.used, key)
+			}
+		}
+		r.lastSweep = now
+	}
+
+	key := info.ID + "/" + channel
+	if _, used := r.used[key]; used {
+		return false
+	}
+	expires := info.ExpiresAt
+	if expires.IsZero() {
+		expires = now.Add(24 * time.Hour)
+	}
+	r.used[key] = expires
+	return true
+}
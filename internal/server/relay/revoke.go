@@ -0,0 +1,67 @@
+package relay
+
+import (
+	"fmt"
+	"time"
+)
+
+// RevokeJoiner refuses joiner joinerID of a session until the given time,
+// by when every relay token issued to it has expired. It does not
+// disconnect the joiner; KickJoiner does.
+func (r *Relay) RevokeJoiner(sessionID string, joinerID uint32, until time.Time) {
+	r.revoke(revokedKey(sessionID, joinerID), until)
+}
+
+// RevokeSession refuses every client of a cancelled session until the given
+// time. It does not disconnect them; CloseSession does.
+func (r *Relay) RevokeSession(sessionID string, until time.Time) {
+	r.revoke(sessionID, until)
+}
+
+func (r *Relay) revoke(key string, until time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for k, expires := range r.revoked {
+		if now.After(expires) {
+			delete(r.revoked, k)
+		}
+	}
+	if until.After(now) {
+		r.revoked[key] = until
+	}
+}
+
+// isRevoked reports whether the host kicked joiner joinerID of a session
+func (r *Relay) isRevoked(sessionID string, joinerID uint32) bool {
+	return r.revokedUntilNow(revokedKey(sessionID, joinerID))
+}
+
+// isCancelled reports whether the host cancelled a session
+func (r *Relay) isCancelled(sessionID string) bool {
+	return r.revokedUntilNow(sessionID)
+}
+
+func (r *Relay) revokedUntilNow(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	until, ok := r.revoked[key]
+	return ok && time.Now().Before(until)
+}
+
+// revokedKey is the key of a kicked joiner; a cancelled session is keyed by
+// its ID alone
+func revokedKey(sessionID string, joinerID uint32) string {
+	return fmt.Sprintf("%s/%d", sessionID, joinerID)
+}
+
+// joinerStream is the stream ID of the joiner a token was issued to. Tokens
+// issued before rooms carry no joiner ID; they were the only joiner.
+func joinerStream(info *TokenInfo) uint32 {
+	if info.JoinerID == 0 {
+		return 1
+	}
+	return info.JoinerID
+}
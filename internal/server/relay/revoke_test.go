@@ -0,0 +1,92 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+func TestRevokedJoinerIsRefused(t *testing.T) {
+	r := NewRelay(testValidator{}, time.Minute, 0)
+	addr := startRelay(t, r)
+
+	r.RevokeJoiner("s1", 1, time.Now().Add(time.Minute))
+	r.RevokeJoiner("s1", 3, time.Now().Add(-time.Second)) // already run out
+
+	tests := []struct {
+		joinerID uint32
+		want     protocol.ErrorCode
+	}{
+		{1, protocol.CodeKicked},
+		{2, ""},
+		{3, ""},
+	}
+	for _, tt := range tests {
+		_, resp := dialRelay(t, addr, "joiner", tt.joinerID)
+		if tt.want == "" && !resp.Success {
+			t.Errorf("joiner %d refused: %s", tt.joinerID, resp.Error)
+		}
+		if tt.want != "" && (resp.Success || resp.Code != tt.want) {
+			t.Errorf("joiner %d: code %q, want %q", tt.joinerID, resp.Code, tt.want)
+		}
+	}
+}
+
+func TestRevokedTokenIsNotUsedUp(t *testing.T) {
+	r := NewRelay(testValidator{}, time.Minute, 0)
+	addr := startRelay(t, r)
+
+	// A refused joiner must not get far enough to spend its token
+	r.RevokeJoiner("s1", 1, time.Now().Add(time.Minute))
+	if _, resp := dialRelay(t, addr, "joiner", 1); resp.Code != protocol.CodeKicked {
+		t.Fatalf("code %q, want %q", resp.Code, protocol.CodeKicked)
+	}
+	r.mu.Lock()
+	used := len(r.used)
+	r.mu.Unlock()
+	if used != 0 {
+		t.Errorf("%d tokens marked used", used)
+	}
+}
+
+func TestCancelledSessionIsClosedAndRefused(t *testing.T) {
+	r := NewRelay(testValidator{}, time.Minute, 0)
+	addr := startRelay(t, r)
+
+	host, resp := dialRelay(t, addr, "host", 0)
+	if !resp.Success {
+		t.Fatalf("host refused: %s", resp.Error)
+	}
+	waitRooms(t, r, 1)
+
+	// What a node does when a heartbeat tells it the host cancelled
+	r.RevokeSession("s1", time.Now().Add(time.Minute))
+	if !r.CloseSession("s1", "cancelled by the host") {
+		t.Fatal("session not found")
+	}
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, host)
+		close(closed)
+	}()
+	waitClosed(t, closed, "waiting host")
+
+	tests := []struct {
+		role     string
+		joinerID uint32
+	}{
+		{"host", 0},
+		{"joiner", 2},
+		{"spectator", 1},
+	}
+	for _, tt := range tests {
+		msg := AuthMessage{SessionID: "s1", RelayToken: fmt.Sprintf("%s %d", tt.role, tt.joinerID), Role: tt.role, Version: protocol.Version}
+		if _, resp := authRelay(t, addr, msg); resp.Success || resp.Code != protocol.CodeSessionNotFound {
+			t.Errorf("%s after cancelling: success %v, code %q", tt.role, resp.Success, resp.Code)
+		}
+	}
+
+}
@@ -0,0 +1,259 @@
+package relay
+
+import (
+	"io"
+	"log"
+	"sort"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// joinerQueueSize bounds how many chunks from the host may wait for a slow
+// joiner before that joiner is dropped, so one joiner cannot stall the room
+const joinerQueueSize = 1024
+
+// room holds the clients of one session channel: a host and its joiners.
+// Joiners are keyed by stream ID.
+type room struct {
+	host    *PendingConnection
+	mux     *muxHost // set while a multiplexing host serves the room
+	joiners map[uint32]*PendingConnection
+}
+
+// muxHost is a host connection that carries every joiner as framed streams
+type muxHost struct {
+	link    *link
+	channel string
+}
+
+func newMuxHost(p *PendingConnection) *muxHost {
+	return &muxHost{link: p.link, channel: p.Channel}
+}
+
+// send writes a frame to the host; frames from many joiners are interleaved
+func (h *muxHost) send(f *protocol.Frame) error {
+	return h.link.send(f)
+}
+
+// roomLocked returns the room for key, creating it if needed
+func (r *Relay) roomLocked(key string) *room {
+	rm, ok := r.rooms[key]
+	if !ok {
+		rm = &room{joiners: make(map[uint32]*PendingConnection)}
+		r.rooms[key] = rm
+	}
+	return rm
+}
+
+// dropRoomLocked forgets rm once nobody is left in it
+func (r *Relay) dropRoomLocked(key string, rm *room) {
+	if rm.host == nil && len(rm.joiners) == 0 && r.rooms[key] == rm {
+		delete(r.rooms, key)
+	}
+}
+
+// inRoomLocked reports whether p is still registered in its room
+func (r *Relay) inRoomLocked(key string, p *PendingConnection) bool {
+	rm, ok := r.rooms[key]
+	if !ok {
+		return false
+	}
+	if p.Role == "host" {
+		return rm.host == p
+	}
+	return rm.joiners[p.Stream] == p
+}
+
+// leaveRoomLocked removes p from its room. It reports whether p was there.
+func (r *Relay) leaveRoomLocked(key string, p *PendingConnection) bool {
+	if !r.inRoomLocked(key, p) {
+		return false
+	}
+	rm := r.rooms[key]
+	if p.Role == "host" {
+		rm.host = nil
+		rm.mux = nil
+	} else {
+		delete(rm.joiners, p.Stream)
+	}
+	p.wakeLocked()
+	r.dropRoomLocked(key, rm)
+	return true
+}
+
+// waitingJoiner returns the unpaired joiner with the lowest stream ID
+func (rm *room) waitingJoiner() *PendingConnection {
+	var ids []uint32
+	for id, j := range rm.joiners {
+		if !j.paired {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	return rm.joiners[ids[0]]
+}
+
+// members returns everyone in the room
+func (rm *room) members() []*PendingConnection {
+	var all []*PendingConnection
+	if rm.host != nil {
+		all = append(all, rm.host)
+	}
+	for _, j := range rm.joiners {
+		all = append(all, j)
+	}
+	return all
+}
+
+// joinerLocked returns the joiner on stream id if it is served by h
+func (r *Relay) joinerLocked(key string, id uint32, h *muxHost) *PendingConnection {
+	rm, ok := r.rooms[key]
+	if !ok {
+		return nil
+	}
+	j := rm.joiners[id]
+	if j == nil || j.host != h {
+		return nil
+	}
+	return j
+}
+
+// serveMuxHost reads frames from a multiplexing host and routes them to its
+// joiners until the host disconnects, then closes the joiners it served
+func (r *Relay) serveMuxHost(key string, p *PendingConnection, h *muxHost) {
+	log.Printf("Room %s open", key)
+
+	stop := r.limitDuration(p.SessionID, "Room "+key, func() []*PendingConnection {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		members := []*PendingConnection{p}
+		if rm, ok := r.rooms[key]; ok {
+			for _, j := range rm.joiners {
+				if j.host == h {
+					members = append(members, j)
+				}
+			}
+		}
+		return members
+	})
+	defer stop()
+
+	for {
+		f, err := p.link.readFrame()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Host in %s disconnected: %v", key, err)
+			}
+			break
+		}
+
+		r.mu.Lock()
+		j := r.joinerLocked(key, f.Stream, h)
+		r.mu.Unlock()
+		if j == nil {
+			continue
+		}
+
+		switch f.Type {
+		case protocol.FrameData:
+			if err := p.session.account(j, false, f.Payload); err != nil {
+				// Over quota: the whole session is being closed
+				continue
+			}
+			select {
+			case j.out <- f.Payload:
+			default:
+				log.Printf("Joiner %d in %s is too slow, dropping it", j.Stream, key)
+				j.link.drop("too slow to keep up with the host")
+			}
+		case protocol.FrameClose:
+			j.link.drop("closed by host")
+		}
+	}
+
+	var orphans []*PendingConnection
+	r.mu.Lock()
+	if rm, ok := r.rooms[key]; ok {
+		if rm.host == p {
+			rm.host = nil
+			rm.mux = nil
+		}
+		for _, j := range rm.joiners {
+			if j.host == h {
+				orphans = append(orphans, j)
+			}
+		}
+		r.dropRoomLocked(key, rm)
+	}
+	r.mu.Unlock()
+
+	for _, j := range orphans {
+		j.link.drop("host left")
+	}
+	log.Printf("Room %s closed", key)
+}
+
+// serveMuxJoiner forwards a joiner's traffic to its multiplexing host as
+// frames tagged with the joiner's stream ID, and writes the host's frames
+// for that stream back to the joiner
+func (r *Relay) serveMuxJoiner(key string, p *PendingConnection) {
+	h := p.host
+	defer p.Conn.Close()
+
+	if err := h.send(&protocol.Frame{Type: protocol.FrameOpen, Stream: p.Stream}); err != nil {
+		r.mu.Lock()
+		r.leaveRoomLocked(key, p)
+		r.mu.Unlock()
+		return
+	}
+	log.Printf("Joiner %d joined %s", p.Stream, key)
+	p.session.markPaired()
+	p.link.send(&protocol.Frame{Type: protocol.FramePeerPaired})
+	r.notify(func(o Observer) { o.Paired(p.SessionID, p.Channel, p.Stream) })
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.writeToJoiner(p, stop)
+
+	for {
+		payload, err := p.link.readData()
+		if err != nil {
+			break
+		}
+
+		if err := p.session.account(p, true, payload); err != nil {
+			break
+		}
+		if err := h.send(&protocol.Frame{Type: protocol.FrameData, Stream: p.Stream, Payload: payload}); err != nil {
+			break
+		}
+	}
+
+	r.mu.Lock()
+	left := r.leaveRoomLocked(key, p)
+	r.mu.Unlock()
+
+	// A replaced joiner was already closed on the host's side
+	if left {
+		h.send(&protocol.Frame{Type: protocol.FrameClose, Stream: p.Stream, Payload: []byte("joiner left")})
+	}
+	log.Printf("Joiner %d left %s", p.Stream, key)
+}
+
+// writeToJoiner drains the joiner's queue of data from the host
+func (r *Relay) writeToJoiner(p *PendingConnection, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case payload := <-p.out:
+			if err := p.link.writeData(payload); err != nil {
+				p.Conn.Close()
+				return
+			}
+		}
+	}
+}
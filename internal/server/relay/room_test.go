@@ -0,0 +1,95 @@
+package relay
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// nextFrame reads frames from conn until one of type typ arrives, skipping
+// control frames a client may ignore
+func nextFrame(t *testing.T, conn net.Conn, typ protocol.FrameType) *protocol.Frame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		f, err := protocol.ReadFrame(conn)
+		if err != nil {
+			t.Fatalf("waiting for frame type %d: %v", typ, err)
+		}
+		if f.Type == typ {
+			return f
+		}
+	}
+}
+
+func TestMuxHostWithV1AndV2Joiners(t *testing.T) {
+	r := NewRelay(testValidator{}, time.Minute, 0)
+	addr := startRelay(t, r)
+
+	host, resp := authRelay(t, addr, AuthMessage{SessionID: "s1", RelayToken: "host 0", Role: "host", Mux: true, Version: protocol.Version})
+	if !resp.Success || !resp.Mux {
+		t.Fatalf("host: success %v, mux %v: %s", resp.Success, resp.Mux, resp.Error)
+	}
+
+	// Joiner 1 is a version 1 client on a raw pipe, joiner 2 speaks frames
+	v1, resp := dialRelay(t, addr, "joiner", 1)
+	if !resp.Success {
+		t.Fatalf("v1 joiner refused: %s", resp.Error)
+	}
+	if f := nextFrame(t, host, protocol.FrameOpen); f.Stream != 1 {
+		t.Fatalf("host opened stream %d, want 1", f.Stream)
+	}
+	v2, resp := authRelay(t, addr, AuthMessage{SessionID: "s1", RelayToken: "joiner 2", Role: "joiner", Version: protocol.Version})
+	if !resp.Success || resp.Version < 2 {
+		t.Fatalf("v2 joiner: success %v, version %d: %s", resp.Success, resp.Version, resp.Error)
+	}
+	if f := nextFrame(t, host, protocol.FrameOpen); f.Stream != 2 {
+		t.Fatalf("host opened stream %d, want 2", f.Stream)
+	}
+	nextFrame(t, v2, protocol.FramePeerPaired)
+
+	// Joiners to the host, tagged with their streams
+	v1.Write([]byte("one"))
+	if f := nextFrame(t, host, protocol.FrameData); f.Stream != 1 || string(f.Payload) != "one" {
+		t.Fatalf("host got %q on stream %d, want %q on 1", f.Payload, f.Stream, "one")
+	}
+	protocol.WriteFrame(v2, &protocol.Frame{Type: protocol.FrameData, Payload: []byte("two")})
+	if f := nextFrame(t, host, protocol.FrameData); f.Stream != 2 || string(f.Payload) != "two" {
+		t.Fatalf("host got %q on stream %d, want %q on 2", f.Payload, f.Stream, "two")
+	}
+
+	// The host's frames are routed by stream, raw to joiner 1 and framed to joiner 2
+	protocol.WriteFrame(host, &protocol.Frame{Type: protocol.FrameData, Stream: 2, Payload: []byte("to2")})
+	protocol.WriteFrame(host, &protocol.Frame{Type: protocol.FrameData, Stream: 1, Payload: []byte("to1")})
+	buf := make([]byte, 3)
+	v1.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := io.ReadFull(v1, buf); err != nil || string(buf) != "to1" {
+		t.Fatalf("v1 joiner read %q, %v; want %q", buf, err, "to1")
+	}
+	if f := nextFrame(t, v2, protocol.FrameData); string(f.Payload) != "to2" {
+		t.Fatalf("v2 joiner got %q, want %q", f.Payload, "to2")
+	}
+
+	// Closing one stream leaves the other up
+	protocol.WriteFrame(host, &protocol.Frame{Type: protocol.FrameClose, Stream: 1})
+	v1.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := v1.Read(buf); err == nil {
+		t.Fatal("v1 joiner still open after the host closed its stream")
+	}
+	protocol.WriteFrame(host, &protocol.Frame{Type: protocol.FrameData, Stream: 2, Payload: []byte("still")})
+	if f := nextFrame(t, v2, protocol.FrameData); string(f.Payload) != "still" {
+		t.Fatalf("v2 joiner got %q, want %q", f.Payload, "still")
+	}
+
+	// The host hears of joiner 2 leaving; stream 1's close may come back first
+	v2.Close()
+	for f := nextFrame(t, host, protocol.FrameClose); f.Stream != 2; f = nextFrame(t, host, protocol.FrameClose) {
+		if f.Stream != 1 {
+			t.Fatalf("host saw stream %d close, want 2", f.Stream)
+		}
+	}
+}
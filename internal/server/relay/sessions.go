@@ -0,0 +1,420 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// errQuotaExceeded is returned once a session has used up its byte quota
+var errQuotaExceeded = errors.New("byte quota exceeded")
+
+// minBurst lets a single chunk or frame through even at very low rates
+const minBurst = 64 * 1024
+
+// liveSession tracks everything the relay holds for one session across all
+// of its channels and joiners: the connected clients, traffic counters and
+// the session's limits, a token bucket per direction and a total byte quota.
+// The limits come from the relay token of whichever client arrives first;
+// signaling issues the same tier to every client of a session. Traffic teed
+// to spectators is not charged to the session.
+type liveSession struct {
+	sessionID string
+	toHost    *rate.Limiter // nil when unshaped
+	fromHost  *rate.Limiter
+	quota     int64 // zero when unlimited
+
+	bytesToHost   atomic.Int64
+	bytesFromHost atomic.Int64
+	lastActive    atomic.Int64 // unix nanoseconds of the last relayed game data
+
+	idleTimeout time.Duration // zero never closes idle sessions
+
+	capture  atomic.Pointer[sessionCapture] // nil unless an admin is capturing
+	watchers atomic.Int32                   // len(spectators), read without the lock
+
+	mu         sync.Mutex
+	members    map[*PendingConnection]struct{}
+	spectators map[*PendingConnection]struct{} // also in members
+	pairedAt   time.Time                       // zero until the first client is paired
+	idle       *time.Timer                     // fires when the session may have gone idle
+	closed     bool
+}
+
+func newLiveSession(info *TokenInfo) *liveSession {
+	s := &liveSession{
+		sessionID:  info.SessionID,
+		quota:      info.ByteQuota,
+		members:    make(map[*PendingConnection]struct{}),
+		spectators: make(map[*PendingConnection]struct{}),
+	}
+	if info.RateLimit > 0 {
+		burst := int(info.RateLimit)
+		if burst < minBurst {
+			burst = minBurst
+		}
+		s.toHost = rate.NewLimiter(rate.Limit(info.RateLimit), burst)
+		s.fromHost = rate.NewLimiter(rate.Limit(info.RateLimit), burst)
+	}
+	return s
+}
+
+// join registers p with its session, creating the session on first use
+func (r *Relay) join(info *TokenInfo, p *PendingConnection) *liveSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[info.SessionID]
+	if !ok {
+		s = newLiveSession(info)
+		s.idleTimeout = r.idleTimeout
+		r.sessions[info.SessionID] = s
+	}
+	s.mu.Lock()
+	s.members[p] = struct{}{}
+	if s.closed {
+		// Over quota or killed while the last clients were still leaving
+		p.Conn.Close()
+	}
+	s.mu.Unlock()
+	return s
+}
+
+// leave unregisters p, forgetting the session once it has no clients left
+func (r *Relay) leave(s *liveSession, p *PendingConnection) {
+	r.mu.Lock()
+
+	s.mu.Lock()
+	delete(s.members, p)
+	switch p.Role {
+	case "spectator":
+		s.unwatchLocked(p)
+	case "host":
+		if !s.hasHostLocked(p.Channel) {
+			s.dropSpectatorsLocked(p.Channel, "host left")
+		}
+	}
+	last := len(s.members) == 0
+	if last {
+		s.stopIdleLocked()
+	}
+	s.mu.Unlock()
+
+	if last {
+		s.stopCapture()
+	} else if c := s.capture.Load(); c != nil {
+		c.end(p)
+	}
+
+	ended := last && r.sessions[s.sessionID] == s
+	if ended {
+		delete(r.sessions, s.sessionID)
+	}
+	r.mu.Unlock()
+
+	if ended {
+		r.notify(func(o Observer) { o.Ended(s.sessionID) })
+	}
+}
+
+// markPaired records when the session started relaying traffic and starts
+// watching it for idleness
+func (s *liveSession) markPaired() {
+	s.mu.Lock()
+	if s.pairedAt.IsZero() {
+		s.pairedAt = time.Now()
+		s.lastActive.Store(s.pairedAt.UnixNano())
+		if s.idleTimeout > 0 && !s.closed {
+			s.idle = time.AfterFunc(s.idleTimeout, s.checkIdle)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// isPaired reports whether the session has started relaying traffic
+func (s *liveSession) isPaired() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.pairedAt.IsZero()
+}
+
+// checkIdle closes the session if no game data has moved in either
+// direction for the idle timeout, and otherwise checks again when it next
+// could have. Control frames such as pings don't count as activity, so a
+// client that stays connected but stops playing is still let go.
+func (s *liveSession) checkIdle() {
+	idle := time.Since(time.Unix(0, s.lastActive.Load()))
+	if idle >= s.idleTimeout {
+		s.close(fmt.Sprintf("no traffic for %s", s.idleTimeout))
+		return
+	}
+
+	s.mu.Lock()
+	if s.idle != nil {
+		s.idle.Reset(s.idleTimeout - idle)
+	}
+	s.mu.Unlock()
+}
+
+func (s *liveSession) stopIdleLocked() {
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+}
+
+// account waits until the session may relay p between joiner j and the host
+// in the given direction, charges it to the session's counters and quota and
+// records it if the session is being captured
+func (s *liveSession) account(j *PendingConnection, toHost bool, p []byte) error {
+	n := len(p)
+	lim, counter := s.fromHost, &s.bytesFromHost
+	if toHost {
+		lim, counter = s.toHost, &s.bytesToHost
+	}
+
+	if lim != nil {
+		for left := n; left > 0; {
+			chunk := left
+			if chunk > lim.Burst() {
+				chunk = lim.Burst()
+			}
+			if err := lim.WaitN(context.Background(), chunk); err != nil {
+				return err
+			}
+			left -= chunk
+		}
+	}
+
+	counter.Add(int64(n))
+	s.lastActive.Store(time.Now().UnixNano())
+	if s.quota > 0 && s.bytesToHost.Load()+s.bytesFromHost.Load() > s.quota {
+		s.close(errQuotaExceeded.Error())
+		return errQuotaExceeded
+	}
+	if c := s.capture.Load(); c != nil {
+		c.record(j, toHost, p)
+	}
+	if !toHost {
+		s.tee(j, p)
+	}
+	return nil
+}
+
+// close disconnects every client of the session, once
+func (s *liveSession) close(reason string) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.stopIdleLocked()
+	members := make([]*PendingConnection, 0, len(s.members))
+	for p := range s.members {
+		members = append(members, p)
+	}
+	s.mu.Unlock()
+
+	log.Printf("Closing session %s: %s", s.sessionID, reason)
+	for _, p := range members {
+		p.link.drop(reason)
+	}
+}
+
+// forward relays game data from src to dst, shaping the given direction and
+// charging the session's quota, until either side fails. j is the joiner
+// at one end.
+func (s *liveSession) forward(dst, src *link, j *PendingConnection, toHost bool) error {
+	for {
+		p, err := src.readData()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.account(j, toHost, p); err != nil {
+			return err
+		}
+		if err := dst.writeData(p); err != nil {
+			return err
+		}
+	}
+}
+
+// PeerInfo describes one client connected to the relay
+type PeerInfo struct {
+	Role        string    `json:"role"`
+	Channel     string    `json:"channel"`
+	Stream      uint32    `json:"stream,omitempty"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// PendingInfo describes a client still waiting for its peer
+type PendingInfo struct {
+	SessionID string `json:"sessionId"`
+	PeerInfo
+	WaitingSeconds float64 `json:"waitingSeconds"`
+}
+
+// SessionInfo describes a session that is relaying traffic
+type SessionInfo struct {
+	SessionID       string     `json:"sessionId"`
+	Peers           []PeerInfo `json:"peers"`
+	BytesToHost     int64      `json:"bytesToHost"`
+	BytesFromHost   int64      `json:"bytesFromHost"`
+	StartedAt       time.Time  `json:"startedAt"`
+	DurationSeconds float64    `json:"durationSeconds"`
+	IdleSeconds     float64    `json:"idleSeconds"` // time since game data last moved
+}
+
+func peerInfo(p *PendingConnection) PeerInfo {
+	info := PeerInfo{
+		Role:        p.Role,
+		Channel:     p.Channel,
+		Stream:      p.Stream,
+		ConnectedAt: p.CreatedAt,
+	}
+	if addr := p.Conn.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+	return info
+}
+
+// Pending lists the clients waiting to be paired
+func (r *Relay) Pending() []PendingInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	pending := []PendingInfo{}
+	for _, rm := range r.rooms {
+		for _, p := range rm.members() {
+			if p.paired {
+				continue
+			}
+			pending = append(pending, PendingInfo{
+				SessionID:      p.SessionID,
+				PeerInfo:       peerInfo(p),
+				WaitingSeconds: now.Sub(p.CreatedAt).Seconds(),
+			})
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].ConnectedAt.Before(pending[j].ConnectedAt)
+	})
+	return pending
+}
+
+// Sessions lists the sessions that are relaying traffic
+func (r *Relay) Sessions() []SessionInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	sessions := []SessionInfo{}
+	for _, s := range r.sessions {
+		s.mu.Lock()
+		if s.pairedAt.IsZero() {
+			s.mu.Unlock()
+			continue
+		}
+		info := SessionInfo{
+			SessionID:       s.sessionID,
+			Peers:           make([]PeerInfo, 0, len(s.members)),
+			BytesToHost:     s.bytesToHost.Load(),
+			BytesFromHost:   s.bytesFromHost.Load(),
+			StartedAt:       s.pairedAt,
+			DurationSeconds: now.Sub(s.pairedAt).Seconds(),
+			IdleSeconds:     now.Sub(time.Unix(0, s.lastActive.Load())).Seconds(),
+		}
+		for p := range s.members {
+			info.Peers = append(info.Peers, peerInfo(p))
+		}
+		s.mu.Unlock()
+
+		sort.Slice(info.Peers, func(i, j int) bool {
+			a, b := info.Peers[i], info.Peers[j]
+			if a.Channel != b.Channel {
+				return a.Channel > b.Channel // tcp before udp
+			}
+			return a.Stream < b.Stream
+		})
+		sessions = append(sessions, info)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+	return sessions
+}
+
+// HasSession reports whether any client of a session is connected to the
+// relay, paired or pending
+func (r *Relay) HasSession(sessionID string) bool {
+	r.mu.Lock()
+	s, ok := r.sessions[sessionID]
+	r.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.closed
+}
+
+// KillSession disconnects every client of a session, paired or pending. It
+// reports whether the session was connected to the relay.
+func (r *Relay) KillSession(sessionID string) bool {
+	return r.CloseSession(sessionID, "terminated by admin")
+}
+
+// CloseSession disconnects every client of a session, telling them reason.
+// It reports whether the session was connected to the relay.
+func (r *Relay) CloseSession(sessionID, reason string) bool {
+	r.mu.Lock()
+	s, ok := r.sessions[sessionID]
+	r.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	s.close(reason)
+	return true
+}
+
+// KickJoiner disconnects joiner joinerID of a session, or every joiner if
+// joinerID is zero. It reports whether any of them was connected.
+func (r *Relay) KickJoiner(sessionID string, joinerID uint32, reason string) bool {
+	r.mu.Lock()
+	s, ok := r.sessions[sessionID]
+	r.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	s.mu.Lock()
+	var kicked []*PendingConnection
+	for p := range s.members {
+		if p.Role == "joiner" && (joinerID == 0 || p.Stream == joinerID) {
+			kicked = append(kicked, p)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, p := range kicked {
+		log.Printf("Kicking joiner %d from session %s (%s)", p.Stream, sessionID, p.Channel)
+		p.link.drop(reason)
+	}
+	return len(kicked) > 0
+}
@@ -0,0 +1,103 @@
+package relay
+
+import (
+	"log"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// spectatorQueueSize bounds how many chunks of host data may wait for a
+// slow spectator before it is dropped, so spectators cannot stall the match
+const spectatorQueueSize = 1024
+
+// serveSpectator sends a spectator a copy of the game data the host sends
+// on its channel, each chunk framed with the stream of the joiner it was
+// meant for, until the spectator leaves or the host does. Anything the
+// spectator sends is read and thrown away.
+func (r *Relay) serveSpectator(key string, p *PendingConnection) {
+	p.feed = make(chan *protocol.Frame, spectatorQueueSize)
+	p.session.watch(p)
+	log.Printf("Spectator joined %s", key)
+	p.link.send(&protocol.Frame{Type: protocol.FramePeerPaired})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.writeToSpectator(p, stop)
+
+	for {
+		if _, err := p.link.readData(); err != nil {
+			break
+		}
+	}
+	log.Printf("Spectator left %s", key)
+}
+
+// writeToSpectator drains the spectator's queue of data from the host
+func (r *Relay) writeToSpectator(p *PendingConnection, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case f := <-p.feed:
+			if err := p.link.send(f); err != nil {
+				p.Conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// watch adds spectator p to the session
+func (s *liveSession) watch(p *PendingConnection) {
+	s.mu.Lock()
+	s.spectators[p] = struct{}{}
+	s.watchers.Store(int32(len(s.spectators)))
+	s.mu.Unlock()
+}
+
+// unwatchLocked removes spectator p from the session
+func (s *liveSession) unwatchLocked(p *PendingConnection) {
+	delete(s.spectators, p)
+	s.watchers.Store(int32(len(s.spectators)))
+}
+
+// tee queues a copy of data the host sent to joiner j for the spectators
+// watching j's channel. Spectators that fall behind are dropped.
+func (s *liveSession) tee(j *PendingConnection, p []byte) {
+	if s.watchers.Load() == 0 {
+		return
+	}
+	f := &protocol.Frame{Type: protocol.FrameData, Stream: j.Stream, Payload: append([]byte(nil), p...)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sp := range s.spectators {
+		if sp.Channel != j.Channel {
+			continue
+		}
+		select {
+		case sp.feed <- f:
+		default:
+			sp.link.drop("too slow to keep up with the host")
+		}
+	}
+}
+
+// hasHostLocked reports whether a host is connected on channel
+func (s *liveSession) hasHostLocked(channel string) bool {
+	for p := range s.members {
+		if p.Role == "host" && p.Channel == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// dropSpectatorsLocked disconnects the spectators watching channel
+func (s *liveSession) dropSpectatorsLocked(channel, reason string) {
+	for sp := range s.spectators {
+		if sp.Channel == channel {
+			sp.link.drop(reason)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package relay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+func TestOldSpectatorKeepsItsToken(t *testing.T) {
+	r := NewRelay(testValidator{}, time.Minute, 0)
+	addr := startRelay(t, r)
+
+	msg := AuthMessage{SessionID: "s1", RelayToken: "spectator 1", Role: "spectator"}
+	if _, resp := authRelay(t, addr, msg); resp.Success || resp.Code != protocol.CodeBadRequest {
+		t.Fatalf("version 1 spectator: success %v, code %q", resp.Success, resp.Code)
+	}
+
+	// Refusing the old client must leave the token for a retry with a new one
+	msg.Version = protocol.Version
+	if _, resp := authRelay(t, addr, msg); !resp.Success {
+		t.Fatalf("version %d spectator refused: %s (%s)", msg.Version, resp.Error, resp.Code)
+	}
+}
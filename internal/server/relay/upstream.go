@@ -0,0 +1,88 @@
+package relay
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// Upstream is the relay node serving a session's host, for a joiner that
+// signaling placed on another node. The joiner is spliced through to it over
+// an inter-relay link.
+type Upstream struct {
+	Node  string // node ID, for logs
+	Addr  string
+	TLS   bool
+	Pin   string // certificate fingerprint; empty verifies against CAs
+	Token string // relay token the link presents to the upstream node
+}
+
+// UpstreamDialer opens inter-relay links. The connection it returns has
+// authenticated as the joiner on channel and speaks protocol version 2.
+type UpstreamDialer interface {
+	DialUpstream(up *Upstream, sessionID, channel string) (net.Conn, error)
+}
+
+// SetUpstreamDialer lets the relay link joiners through to other relay
+// nodes. Without one, tokens naming an upstream node are refused.
+func (r *Relay) SetUpstreamDialer(d UpstreamDialer) {
+	r.mu.Lock()
+	r.upstream = d
+	r.mu.Unlock()
+}
+
+// dialUpstream opens the link for a joiner whose host is on another node
+func (r *Relay) dialUpstream(up *Upstream, sessionID, channel string) (net.Conn, error) {
+	r.mu.Lock()
+	d := r.upstream
+	r.mu.Unlock()
+
+	if d == nil {
+		return nil, fmt.Errorf("relay links are not enabled on this node")
+	}
+	return d.DialUpstream(up, sessionID, channel)
+}
+
+// serveUpstream splices a joiner through to the node serving its host. That
+// node pairs the link like any other joiner; its control frames are passed
+// on, so the joiner hears when the host arrives or leaves.
+func (r *Relay) serveUpstream(p *PendingConnection, upstream net.Conn, node string) {
+	up := newLink(upstream, true)
+	sess := p.session
+
+	r.mu.Lock()
+	p.paired = true
+	r.mu.Unlock()
+	sess.markPaired()
+	log.Printf("Linked joiner %d of session %s (%s) to relay node %s", p.Stream, p.SessionID, p.Channel, node)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sess.forward(up, p.link, p, true)
+		upstream.Close()
+	}()
+
+	for {
+		f, err := up.readFrame()
+		if err != nil {
+			break
+		}
+		if f.Type != protocol.FrameData {
+			p.link.send(f)
+			continue
+		}
+		if err := sess.account(p, false, f.Payload); err != nil {
+			break
+		}
+		if err := p.link.writeData(f.Payload); err != nil {
+			break
+		}
+	}
+
+	p.link.drop("host's relay closed the link")
+	<-done
+	log.Printf("Link for joiner %d of session %s (%s) to relay node %s closed", p.Stream, p.SessionID, p.Channel, node)
+}
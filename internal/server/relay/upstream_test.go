@@ -0,0 +1,102 @@
+package relay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+// edgeValidator is testValidator for a node whose joiners' hosts are on the
+// relay at home
+type edgeValidator struct {
+	home string
+}
+
+func (v edgeValidator) Validate(token string) (*TokenInfo, error) {
+	info, err := testValidator{}.Validate(token)
+	if err == nil && info.Role == "joiner" {
+		info.Upstream = &Upstream{Node: "home", Addr: v.home, Token: token}
+	}
+	return info, err
+}
+
+// testDialer links joiners to the upstream node with the same token
+type testDialer struct{}
+
+func (testDialer) DialUpstream(up *Upstream, sessionID, channel string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", up.Addr)
+	if err != nil {
+		return nil, err
+	}
+	msg := AuthMessage{SessionID: sessionID, RelayToken: up.Token, Role: "joiner", Channel: channel, Version: protocol.Version}
+	if err := json.NewEncoder(conn).Encode(msg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	line, err := bufio.NewReaderSize(oneByteReader{conn}, 16).ReadBytes('\n')
+	var resp AuthResponse
+	if err == nil {
+		err = json.Unmarshal(line, &resp)
+	}
+	if err == nil && !resp.Success {
+		err = fmt.Errorf("refused: %s", resp.Error)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func TestUpstreamLink(t *testing.T) {
+	home := NewRelay(testValidator{}, time.Minute, 0)
+	homeAddr := startRelay(t, home)
+	edge := NewRelay(edgeValidator{home: homeAddr}, time.Minute, 0)
+	edge.SetUpstreamDialer(testDialer{})
+	edgeAddr := startRelay(t, edge)
+
+	host, resp := authRelay(t, homeAddr, AuthMessage{SessionID: "s1", RelayToken: "host 0", Role: "host", Mux: true, Version: protocol.Version})
+	if !resp.Success {
+		t.Fatalf("host refused: %s", resp.Error)
+	}
+	joiner, resp := authRelay(t, edgeAddr, AuthMessage{SessionID: "s1", RelayToken: "joiner 1", Role: "joiner", Version: protocol.Version})
+	if !resp.Success {
+		t.Fatalf("joiner refused at the edge: %s", resp.Error)
+	}
+
+	// The home node pairs the link; its control frames reach the joiner
+	if f := nextFrame(t, host, protocol.FrameOpen); f.Stream != 1 {
+		t.Fatalf("host opened stream %d, want 1", f.Stream)
+	}
+	nextFrame(t, joiner, protocol.FramePeerPaired)
+
+	protocol.WriteFrame(joiner, &protocol.Frame{Type: protocol.FrameData, Payload: []byte("up")})
+	if f := nextFrame(t, host, protocol.FrameData); f.Stream != 1 || string(f.Payload) != "up" {
+		t.Fatalf("host got %q on stream %d, want %q on 1", f.Payload, f.Stream, "up")
+	}
+	protocol.WriteFrame(host, &protocol.Frame{Type: protocol.FrameData, Stream: 1, Payload: []byte("down")})
+	if f := nextFrame(t, joiner, protocol.FrameData); string(f.Payload) != "down" {
+		t.Fatalf("joiner got %q, want %q", f.Payload, "down")
+	}
+
+	// Closing the stream at home ends the link, and the joiner is told why
+	protocol.WriteFrame(host, &protocol.Frame{Type: protocol.FrameClose, Stream: 1})
+	if f := nextFrame(t, joiner, protocol.FramePeerLeft); string(f.Payload) != "closed by host" {
+		t.Errorf("joiner told %q, want %q", f.Payload, "closed by host")
+	}
+	joiner.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		_, err := protocol.ReadFrame(joiner)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Fatal("edge kept the joiner after the link closed")
+		}
+		if err != nil {
+			break
+		}
+	}
+}
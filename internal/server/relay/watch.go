@@ -0,0 +1,139 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+)
+
+// watchLimit bounds how many bytes a waiting client may send before the
+// relay stops reading ahead of its peer
+const watchLimit = 64 * 1024
+
+// watchChunk is the read-ahead buffer size. Thousands of clients may be
+// waiting at once, and game traffic comes in small messages.
+const watchChunk = 4 * 1024
+
+// errReadAhead is why a waiting client that sent more than the relay reads
+// ahead is let go
+var errReadAhead = errors.New("sent too much before its peer arrived")
+
+// pendingConn is a client's connection as held by the relay. Once the client
+// starts waiting for a peer, a pump reads ahead from the socket so a client
+// that hangs up, or whose TCP keepalives go unanswered, is noticed at once
+// instead of when a peer finally arrives. Data read ahead is kept for the
+// peer; the pump keeps feeding Read after pairing, as a blocked read cannot
+// be taken back from it, but then only reads once Read has taken what it
+// has. A client that sends more than watchLimit bytes before it is paired is
+// treated as failed, so the pump never stops watching a waiting client.
+type pendingConn struct {
+	net.Conn
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	watching bool
+	ahead    bytes.Buffer  // read from the socket, not yet by Read
+	claimed  bool          // set once paired or removed
+	closed   bool          // set by Close, which stops the pump
+	dead     chan struct{} // closed once the socket fails
+	err      error         // why the socket failed; set before dead is closed
+}
+
+func newPendingConn(conn net.Conn) *pendingConn {
+	c := &pendingConn{Conn: conn}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// watch starts the read-ahead pump and returns a channel closed when the
+// client hangs up. It must be called under the relay lock before anyone else
+// may read from the connection.
+func (c *pendingConn) watch() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.watching {
+		c.watching = true
+		c.dead = make(chan struct{})
+		go c.pump()
+	}
+	return c.dead
+}
+
+// claim tells the pump that the client has stopped waiting, so it only reads
+// as fast as Read takes the data
+func (c *pendingConn) claim() {
+	c.mu.Lock()
+	c.claimed = true
+	c.mu.Unlock()
+}
+
+func (c *pendingConn) pump() {
+	buf := make([]byte, watchChunk)
+	for {
+		c.mu.Lock()
+		for c.claimed && c.ahead.Len() > 0 && !c.closed {
+			c.cond.Wait()
+		}
+		if c.closed {
+			c.failLocked(net.ErrClosed)
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+
+		n, err := c.Conn.Read(buf)
+
+		c.mu.Lock()
+		c.ahead.Write(buf[:n])
+		if err == nil && !c.claimed && c.ahead.Len() > watchLimit {
+			err = errReadAhead
+		}
+		if err != nil {
+			c.failLocked(err)
+		}
+		c.cond.Broadcast()
+		c.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// failLocked records why the pump stopped and wakes everyone waiting on it
+func (c *pendingConn) failLocked(err error) {
+	c.err = err
+	close(c.dead)
+	c.cond.Broadcast()
+}
+
+// Read reads what the pump read ahead once the connection is watched, or
+// directly. Data read before the socket failed is returned first.
+func (c *pendingConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	if !c.watching {
+		c.mu.Unlock()
+		return c.Conn.Read(p)
+	}
+	defer c.mu.Unlock()
+
+	for c.ahead.Len() == 0 && c.err == nil {
+		c.cond.Wait()
+	}
+	if c.ahead.Len() == 0 {
+		return 0, c.err
+	}
+	n, _ := c.ahead.Read(p)
+	c.cond.Broadcast()
+	return n, nil
+}
+
+// Close closes the connection and stops the pump, even while it waits for
+// a reader that is gone
+func (c *pendingConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
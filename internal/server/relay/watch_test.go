@@ -0,0 +1,182 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// waitClosed fails the test unless ch is closed within a few seconds
+func waitClosed(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("%s: timed out", what)
+	}
+}
+
+func TestPendingConnReadAhead(t *testing.T) {
+	tests := []struct {
+		name    string
+		claimed bool
+		send    int   // bytes the client sends while nobody reads
+		closeIt bool  // close the pendingConn afterwards
+		wantErr error // why the pump stopped; nil if it must still run
+	}{
+		{"fits the limit", false, watchLimit / 2, false, nil},
+		{"overflows while waiting", false, watchLimit + 2*watchChunk, false, errReadAhead},
+		{"paired reader falls behind", true, watchLimit + 2*watchChunk, false, nil},
+		{"closed while the pump is blocked", true, watchLimit + 2*watchChunk, true, net.ErrClosed},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			pc := newPendingConn(server)
+			defer pc.Close()
+			if tt.claimed {
+				pc.claim()
+			}
+			dead := pc.watch()
+
+			// Writes to a pipe block until read, so the writer gives up once the pump has
+			go func() {
+				client.SetWriteDeadline(time.Now().Add(3 * time.Second))
+				client.Write(make([]byte, tt.send))
+			}()
+			time.Sleep(100 * time.Millisecond)
+			if tt.closeIt {
+				pc.Close()
+			}
+
+			if tt.wantErr == nil {
+				select {
+				case <-dead:
+					t.Fatalf("pump stopped: %v", pc.err)
+				case <-time.After(200 * time.Millisecond):
+				}
+				return
+			}
+			waitClosed(t, dead, "pump")
+			if !errors.Is(pc.err, tt.wantErr) {
+				t.Errorf("pump stopped with %v, want %v", pc.err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPendingConnKeepsDataForPeer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	pc := newPendingConn(server)
+	defer pc.Close()
+	pc.watch()
+
+	want := []byte("sent while waiting")
+	go client.Write(want)
+	time.Sleep(50 * time.Millisecond)
+	pc.claim()
+
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(pc, got); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("read %q, %v; want %q", got, err, want)
+	}
+}
+
+func TestHangupWhileWaiting(t *testing.T) {
+	for _, role := range []string{"host", "joiner"} {
+		t.Run(role, func(t *testing.T) {
+			r := NewRelay(testValidator{}, time.Minute, 0)
+			addr := startRelay(t, r)
+
+			conn, resp := dialRelay(t, addr, role, 1)
+			if !resp.Success {
+				t.Fatalf("refused: %s", resp.Error)
+			}
+			waitRooms(t, r, 1)
+
+			conn.Close()
+			waitRooms(t, r, 0)
+		})
+	}
+}
+
+func TestOverflowWhileWaiting(t *testing.T) {
+	r := NewRelay(testValidator{}, time.Minute, 0)
+	addr := startRelay(t, r)
+
+	conn, resp := dialRelay(t, addr, "host", 0)
+	if !resp.Success {
+		t.Fatalf("refused: %s", resp.Error)
+	}
+	waitRooms(t, r, 1)
+
+	go func() {
+		conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
+		conn.Write(make([]byte, watchLimit+2*watchChunk))
+	}()
+	waitRooms(t, r, 0)
+}
+
+func TestManySmallWritesWhileWaiting(t *testing.T) {
+	r := NewRelay(testValidator{}, time.Minute, 0)
+	addr := startRelay(t, r)
+
+	// A version 1 host bridge dials the game at once and passes on whatever
+	// it sends, in many small writes, before any joiner arrives
+	host, resp := dialRelay(t, addr, "host", 0)
+	if !resp.Success {
+		t.Fatalf("host refused: %s", resp.Error)
+	}
+	waitRooms(t, r, 1)
+	var want []byte
+	for i := 0; i < 50; i++ {
+		msg := []byte{byte(i), 'h', 'i'}
+		want = append(want, msg...)
+		if _, err := host.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	joiner, resp := dialRelay(t, addr, "joiner", 1)
+	if !resp.Success {
+		t.Fatalf("joiner refused: %s", resp.Error)
+	}
+	got := make([]byte, len(want))
+	joiner.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := io.ReadFull(joiner, got); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("joiner read %d bytes, %v; want what the host sent while waiting", len(got), err)
+	}
+
+	// Both ways still work once paired
+	joiner.Write([]byte("back"))
+	back := make([]byte, 4)
+	host.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := io.ReadFull(host, back); err != nil || string(back) != "back" {
+		t.Fatalf("host read %q, %v", back, err)
+	}
+}
+
+// waitRooms waits until the relay has n rooms
+func waitRooms(t *testing.T, r *Relay, n int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		r.mu.Lock()
+		got := len(r.rooms)
+		r.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("relay has %d rooms, want %d", got, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
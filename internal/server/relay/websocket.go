@@ -0,0 +1,52 @@
+package relay
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// WebSocketPath is where the relay's WebSocket endpoint is served
+const WebSocketPath = "/relay"
+
+// WebSocketHandler serves the relay over WebSocket for players whose networks
+// only allow HTTP(S). Each connection carries the same auth handshake and
+// byte stream as a plain TCP connection, in binary messages.
+func (r *Relay) WebSocketHandler() http.Handler {
+	// No Handshake: the game client sends no Origin, and tokens authenticate it
+	return websocket.Server{Handler: func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		conn := &wsConn{Conn: ws, remote: remoteAddr(ws.Request()), closed: make(chan struct{})}
+		go r.HandleConnection(conn)
+		// The WebSocket is torn down when this handler returns
+		<-conn.closed
+	}}
+}
+
+// wsConn adapts a server-side WebSocket to the relay
+type wsConn struct {
+	*websocket.Conn
+	remote    net.Addr
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// RemoteAddr returns the client's address; websocket.Conn reports its Origin
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *wsConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { close(c.closed) })
+	return err
+}
+
+func remoteAddr(req *http.Request) net.Addr {
+	if addr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr); err == nil {
+		return addr
+	}
+	return &net.TCPAddr{}
+}
@@ -0,0 +1,55 @@
+package relay
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/client/transport"
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/protocol"
+)
+
+func TestWebSocketClientPairsWithTCPClient(t *testing.T) {
+	r := NewRelay(testValidator{}, time.Minute, 0)
+	tcpAddr := startRelay(t, r)
+	srv := httptest.NewServer(r.WebSocketHandler())
+	defer srv.Close()
+
+	// A version 1 host on plain TCP
+	host, resp := dialRelay(t, tcpAddr, "host", 0)
+	if !resp.Success {
+		t.Fatalf("host refused: %s", resp.Error)
+	}
+
+	// The client fills in the relay's path when the URL has none
+	client := transport.NewRelayClient("ws"+strings.TrimPrefix(srv.URL, "http"), false)
+	if err := client.Connect("s1", "joiner 1", "joiner"); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if client.Version() != protocol.Version {
+		t.Fatalf("WebSocket client speaks version %d, want %d", client.Version(), protocol.Version)
+	}
+	ws := client.GetConn()
+	nextFrame(t, ws, protocol.FramePeerPaired)
+
+	// The WebSocket joiner's frames reach the host as its raw byte stream
+	protocol.WriteFrame(ws, &protocol.Frame{Type: protocol.FrameData, Payload: []byte("over ws")})
+	buf := make([]byte, len("over ws"))
+	host.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := io.ReadFull(host, buf); err != nil || string(buf) != "over ws" {
+		t.Fatalf("host read %q, %v", buf, err)
+	}
+	host.Write([]byte("over tcp"))
+	if f := nextFrame(t, ws, protocol.FrameData); string(f.Payload) != "over tcp" {
+		t.Fatalf("WebSocket joiner got %q, want %q", f.Payload, "over tcp")
+	}
+
+	// Hanging up the host ends the pairing for the joiner
+	host.Close()
+	if f := nextFrame(t, ws, protocol.FramePeerLeft); len(f.Payload) == 0 {
+		t.Error("joiner not told why the host left")
+	}
+}
@@ -0,0 +1,65 @@
+package session
+
+import "time"
+
+// Backend holds the signaling server's sessions. Store keeps them in memory
+// only; FileStore also logs them to disk so join codes and tokens survive a
+// restart.
+type Backend interface {
+	// Create creates a new session and returns it
+	Create() (*Session, error)
+
+	// GetByID retrieves a live session by ID
+	GetByID(id string) (*Session, bool)
+
+	// GetByCode retrieves a live session by join code
+	GetByCode(code string) (*Session, bool)
+
+	// Join admits a new joiner to a session and generates its token
+	Join(code string) (*Session, *Joiner, error)
+
+	// Spectate admits a spectator to a session and generates its token
+	Spectate(code string) (*Session, *Spectator, error)
+
+	// SetAllowSpectators sets whether a session admits spectators
+	SetAllowSpectators(id string, allow bool) error
+
+	// SetLobby lists a session in the lobby browser, or unlists it if lobby
+	// is nil
+	SetLobby(id string, lobby *Lobby) error
+
+	// Lobbies returns copies of the live sessions listed in the lobby
+	// browser, newest first
+	Lobbies() []Session
+
+	// SetHostConnected marks the host as connected to relay
+	SetHostConnected(id string, connected bool) error
+
+	// SetJoinConnected marks the joiner as connected to relay
+	SetJoinConnected(id string, connected bool) error
+
+	// SetRelayNode records the relay node the session was assigned to
+	SetRelayNode(id, node string) error
+
+	// Extend pushes a session's expiry back to a full TTL from now
+	Extend(id string) (time.Time, error)
+
+	// RegenerateCode gives a session a new join code
+	RegenerateCode(id string) (string, error)
+
+	// Kick removes a joiner, or every joiner if joinerID is zero
+	Kick(id string, joinerID uint32) ([]uint32, error)
+
+	// Delete removes a session
+	Delete(id string)
+
+	// ValidateToken checks if a token is valid for a session
+	ValidateToken(sessionID, token, role string) bool
+
+	// Expire removes sessions past their TTL and returns how many it
+	// removed. Backends also expire sessions on their own every minute.
+	Expire() int
+
+	// Close stops the backend and releases what it holds open
+	Close() error
+}
@@ -0,0 +1,217 @@
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// compactMinRecords is how long the session log may grow before it is
+// compacted. Past that it is compacted once it holds more than twice as
+// many records as there are live sessions.
+const compactMinRecords = 1024
+
+// maxRecordSize bounds one line of the session log
+const maxRecordSize = 1 << 20
+
+// logRecord is one line of the session log
+type logRecord struct {
+	Op      string   `json:"op"` // "put" or "delete"
+	ID      string   `json:"id,omitempty"`
+	Session *Session `json:"session,omitempty"`
+}
+
+// FileStore is a Store that also appends every change to a JSON log on disk,
+// one record per line, so join codes and tokens survive a restart. The log
+// is replayed when the store is opened and rewritten with just the live
+// sessions as it grows.
+type FileStore struct {
+	*Store
+
+	// Guarded by Store.mu
+	path    string
+	f       *os.File
+	records int
+}
+
+// OpenFileStore opens the session log at path, creating it if needed, and
+// loads the sessions in it that have not expired
+func OpenFileStore(path string, ttl time.Duration) (*FileStore, error) {
+	fs := &FileStore{Store: newStore(ttl), path: path}
+	if err := fs.load(); err != nil {
+		return nil, err
+	}
+
+	fs.Store.mu.Lock()
+	err := fs.compactLocked()
+	restored := len(fs.Store.sessions)
+	fs.Store.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if restored > 0 {
+		log.Printf("Restored %d sessions from %s", restored, path)
+	}
+
+	fs.Store.journal = fs
+	go fs.Store.cleanupLoop()
+	return fs, nil
+}
+
+// Close stops the store and closes the session log
+func (fs *FileStore) Close() error {
+	fs.Store.Close()
+
+	fs.Store.mu.Lock()
+	defer fs.Store.mu.Unlock()
+	if fs.f == nil {
+		return nil
+	}
+	err := fs.f.Sync()
+	if cerr := fs.f.Close(); err == nil {
+		err = cerr
+	}
+	fs.f = nil
+	return err
+}
+
+// load replays the session log into the store
+func (fs *FileStore) load() error {
+	f, err := os.Open(fs.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open session log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var rec logRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// Most likely the tail of a write cut short by a crash
+			log.Printf("Skipping bad record on line %d of %s: %v", line, fs.path, err)
+			continue
+		}
+		switch rec.Op {
+		case "put":
+			if rec.Session == nil {
+				continue
+			}
+			if old, ok := fs.Store.sessions[rec.Session.ID]; ok {
+				delete(fs.Store.byCodes, old.Code)
+			}
+			fs.Store.sessions[rec.Session.ID] = rec.Session
+			fs.Store.byCodes[rec.Session.Code] = rec.Session.ID
+		case "delete":
+			if old, ok := fs.Store.sessions[rec.ID]; ok {
+				delete(fs.Store.byCodes, old.Code)
+				delete(fs.Store.sessions, rec.ID)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read session log: %w", err)
+	}
+
+	now := time.Now()
+	for id, session := range fs.Store.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(fs.Store.byCodes, session.Code)
+			delete(fs.Store.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (fs *FileStore) put(session *Session) error {
+	return fs.appendLocked(logRecord{Op: "put", Session: session})
+}
+
+func (fs *FileStore) remove(id string) error {
+	return fs.appendLocked(logRecord{Op: "delete", ID: id})
+}
+
+func (fs *FileStore) expired(sessions map[string]*Session) error {
+	if fs.records < compactMinRecords || fs.records <= 2*len(sessions) {
+		return nil
+	}
+	return fs.compactLocked()
+}
+
+// appendLocked writes one record to the end of the log
+func (fs *FileStore) appendLocked(rec logRecord) error {
+	if fs.f == nil {
+		return errors.New("session log is closed")
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	fs.records++
+	return nil
+}
+
+// compactLocked rewrites the log with one record per live session, then
+// reopens it for appending. The new log replaces the old one only once it
+// has been written in full.
+func (fs *FileStore) compactLocked() error {
+	tmp, err := os.CreateTemp(filepath.Dir(fs.path), filepath.Base(fs.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to compact session log: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	records := 0
+	for _, session := range fs.Store.sessions {
+		if err := enc.Encode(logRecord{Op: "put", Session: session}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to compact session log: %w", err)
+		}
+		records++
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to compact session log: %w", err)
+	}
+
+	// Windows cannot replace a file that is still open
+	if fs.f != nil {
+		fs.f.Close()
+		fs.f = nil
+	}
+	renameErr := os.Rename(tmp.Name(), fs.path)
+	if renameErr == nil {
+		fs.records = records
+	}
+
+	f, err := os.OpenFile(fs.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open session log: %w", err)
+	}
+	fs.f = f
+	if renameErr != nil {
+		return fmt.Errorf("failed to compact session log: %w", renameErr)
+	}
+	return nil
+}
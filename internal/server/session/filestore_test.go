@@ -0,0 +1,233 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// logLines counts the records in the session log at path
+func logLines(t *testing.T, path string) int {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(b, []byte("\n"))
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	join := func(fs *FileStore, id string, n int) error {
+		sess, _ := fs.GetByID(id)
+		for i := 0; i < n; i++ {
+			if _, _, err := fs.Join(sess.Code); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		change     func(fs *FileStore, id string) error
+		gone       bool
+		nextJoiner uint32 // ID the first joiner after reopening gets
+	}{
+		{"created", func(*FileStore, string) error { return nil }, false, 1},
+		{"joined", func(fs *FileStore, id string) error { return join(fs, id, 2) }, false, 3},
+		{"all kicked", func(fs *FileStore, id string) error {
+			if err := join(fs, id, 2); err != nil {
+				return err
+			}
+			_, err := fs.Kick(id, 0)
+			return err
+		}, false, 3},
+		{"last kicked", func(fs *FileStore, id string) error {
+			if err := join(fs, id, 2); err != nil {
+				return err
+			}
+			_, err := fs.Kick(id, 2)
+			return err
+		}, false, 3},
+		{"new code", func(fs *FileStore, id string) error { _, err := fs.RegenerateCode(id); return err }, false, 1},
+		{"spectators allowed", func(fs *FileStore, id string) error { return fs.SetAllowSpectators(id, true) }, false, 1},
+		{"relay node set", func(fs *FileStore, id string) error { return fs.SetRelayNode(id, "node-2") }, false, 1},
+		{"deleted", func(fs *FileStore, id string) error { fs.Delete(id); return nil }, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sessions.log")
+			fs, err := OpenFileStore(path, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sess, err := fs.Create()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.change(fs, sess.ID); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := fs.GetByID(sess.ID)
+			oldCode := sess.Code
+			if err := fs.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			fs, err = OpenFileStore(path, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fs.Close()
+
+			after, ok := fs.GetByID(sess.ID)
+			if tt.gone {
+				if ok {
+					t.Fatal("deleted session came back")
+				}
+				if _, ok := fs.GetByCode(oldCode); ok {
+					t.Fatal("deleted session's code still works")
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("session lost on reopen")
+			}
+			want, _ := json.Marshal(before)
+			got, _ := json.Marshal(after)
+			if !bytes.Equal(got, want) {
+				t.Errorf("reopened session\n%s\nwant\n%s", got, want)
+			}
+			if byCode, ok := fs.GetByCode(after.Code); !ok || byCode.ID != sess.ID {
+				t.Errorf("code %s does not find the session", after.Code)
+			}
+			if after.Code != oldCode {
+				if _, ok := fs.GetByCode(oldCode); ok {
+					t.Errorf("old code %s still works", oldCode)
+				}
+			}
+
+			_, j, err := fs.Join(after.Code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if j.ID != tt.nextJoiner {
+				t.Errorf("joiner ID after reopening = %d, want %d", j.ID, tt.nextJoiner)
+			}
+		})
+	}
+}
+
+func TestFileStoreSkipsExpiredAndBadRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.log")
+	fs, err := OpenFileStore(path, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := fs.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+
+	fs, err = OpenFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, err := fs.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+
+	// A crash in the middle of a write leaves half a record behind
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"put","session":{"id":"tor`)
+	f.Close()
+
+	time.Sleep(5 * time.Millisecond)
+	fs, err = OpenFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	if _, ok := fs.GetByID(expired.ID); ok {
+		t.Error("expired session restored")
+	}
+	if _, ok := fs.GetByCode(expired.Code); ok {
+		t.Error("expired session's code restored")
+	}
+	if _, ok := fs.GetByID(live.ID); !ok {
+		t.Error("live session lost")
+	}
+	if n := logLines(t, path); n != 1 {
+		t.Errorf("log holds %d records after reopening, want 1", n)
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates int // changes made to the surviving session
+		records int // records in the log after Expire
+	}{
+		{"below the minimum", 100, 2 + 100 + 1}, // two creates, the updates and a delete
+		{"past the minimum", compactMinRecords, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sessions.log")
+			fs, err := OpenFileStore(path, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kept, err := fs.Create()
+			if err != nil {
+				t.Fatal(err)
+			}
+			dropped, err := fs.Create()
+			if err != nil {
+				t.Fatal(err)
+			}
+			fs.Delete(dropped.ID)
+			for i := 0; i < tt.updates; i++ {
+				if err := fs.SetHostConnected(kept.ID, i%2 == 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fs.Expire()
+			if n := logLines(t, path); n != tt.records {
+				t.Errorf("log holds %d records, want %d", n, tt.records)
+			}
+
+			// The log keeps working after it was rewritten
+			if err := fs.SetAllowSpectators(kept.ID, true); err != nil {
+				t.Fatal(err)
+			}
+			fs.Close()
+
+			fs, err = OpenFileStore(path, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fs.Close()
+			sess, ok := fs.GetByID(kept.ID)
+			if !ok || !sess.AllowSpectators || sess.HostConnected != (tt.updates%2 == 1) {
+				t.Errorf("session after compaction and reopening: %+v, %v", sess, ok)
+			}
+			if _, ok := fs.GetByID(dropped.ID); ok {
+				t.Error("deleted session came back")
+			}
+		})
+	}
+}
@@ -2,42 +2,132 @@ package session
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/Deze-Tingz/SFO_Connectivity_Helper/internal/joincode"
 )
 
-// Session represents a game session between host and joiner
+// DefaultMaxJoiners is how many joiners a session admits unless configured
+const DefaultMaxJoiners = 7
+
+// ErrSessionFull is returned by Join when the session has no free slot
+var ErrSessionFull = errors.New("session is full")
+
+// ErrJoinerNotFound is returned by Kick when the session has no such joiner
+var ErrJoinerNotFound = errors.New("joiner not found")
+
+// ErrSpectatorsDenied is returned by Spectate when the host does not allow
+// spectators
+var ErrSpectatorsDenied = errors.New("session does not allow spectators")
+
+// Session represents a game room: one host, up to MaxJoiners joiners and,
+// if the host allows it, any number of spectators
 type Session struct {
-	ID            string    `json:"id"`
-	Code          string    `json:"code"`
-	HostToken     string    `json:"hostToken,omitempty"`
-	JoinToken     string    `json:"joinToken,omitempty"`
-	HostConnected bool      `json:"hostConnected"`
-	JoinConnected bool      `json:"joinConnected"`
-	CreatedAt     time.Time `json:"createdAt"`
-	ExpiresAt     time.Time `json:"expiresAt"`
+	ID              string       `json:"id"`
+	Code            string       `json:"code"`
+	HostToken       string       `json:"hostToken,omitempty"`
+	Joiners         []*Joiner    `json:"joiners,omitempty"`
+	NextJoinerID    uint32       `json:"nextJoinerId,omitempty"` // ID the next joiner gets; IDs are never reused
+	MaxJoiners      int          `json:"maxJoiners"`
+	AllowSpectators bool         `json:"allowSpectators"`
+	Spectators      []*Spectator `json:"spectators,omitempty"`
+	HostConnected   bool         `json:"hostConnected"`
+	JoinConnected   bool         `json:"joinConnected"`
+	RelayNode       string       `json:"relayNode,omitempty"` // relay node the session was assigned to
+	Lobby           *Lobby       `json:"lobby,omitempty"`     // set when the session is listed publicly
+	CreatedAt       time.Time    `json:"createdAt"`
+	ExpiresAt       time.Time    `json:"expiresAt"`
+}
+
+// Lobby is what the host of a public session shows in the lobby browser
+type Lobby struct {
+	Nickname    string `json:"nickname,omitempty"`
+	Region      string `json:"region,omitempty"`
+	Skill       string `json:"skill,omitempty"` // free-form note, e.g. "beginners welcome"
+	GameVersion string `json:"gameVersion,omitempty"`
+}
+
+// Joiner is a player admitted to a session. ID doubles as the joiner's
+// stream ID on the host's relay connection.
+type Joiner struct {
+	ID       uint32    `json:"id"`
+	Token    string    `json:"token,omitempty"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+// Spectator is a viewer admitted to a session. The relay sends spectators
+// the host's traffic but never lets them write to the match.
+type Spectator struct {
+	ID       uint32    `json:"id"`
+	Token    string    `json:"token,omitempty"`
+	JoinedAt time.Time `json:"joinedAt"`
 }
 
 // Store is an in-memory session store with TTL
 type Store struct {
-	mu       sync.RWMutex
-	sessions map[string]*Session
-	byCodes  map[string]string
-	ttl      time.Duration
+	mu         sync.RWMutex
+	sessions   map[string]*Session
+	byCodes    map[string]string
+	ttl        time.Duration
+	maxJoiners int
+	codes      *joincode.Generator
+	journal    journal // nil keeps sessions in memory only
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// journal records changes to a store's sessions. Its methods are called
+// with the store locked.
+type journal interface {
+	// put records the current state of a session
+	put(session *Session) error
+
+	// remove records that a session was deleted
+	remove(id string) error
+
+	// expired is told which sessions are left after expired ones were
+	// dropped, so it can forget the rest
+	expired(sessions map[string]*Session) error
 }
 
 // NewStore creates a new session store
 func NewStore(ttl time.Duration) *Store {
-	s := &Store{
-		sessions: make(map[string]*Session),
-		byCodes:  make(map[string]string),
-		ttl:      ttl,
-	}
+	s := newStore(ttl)
 	go s.cleanupLoop()
 	return s
 }
 
+func newStore(ttl time.Duration) *Store {
+	return &Store{
+		sessions:   make(map[string]*Session),
+		byCodes:    make(map[string]string),
+		ttl:        ttl,
+		maxJoiners: DefaultMaxJoiners,
+		codes:      joincode.Default(),
+		done:       make(chan struct{}),
+	}
+}
+
+// SetMaxJoiners sets how many joiners new sessions admit
+func (s *Store) SetMaxJoiners(n int) {
+	s.mu.Lock()
+	s.maxJoiners = n
+	s.mu.Unlock()
+}
+
+// SetCodeGenerator sets how join codes for new sessions are made
+func (s *Store) SetCodeGenerator(g *joincode.Generator) {
+	s.mu.Lock()
+	s.codes = g
+	s.mu.Unlock()
+}
+
 // Create creates a new session and returns it
 func (s *Store) Create() (*Session, error) {
 	s.mu.Lock()
@@ -48,9 +138,12 @@ func (s *Store) Create() (*Session, error) {
 		return nil, fmt.Errorf("failed to generate session ID: %w", err)
 	}
 
-	code, err := generateCode()
+	code, err := s.codes.Generate(func(code string) bool {
+		_, taken := s.byCodes[code]
+		return taken
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate join code: %w", err)
+		return nil, err
 	}
 
 	hostToken, err := generateID(32)
@@ -60,11 +153,16 @@ func (s *Store) Create() (*Session, error) {
 
 	now := time.Now()
 	session := &Session{
-		ID:        id,
-		Code:      code,
-		HostToken: hostToken,
-		CreatedAt: now,
-		ExpiresAt: now.Add(s.ttl),
+		ID:           id,
+		Code:         code,
+		HostToken:    hostToken,
+		MaxJoiners:   s.maxJoiners,
+		NextJoinerID: 1,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(s.ttl),
+	}
+	if err := s.saveLocked(session); err != nil {
+		return nil, err
 	}
 
 	s.sessions[id] = session
@@ -102,32 +200,140 @@ func (s *Store) GetByCode(code string) (*Session, bool) {
 	return session, true
 }
 
-// Join generates a join token for an existing session
-func (s *Store) Join(code string) (*Session, error) {
+// Join admits a new joiner to an existing session and generates its token
+func (s *Store) Join(code string) (*Session, *Joiner, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	id, ok := s.byCodes[code]
 	if !ok {
-		return nil, fmt.Errorf("session not found")
+		return nil, nil, fmt.Errorf("session not found")
 	}
 
 	session, ok := s.sessions[id]
 	if !ok || time.Now().After(session.ExpiresAt) {
-		return nil, fmt.Errorf("session expired")
+		return nil, nil, fmt.Errorf("session expired")
 	}
 
-	if session.JoinToken != "" {
-		return nil, fmt.Errorf("session already has a joiner")
+	if len(session.Joiners) >= session.MaxJoiners {
+		return nil, nil, ErrSessionFull
 	}
 
 	joinToken, err := generateID(32)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate join token: %w", err)
+		return nil, nil, fmt.Errorf("failed to generate join token: %w", err)
 	}
 
-	session.JoinToken = joinToken
-	return session, nil
+	// IDs are never reused, so a kicked joiner's stream stays its own.
+	// Sessions logged before the counter was kept start past their joiners.
+	joinerID := session.NextJoinerID
+	for _, j := range session.Joiners {
+		if j.ID >= joinerID {
+			joinerID = j.ID + 1
+		}
+	}
+	if joinerID == 0 {
+		joinerID = 1
+	}
+	joiner := &Joiner{
+		ID:       joinerID,
+		Token:    joinToken,
+		JoinedAt: time.Now(),
+	}
+	err = s.changeLocked(session, func(session *Session) {
+		session.Joiners = append(session.Joiners, joiner)
+		session.NextJoinerID = joinerID + 1
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, joiner, nil
+}
+
+// Spectate admits a spectator to an existing session and generates its token
+func (s *Store) Spectate(code string) (*Session, *Spectator, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.byCodes[code]
+	if !ok {
+		return nil, nil, fmt.Errorf("session not found")
+	}
+
+	session, ok := s.sessions[id]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return nil, nil, fmt.Errorf("session expired")
+	}
+
+	if !session.AllowSpectators {
+		return nil, nil, ErrSpectatorsDenied
+	}
+
+	token, err := generateID(32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate spectator token: %w", err)
+	}
+
+	spectator := &Spectator{
+		ID:       uint32(len(session.Spectators) + 1),
+		Token:    token,
+		JoinedAt: time.Now(),
+	}
+	err = s.changeLocked(session, func(session *Session) {
+		session.Spectators = append(session.Spectators, spectator)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, spectator, nil
+}
+
+// SetAllowSpectators sets whether a session admits spectators
+func (s *Store) SetAllowSpectators(id string, allow bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return fmt.Errorf("session not found")
+	}
+	return s.changeLocked(session, func(session *Session) {
+		session.AllowSpectators = allow
+	})
+}
+
+// SetLobby lists a session in the lobby browser with the host's details, or
+// unlists it if lobby is nil
+func (s *Store) SetLobby(id string, lobby *Lobby) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return fmt.Errorf("session not found")
+	}
+	return s.changeLocked(session, func(session *Session) {
+		session.Lobby = lobby
+	})
+}
+
+// Lobbies returns copies of the live sessions listed in the lobby browser,
+// newest first
+func (s *Store) Lobbies() []Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var lobbies []Session
+	for _, session := range s.sessions {
+		if session.Lobby != nil && now.Before(session.ExpiresAt) {
+			lobbies = append(lobbies, *session)
+		}
+	}
+	sort.Slice(lobbies, func(i, j int) bool {
+		return lobbies[i].CreatedAt.After(lobbies[j].CreatedAt)
+	})
+	return lobbies
 }
 
 // SetHostConnected marks the host as connected to relay
@@ -139,8 +345,9 @@ func (s *Store) SetHostConnected(id string, connected bool) error {
 	if !ok {
 		return fmt.Errorf("session not found")
 	}
-	session.HostConnected = connected
-	return nil
+	return s.changeLocked(session, func(session *Session) {
+		session.HostConnected = connected
+	})
 }
 
 // SetJoinConnected marks the joiner as connected to relay
@@ -152,8 +359,105 @@ func (s *Store) SetJoinConnected(id string, connected bool) error {
 	if !ok {
 		return fmt.Errorf("session not found")
 	}
-	session.JoinConnected = connected
-	return nil
+	return s.changeLocked(session, func(session *Session) {
+		session.JoinConnected = connected
+	})
+}
+
+// SetRelayNode records the relay node the session was assigned to
+func (s *Store) SetRelayNode(id, node string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return fmt.Errorf("session not found")
+	}
+	return s.changeLocked(session, func(session *Session) {
+		session.RelayNode = node
+	})
+}
+
+// Extend pushes a session's expiry back to a full TTL from now and returns
+// the new expiry
+func (s *Store) Extend(id string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return time.Time{}, fmt.Errorf("session not found")
+	}
+	expires := time.Now().Add(s.ttl)
+	err := s.changeLocked(session, func(session *Session) {
+		session.ExpiresAt = expires
+	})
+	return expires, err
+}
+
+// RegenerateCode gives a session a new join code and returns it. The old
+// code stops working; players already admitted keep their tokens.
+func (s *Store) RegenerateCode(id string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return "", fmt.Errorf("session not found")
+	}
+	code, err := s.codes.Generate(func(code string) bool {
+		_, taken := s.byCodes[code]
+		return taken
+	})
+	if err != nil {
+		return "", err
+	}
+
+	old := session.Code
+	err = s.changeLocked(session, func(session *Session) {
+		session.Code = code
+	})
+	if err != nil {
+		return "", err
+	}
+	delete(s.byCodes, old)
+	s.byCodes[code] = id
+	return code, nil
+}
+
+// Kick removes joiner joinerID from a session, or every joiner if joinerID
+// is zero, revoking their tokens and freeing their slots. It returns the IDs
+// of the joiners removed.
+func (s *Store) Kick(id string, joinerID uint32) ([]uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return nil, fmt.Errorf("session not found")
+	}
+
+	var kept []*Joiner
+	var kicked []uint32
+	for _, j := range session.Joiners {
+		if joinerID == 0 || j.ID == joinerID {
+			kicked = append(kicked, j.ID)
+		} else {
+			kept = append(kept, j)
+		}
+	}
+	if joinerID != 0 && len(kicked) == 0 {
+		return nil, ErrJoinerNotFound
+	}
+
+	err := s.changeLocked(session, func(session *Session) {
+		session.Joiners = kept
+		session.JoinConnected = len(kept) > 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	return kicked, nil
 }
 
 // Delete removes a session
@@ -164,6 +468,11 @@ func (s *Store) Delete(id string) {
 	if session, ok := s.sessions[id]; ok {
 		delete(s.byCodes, session.Code)
 		delete(s.sessions, id)
+		if s.journal != nil {
+			if err := s.journal.remove(id); err != nil {
+				log.Printf("Failed to record deletion of session %s: %v", id, err)
+			}
+		}
 	}
 }
 
@@ -181,52 +490,95 @@ func (s *Store) ValidateToken(sessionID, token, role string) bool {
 	case "host":
 		return session.HostToken == token
 	case "joiner":
-		return session.JoinToken == token
+		for _, j := range session.Joiners {
+			if j.Token == token {
+				return true
+			}
+		}
+		return false
+	case "spectator":
+		if !session.AllowSpectators {
+			return false
+		}
+		for _, sp := range session.Spectators {
+			if sp.Token == token {
+				return true
+			}
+		}
+		return false
 	default:
 		return false
 	}
 }
 
-func (s *Store) cleanupLoop() {
-	ticker := time.NewTicker(time.Minute)
-	for range ticker.C {
-		s.cleanup()
-	}
-}
-
-func (s *Store) cleanup() {
+// Expire removes sessions past their TTL and returns how many it removed
+func (s *Store) Expire() int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	now := time.Now()
+	n := 0
 	for id, session := range s.sessions {
 		if now.After(session.ExpiresAt) {
 			delete(s.byCodes, session.Code)
 			delete(s.sessions, id)
+			n++
 		}
 	}
+	if s.journal != nil {
+		if err := s.journal.expired(s.sessions); err != nil {
+			log.Printf("Failed to compact session log: %v", err)
+		}
+	}
+	return n
 }
 
-func generateID(length int) (string, error) {
-	b := make([]byte, length)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
+// Close stops the store's expiry loop
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+func (s *Store) cleanupLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.Expire()
+		}
 	}
-	return fmt.Sprintf("%x", b), nil
 }
 
-func generateCode() (string, error) {
-	// Generate 4 alphanumeric characters (common letters + numbers)
-	const chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, 4)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
+// saveLocked records a new session in the journal
+func (s *Store) saveLocked(session *Session) error {
+	if s.journal == nil {
+		return nil
+	}
+	if err := s.journal.put(session); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
 	}
+	return nil
+}
 
-	code := make([]byte, 4)
-	for i := 0; i < 4; i++ {
-		code[i] = chars[int(b[i])%len(chars)]
+// changeLocked applies change to session and records the result, undoing
+// the change if it cannot be recorded
+func (s *Store) changeLocked(session *Session, change func(*Session)) error {
+	prev := *session
+	change(session)
+	if err := s.saveLocked(session); err != nil {
+		*session = prev
+		return err
 	}
+	return nil
+}
 
-	return fmt.Sprintf("SFO-%s", string(code)), nil
+func generateID(length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
 }
@@ -0,0 +1,62 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJoinerIDsAreNeverReused(t *testing.T) {
+	tests := []struct {
+		name string
+		kick func(s *Store, id string) error // after joiners 1 and 2 joined
+		want uint32                          // ID of the next joiner
+	}{
+		{"no kick", func(*Store, string) error { return nil }, 3},
+		{"kick last", func(s *Store, id string) error { _, err := s.Kick(id, 2); return err }, 3},
+		{"kick all", func(s *Store, id string) error { _, err := s.Kick(id, 0); return err }, 3},
+		{"kick first", func(s *Store, id string) error { _, err := s.Kick(id, 1); return err }, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore(time.Minute)
+			sess, err := s.Create()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for want := uint32(1); want <= 2; want++ {
+				_, j, err := s.Join(sess.Code)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if j.ID != want {
+					t.Fatalf("joiner ID = %d, want %d", j.ID, want)
+				}
+			}
+
+			if err := tt.kick(s, sess.ID); err != nil {
+				t.Fatal(err)
+			}
+			_, j, err := s.Join(sess.Code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if j.ID != tt.want {
+				t.Errorf("joiner ID after kick = %d, want %d", j.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestKickUnknownJoiner(t *testing.T) {
+	s := newStore(time.Minute)
+	sess, err := s.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Kick(sess.ID, 5); err != ErrJoinerNotFound {
+		t.Errorf("Kick of unknown joiner = %v, want ErrJoinerNotFound", err)
+	}
+	if _, err := s.Kick("nope", 0); err == nil {
+		t.Error("Kick of unknown session succeeded")
+	}
+}